@@ -0,0 +1,138 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tstranex/u2f"
+)
+
+// ListUsers returns, sorted, the users with a registration, recovery code or
+// TOTP.
+func ListUsers(ctx context.Context) (_ []string, err error) {
+	ctx, end := startStorage(ctx, "listUsers")
+	defer func() { end(err) }()
+
+	return Storage.Users(ctx)
+}
+
+// RenameRegistration sets the Name of the user's registration with the
+// given ID.
+func RenameRegistration(ctx context.Context, userIdentity string, id int64, name string) (_ *Registration, err error) {
+	ctx, start := begin(ctx, AuditRename, userIdentity)
+	defer func() { finish(ctx, AuditRename, userIdentity, id, start, err) }()
+
+	return Storage.UpdateRegistration(ctx, userIdentity, id, func(regi *Registration) error {
+		regi.Name = name
+		return nil
+	})
+}
+
+// UpdateProperties merges props into the Properties of the user's
+// registration with the given ID: a property set to nil is deleted, and the
+// others are set.
+func UpdateProperties(ctx context.Context, userIdentity string, id int64, props map[string]interface{}) (_ *Registration, err error) {
+	ctx, start := begin(ctx, AuditProperties, userIdentity)
+	defer func() { finish(ctx, AuditProperties, userIdentity, id, start, err) }()
+
+	return Storage.UpdateRegistration(ctx, userIdentity, id, func(regi *Registration) error {
+		merged := map[string]interface{}{}
+		if len(regi.Properties) > 0 {
+			if err := json.Unmarshal(regi.Properties, &merged); err != nil {
+				return fmt.Errorf("properties error: %v", err)
+			}
+		}
+		for k, v := range props {
+			if v == nil {
+				delete(merged, k)
+			} else {
+				merged[k] = v
+			}
+		}
+		if len(merged) == 0 {
+			regi.Properties = nil
+			return nil
+		}
+		b, err := json.Marshal(merged)
+		if err != nil {
+			return fmt.Errorf("properties error: %v", err)
+		}
+		regi.Properties = b
+		return nil
+	})
+}
+
+// RevokeRegistration marks the user's registration with the given ID as
+// revoked: it is kept, e.g. to show its attestation, but no longer signs.
+// Revoking a revoked registration keeps the time it was first revoked.
+func RevokeRegistration(ctx context.Context, userIdentity string, id int64) (_ *Registration, err error) {
+	ctx, start := begin(ctx, AuditRevocation, userIdentity)
+	defer func() { finish(ctx, AuditRevocation, userIdentity, id, start, err) }()
+
+	return Storage.UpdateRegistration(ctx, userIdentity, id, func(regi *Registration) error {
+		if regi.Revoked.IsZero() {
+			regi.Revoked = time.Now()
+		}
+		return nil
+	})
+}
+
+// ResetCounter sets the Counter of the user's registration with the given
+// ID back to zero, e.g. after the key has been reset.  Responses replayed
+// from before the reset are then accepted again, so only reset the counter
+// of a key known to be in the hands of its user.
+func ResetCounter(ctx context.Context, userIdentity string, id int64) (_ *Registration, err error) {
+	ctx, start := begin(ctx, AuditCounterReset, userIdentity)
+	defer func() { finish(ctx, AuditCounterReset, userIdentity, id, start, err) }()
+
+	return Storage.UpdateRegistration(ctx, userIdentity, id, func(regi *Registration) error {
+		regi.Counter = 0
+		return nil
+	})
+}
+
+// PurgeExpiredChallenges deletes the challenges older than ChallengeTimeout,
+// which can no longer be answered, and returns how many it deleted.
+// Answered challenges are deleted as they are answered; the others are only
+// replaced by the user's next challenge, so run this e.g. from a daily cron.
+func PurgeExpiredChallenges(ctx context.Context) (n int, err error) {
+	ctx, end := startStorage(ctx, "purgeChallenges")
+	defer func() { end(err) }()
+
+	before := time.Now().Add(-time.Duration(ChallengeTimeout) * time.Millisecond)
+	for _, kind := range []string{ChallengeRegister, ChallengeSign} {
+		deleted, err := Storage.DeleteChallenges(ctx, kind, before)
+		n += deleted
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// AttestationCertificate returns the certificate the key presented when it
+// was registered, identifying its make and model.
+func (regi *Registration) AttestationCertificate() (*x509.Certificate, error) {
+	if len(regi.U2FRegistrationBytes) == 0 {
+		return nil, errors.New("no registration data")
+	}
+
+	var reg u2f.Registration
+	if err := reg.UnmarshalBinary(regi.U2FRegistrationBytes); err != nil {
+		return nil, fmt.Errorf("UnmarshalBinary error: %v", err)
+	}
+	if reg.AttestationCert == nil {
+		return nil, errors.New("no attestation certificate")
+	}
+	return reg.AttestationCert, nil
+}
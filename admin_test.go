@@ -0,0 +1,183 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+// registrationOf returns the user's registration with the key handle, or
+// fails the test.
+func registrationOf(t *testing.T, regis []*Registration, keyHandle string) *Registration {
+	for _, regi := range regis {
+		if base64.RawURLEncoding.EncodeToString(regi.KeyHandle) == keyHandle {
+			return regi
+		}
+	}
+	t.Fatalf("Expected a registration for key handle %v", keyHandle)
+	return nil
+}
+
+func TestRevokeRegistration(t *testing.T) {
+	ctx, done := newConsistentContext(t)
+	defer done()
+
+	a, aReq := registerAuthenticator(t, ctx, "bob")
+	b, _ := registerAuthenticator(t, ctx, "bob")
+	regis, err := ListRegistrations(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := registrationOf(t, regis, aReq.KeyHandle).ID
+
+	// A response of a, to a challenge made before it was revoked.
+	reqs, err := NewSignChallenge(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := a.SignAny(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, err := RevokeRegistration(ctx, "bob", id)
+	if err != nil || revoked.Revoked.IsZero() {
+		t.Fatalf("Expected the registration to be revoked, got %+v, %v", revoked, err)
+	}
+	err = Sign(ctx, "bob", *resp)
+	expectVerificationError(t, err)
+	if !errors.Is(err, ErrRevoked) {
+		t.Errorf("Expected ErrRevoked, got %v", err)
+	}
+
+	// The revoked key is no longer asked to sign; the other still signs.
+	reqs, err = NewSignChallenge(ctx, "bob")
+	if err != nil || len(reqs) != 1 || reqs[0].KeyHandle == aReq.KeyHandle {
+		t.Fatalf("Expected a request for the other key only, got %v, %v", reqs, err)
+	}
+	resp, err = b.SignAny(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Sign(ctx, "bob", *resp); err != nil {
+		t.Errorf("Expected the other key to sign, got %v", err)
+	}
+
+	// Revoking again keeps the time it was revoked.
+	again, err := RevokeRegistration(ctx, "bob", id)
+	if err != nil || !again.Revoked.Equal(revoked.Revoked) {
+		t.Errorf("Expected the revocation time to be kept, got %+v, %v", again, err)
+	}
+	if _, err := RevokeRegistration(ctx, "alice", id); err != ErrNoSuchRegistration {
+		t.Errorf("Expected another user's registration to be refused, got %v", err)
+	}
+}
+
+func TestRenameResetCounter(t *testing.T) {
+	ctx, done := newConsistentContext(t)
+	defer done()
+
+	a, req := registerAuthenticator(t, ctx, "bob")
+	regi, err := Authenticate(ctx, "bob", sign(t, a, req))
+	if err != nil || regi.Counter == 0 {
+		t.Fatalf("Authenticate: %+v, %v", regi, err)
+	}
+
+	if regi, err = RenameRegistration(ctx, "bob", regi.ID, "backup key"); err != nil || regi.Name != "backup key" {
+		t.Errorf("Expected the registration to be renamed, got %+v, %v", regi, err)
+	}
+	if regi, err = ResetCounter(ctx, "bob", regi.ID); err != nil || regi.Counter != 0 || regi.Name != "backup key" {
+		t.Errorf("Expected the counter to be reset, got %+v, %v", regi, err)
+	}
+
+	// A counter above zero is accepted again.
+	a.Counter = 0
+	reqs, err := NewSignChallenge(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := a.SignAny(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Sign(ctx, "bob", *resp); err != nil {
+		t.Errorf("Expected a counter above zero after the reset, got %v", err)
+	}
+}
+
+func TestUpdateProperties(t *testing.T) {
+	ctx, done := newConsistentContext(t)
+	defer done()
+
+	registerAuthenticator(t, ctx, "bob")
+	id := mustList(t, ctx, "bob")[0].ID
+
+	regi, err := UpdateProperties(ctx, "bob", id, map[string]interface{}{"name": "yubikey", "n": 1})
+	if err != nil || string(regi.Properties) != `{"n":1,"name":"yubikey"}` {
+		t.Errorf("Expected the properties to be set, got %s, %v", regi.Properties, err)
+	}
+	regi, err = UpdateProperties(ctx, "bob", id, map[string]interface{}{"n": nil, "os": "linux"})
+	if err != nil || string(regi.Properties) != `{"name":"yubikey","os":"linux"}` {
+		t.Errorf("Expected the properties to be merged, got %s, %v", regi.Properties, err)
+	}
+	regi, err = UpdateProperties(ctx, "bob", id, map[string]interface{}{"name": nil, "os": nil})
+	if err != nil || regi.Properties != nil {
+		t.Errorf("Expected no properties left, got %s, %v", regi.Properties, err)
+	}
+	if _, err := UpdateProperties(ctx, "alice", id, nil); err != ErrNoSuchRegistration {
+		t.Errorf("Expected ErrNoSuchRegistration, got %v", err)
+	}
+}
+
+func TestAdminUsersAndChallenges(t *testing.T) {
+	ctx, done := newConsistentContext(t)
+	defer done()
+
+	registerAuthenticator(t, ctx, "bob")
+	registerAuthenticator(t, ctx, "alice")
+	if users, err := ListUsers(ctx); err != nil || len(users) != 2 || users[0] != "alice" {
+		t.Errorf("Expected alice and bob, got %v, %v", users, err)
+	}
+
+	// registerAuthenticator leaves a sign challenge per user, which are not
+	// yet expired.
+	if n, err := PurgeExpiredChallenges(ctx); err != nil || n != 0 {
+		t.Errorf("Expected no expired challenges, got %v, %v", n, err)
+	}
+
+	old := ChallengeTimeout
+	ChallengeTimeout = -int(time.Minute / time.Millisecond)
+	defer func() { ChallengeTimeout = old }()
+	if n, err := PurgeExpiredChallenges(ctx); err != nil || n != 2 {
+		t.Errorf("Expected 2 expired challenges, got %v, %v", n, err)
+	}
+	if _, err := getChallenge(ctx, ChallengeSign, "bob"); err != ErrNoChallenge {
+		t.Errorf("Expected the challenge to be purged, got %v", err)
+	}
+}
+
+func TestAttestationCertificate(t *testing.T) {
+	ctx, done := newConsistentContext(t)
+	defer done()
+
+	registerAuthenticator(t, ctx, "bob")
+	regis, err := ListRegistrations(ctx, "bob")
+	if err != nil || len(regis) != 1 {
+		t.Fatalf("Expected a registration, got %v, %v", regis, err)
+	}
+	cert, err := regis[0].AttestationCertificate()
+	if err != nil || cert.Subject.String() == "" {
+		t.Errorf("Expected an attestation certificate, got %v, %v", cert, err)
+	}
+
+	if _, err := (&Registration{}).AttestationCertificate(); err == nil {
+		t.Error("Expected an error without registration data")
+	}
+}
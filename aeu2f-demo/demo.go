@@ -1,24 +1,24 @@
-package demo
+// Command aeu2f-demo is an App Engine app demonstrating aeu2f in a browser.
+package main
 
 
 import (
-  "log"
-  "fmt"
   "net/http"
-  "encoding/json"
   "io/ioutil"
 
-  "appengine"
-  "appengine/datastore"
-  "github.com/brianmhunt/aeu2f-go"
+  "google.golang.org/appengine"
 
-  "github.com/tstranex/u2f"
+  "github.com/brianmhunt/aeu2f-go"
+  "github.com/brianmhunt/aeu2f-go/aeu2fhttp"
 )
 
-// Handle /register/USERNAME and /auth/USERNAME, respectively.
+// Handle /register/USERNAME, /auth/USERNAME, /list/USERNAME and
+// /delete/USERNAME, respectively.
 const registerURLPrefix = "/register/"
 const authURLPrefix = "/auth/"
 const listURLPrefix = "/list/"
+const deleteURLPrefix = "/delete/"
+const openAPIURL = "/openapi.json"
 
 
 // HTTP request wrappers
@@ -129,177 +129,32 @@ func fileHandler(w http.ResponseWriter, r *http.Request) {
   w.Write([]byte(b))
 }
 
-// --- setupUserContext ---
-//
-func setupUserContext(r *http.Request, prefix string) (appengine.Context, string) {
-  // Set up the aeu2f variables.
-  aeu2f.AppID = getAppID(r)
-  aeu2f.TrustedFacets = []string{aeu2f.AppID}
-
-  // Create an AppEngine context
-  ctx := appengine.NewContext(r)
-
-  // Get the user identity
-  userIdentity := r.URL.Path[len(prefix):]
-  return ctx, userIdentity
-}
-
-
-// --- createRegistrationChallenge ---
-//
-func createRegistrationChallenge(ctx appengine.Context, userIdentity string) (interface{}, error) {
-  req, err := aeu2f.NewRegistrationChallenge(ctx, userIdentity)
-  if err != nil {
-    return nil, fmt.Errorf("Registration Challenge error: %v", err)
-  }
-
-	log.Printf("Created Registration Challenge: %+v", req)
-  return req, nil
-}
-
-
-// --- testRegistrationResponse ---
-//
-func testRegistrationResponse(ctx appengine.Context, userIdentity string, regResp u2f.RegisterResponse) (interface{}, error) {
-	if err := aeu2f.StoreResponse(ctx, userIdentity, regResp); err != nil {
-    return nil, fmt.Errorf("Registration error: %v", err)
-  }
-  return "success", nil
-}
-
-
-// --- registerHandler ---
-//
-func registerHandler(w http.ResponseWriter, r *http.Request) {
-  ctx, userIdentity := setupUserContext(r, registerURLPrefix)
-  if userIdentity == "" {
-    http.Error(w, "User identity not provided", http.StatusBadRequest)
-  }
-
-  var err error
-  var ret interface{}
-
-  switch r.Method {
-
-  case "GET":
-    ret, err = createRegistrationChallenge(ctx, userIdentity)
-
-  case "POST":
-  	var regResp u2f.RegisterResponse
-  	if err := json.NewDecoder(r.Body).Decode(&regResp); err != nil {
-  		http.Error(w, "invalid response: "+err.Error(), http.StatusBadRequest)
-  		return
-  	}
-
-  	log.Printf("Registration Response: %+v", regResp)
-
-    ret, err = testRegistrationResponse(ctx, userIdentity, regResp)
-  default:
-    http.Error(w, "Method not supported.", http.StatusBadRequest)
-  }
-
-  if err != nil {
-    http.Error(w, "Error: %v" + err.Error(), http.StatusBadRequest)
-  }
-
-  json.NewEncoder(w).Encode(ret)
-}
-
-// --- createAuthChallenge ---
-//
-func createAuthChallenge(ctx appengine.Context, userIdentity string) (interface{}, error) {
-  reqs, err := aeu2f.NewSignChallenge(ctx, userIdentity)
-  if err != nil {
-    return nil, fmt.Errorf("Auth Challenge error: %v", err)
-  }
-
-	log.Printf("Created Auth Challenge(s): %+v", reqs)
-  return reqs, nil
+// --- withAppID ---
+// Set the AppID of the request's context before handing it to h.  It is per
+// request, since concurrent requests may come through different addresses.
+func withAppID(h http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    appID := getAppID(r)
+    ctx := aeu2f.WithAppID(r.Context(), appID, []string{appID})
+    h.ServeHTTP(w, r.WithContext(ctx))
+  })
 }
 
-// --- testAuthResponse ---
-func testAuthResponse(ctx appengine.Context, userIdentity string, signResp u2f.SignResponse) (interface{}, error) {
-
-  if err := aeu2f.Sign(ctx, userIdentity, signResp); err != nil {
-    return nil, fmt.Errorf("Sign failure: %v", err)
-  }
-
-  return "success", nil
-}
-
-
-// --- authHandler ---
+// --- main ---
 //
-func authHandler(w http.ResponseWriter, r *http.Request) {
-  ctx, userIdentity := setupUserContext(r, authURLPrefix)
-  if userIdentity == "" {
-    http.Error(w, "User identity not provided", http.StatusBadRequest)
-  }
-  var err error
-  var ret interface{}
-
-  switch r.Method {
-
-  case "GET":
-    ret, err = createAuthChallenge(ctx, userIdentity)
-
-  case "POST":
-  	var signResp u2f.SignResponse
-  	if err := json.NewDecoder(r.Body).Decode(&signResp); err != nil {
-  		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
-  		return
-  	}
-
-  	log.Printf("Auth Response: %+v", signResp)
-
-    ret, err = testAuthResponse(ctx, userIdentity, signResp)
-  default:
-    http.Error(w, "Method not supported.", http.StatusBadRequest)
-  }
-
-  if err != nil {
-    log.Printf("testAuthResponse error: %+v", err)
-    http.Error(w, "Error: %v" + err.Error(), http.StatusBadRequest)
-  }
-
-  json.NewEncoder(w).Encode(ret)
-}
-
-// --- listHandler ---
-// Return a list of the keys for the given user.
-func listHandler(w http.ResponseWriter, r *http.Request) {
-  ctx, userIdentity := setupUserContext(r, listURLPrefix)
-  if userIdentity == "" {
-    http.Error(w, "User identity not provided", http.StatusBadRequest)
-  }
-
-  reqs := []aeu2f.Registration{}
-  q := datastore.NewQuery("Registration").
-    Ancestor(aeu2f.MakeParentKey(ctx)).
-    Filter("UserIdentity =", userIdentity)
-
-	for t := q.Run(ctx) ; ; {
-		var regi aeu2f.Registration
-		if _, err := t.Next(&regi); err == datastore.Done {
-			break
-		} else if err != nil {
-  		http.Error(w, "datastore error: "+err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		reqs = append(reqs, regi)
-	}
+func main() {
+    http.HandleFunc("/", fileHandler)
 
-  json.NewEncoder(w).Encode(reqs)
-}
+    // The user identity is taken from the path, e.g. /register/USERNAME.
+    u2fHandler := withAppID(aeu2fhttp.New(aeu2fhttp.PathIdentity))
+    http.Handle(registerURLPrefix, u2fHandler)
+    http.Handle(authURLPrefix, u2fHandler)
+    http.Handle(listURLPrefix, u2fHandler)
+    http.Handle(deleteURLPrefix, u2fHandler)
+    http.Handle(openAPIURL, u2fHandler)
 
-// --- init ---
-//
-func init() {
-    http.HandleFunc("/", fileHandler)
+    // Prometheus metrics, for admins only; see app.yaml.
+    http.Handle("/metrics", &aeu2f.MetricsHandler{})
 
-    http.HandleFunc(registerURLPrefix, registerHandler)
-    http.HandleFunc(authURLPrefix, authHandler)
-    http.HandleFunc(listURLPrefix, listHandler)
-    // TODO: Delete.
+    appengine.Main()
 }
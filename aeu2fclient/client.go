@@ -0,0 +1,249 @@
+//
+// Package aeu2fclient is a client of the aeu2fhttp register, authenticate,
+// list and delete API, as served by aeu2f-demo and aeu2fd, e.g. for command
+// line tools and integration tests.
+//
+// Each ceremony is a GET of a challenge and a POST of the response; with an
+// Authenticator, e.g. the software one of aeu2ftest, the client answers the
+// challenge itself:
+// 	c, err := aeu2fclient.New("https://u2f.example.com/u2f")
+// 	a := aeu2ftest.New()
+// 	err = c.RegisterWith(ctx, "bob", a)
+// 	token, err := c.AuthenticateWith(ctx, "bob", a)
+//
+// License: MIT
+//
+package aeu2fclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brianmhunt/aeu2f-go"
+	"github.com/brianmhunt/aeu2f-go/aeu2fhttp"
+	"github.com/tstranex/u2f"
+)
+
+// Authenticator answers U2F challenges, as a security key would.
+// *aeu2ftest.Authenticator is one.
+type Authenticator interface {
+	Register(req *u2f.RegisterRequest) (*u2f.RegisterResponse, error)
+	SignAny(reqs []*u2f.SignRequest) (*u2f.SignResponse, error)
+}
+
+// Error is the error response of a request.  Err, if known, is the aeu2f
+// error it stands for: aeu2f.ErrNoChallenge, aeu2f.ErrNoSuchRegistration, an
+// *aeu2f.VerificationError or an *aeu2f.LockedOutError, so that e.g.
+// errors.Is(err, aeu2f.ErrNoChallenge) tells why a request failed.
+type Error struct {
+	StatusCode int
+	Message    string
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("aeu2fclient: %v %v", e.StatusCode, e.Message)
+}
+
+// Unwrap returns Err.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Client makes the requests of the API.
+type Client struct {
+	// BaseURL is where the routes are served, e.g. https://example.com/u2f
+	// for https://example.com/u2f/register/USER.
+	BaseURL *url.URL
+
+	// HTTPClient makes the requests.  New gives it a cookie jar, so that a
+	// session set by the server is kept between requests.
+	HTTPClient *http.Client
+}
+
+// New returns a Client of the API at baseURL, whose requests keep cookies.
+func New(baseURL string) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("aeu2fclient: base URL %q is not http or https", baseURL)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{BaseURL: u, HTTPClient: &http.Client{Jar: jar}}, nil
+}
+
+// --- url ---
+// Return the URL of the route for the user.  The user is empty when the
+// server identifies users otherwise, e.g. by their session.
+func (c *Client) url(route, userIdentity string) string {
+	u := *c.BaseURL
+	base := strings.TrimSuffix(u.EscapedPath(), "/")
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + route + "/" + userIdentity
+	u.RawPath = base + "/" + route + "/" + url.PathEscape(userIdentity)
+	return u.String()
+}
+
+// --- do ---
+// Make the request, with body as JSON if not nil, and decode the JSON
+// response into v if not nil.
+func (c *Client) do(ctx context.Context, method, target string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("aeu2fclient: invalid response: %v", err)
+	}
+	return nil
+}
+
+// --- responseError ---
+// Return the Error of an error response.
+func responseError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode, Message: resp.Status}
+	var body aeu2fhttp.Error
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&body); err == nil && body.Message != "" {
+		e.Message = body.Message
+	}
+
+	switch resp.StatusCode {
+	case http.StatusForbidden:
+		e.Err = &aeu2f.VerificationError{Op: "aeu2fclient", Err: errors.New(e.Message)}
+	case http.StatusConflict:
+		e.Err = aeu2f.ErrNoChallenge
+	case http.StatusNotFound:
+		e.Err = aeu2f.ErrNoSuchRegistration
+	case http.StatusTooManyRequests:
+		until := time.Now()
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			until = until.Add(time.Duration(secs) * time.Second)
+		}
+		e.Err = &aeu2f.LockedOutError{Until: until}
+	}
+	return e
+}
+
+// RegisterChallenge returns a new registration challenge for the user.
+func (c *Client) RegisterChallenge(ctx context.Context, userIdentity string) (*u2f.RegisterRequest, error) {
+	var req u2f.RegisterRequest
+	if err := c.do(ctx, "GET", c.url("register", userIdentity), nil, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// Register sends the response to the user's registration challenge.
+func (c *Client) Register(ctx context.Context, userIdentity string, resp *u2f.RegisterResponse) error {
+	return c.do(ctx, "POST", c.url("register", userIdentity), resp, nil)
+}
+
+// RegisterWith registers a new key of a for the user: it gets a challenge,
+// and sends a's response to it.
+func (c *Client) RegisterWith(ctx context.Context, userIdentity string, a Authenticator) error {
+	req, err := c.RegisterChallenge(ctx, userIdentity)
+	if err != nil {
+		return err
+	}
+	resp, err := a.Register(req)
+	if err != nil {
+		return fmt.Errorf("aeu2fclient: register: %v", err)
+	}
+	return c.Register(ctx, userIdentity, resp)
+}
+
+// SignChallenge returns new sign challenges for the user, one per key.
+func (c *Client) SignChallenge(ctx context.Context, userIdentity string) ([]*u2f.SignRequest, error) {
+	var reqs []*u2f.SignRequest
+	if err := c.do(ctx, "GET", c.url("auth", userIdentity), nil, &reqs); err != nil {
+		return nil, err
+	}
+	return reqs, nil
+}
+
+// Authenticate sends the response to a sign challenge of the user, and
+// returns the token the server issues, or "" if it issues none.
+func (c *Client) Authenticate(ctx context.Context, userIdentity string, resp *u2f.SignResponse) (string, error) {
+	var raw json.RawMessage
+	if err := c.do(ctx, "POST", c.url("auth", userIdentity), resp, &raw); err != nil {
+		return "", err
+	}
+	var tok aeu2fhttp.TokenResponse
+	if json.Unmarshal(raw, &tok) != nil {
+		// "success", without a token.
+		return "", nil
+	}
+	return tok.Token, nil
+}
+
+// AuthenticateWith authenticates the user with a key of a, as Authenticate.
+func (c *Client) AuthenticateWith(ctx context.Context, userIdentity string, a Authenticator) (string, error) {
+	reqs, err := c.SignChallenge(ctx, userIdentity)
+	if err != nil {
+		return "", err
+	}
+	resp, err := a.SignAny(reqs)
+	if err != nil {
+		return "", fmt.Errorf("aeu2fclient: sign: %v", err)
+	}
+	return c.Authenticate(ctx, userIdentity, resp)
+}
+
+// List returns the registrations of the user, as the server lists them:
+// without their key material.
+func (c *Client) List(ctx context.Context, userIdentity string) ([]*aeu2fhttp.Registration, error) {
+	var regis []*aeu2fhttp.Registration
+	if err := c.do(ctx, "GET", c.url("list", userIdentity), nil, &regis); err != nil {
+		return nil, err
+	}
+	return regis, nil
+}
+
+// Delete deletes the user's registration with the given ID.
+func (c *Client) Delete(ctx context.Context, userIdentity string, id int64) error {
+	u := c.url("delete", userIdentity) + "?id=" + strconv.FormatInt(id, 10)
+	return c.do(ctx, "DELETE", u, nil, nil)
+}
@@ -0,0 +1,198 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2fclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brianmhunt/aeu2f-go"
+	"github.com/brianmhunt/aeu2f-go/aeu2fhttp"
+	"github.com/brianmhunt/aeu2f-go/aeu2ftest"
+	"github.com/brianmhunt/aeu2f-go/aeu2ftoken"
+)
+
+// newTestServer serves h under /u2f, over a new MemoryStore, and returns a
+// Client of it.
+func newTestServer(t *testing.T, h *aeu2fhttp.Handler) *Client {
+	oldStorage, oldAppID, oldFacets := aeu2f.Storage, aeu2f.AppID, aeu2f.TrustedFacets
+	aeu2f.Storage = aeu2f.NewMemoryStore()
+	aeu2f.AppID, aeu2f.TrustedFacets = "https://example.com", []string{"https://example.com"}
+	t.Cleanup(func() { aeu2f.Storage, aeu2f.AppID, aeu2f.TrustedFacets = oldStorage, oldAppID, oldFacets })
+
+	h.Context = func(r *http.Request) context.Context { return r.Context() }
+	mux := http.NewServeMux()
+	mux.Handle("/u2f/", http.StripPrefix("/u2f", h))
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "user", Value: r.URL.Query().Get("user")})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL + "/u2f/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCeremonies(t *testing.T) {
+	h := aeu2fhttp.New(nil)
+	c := newTestServer(t, h)
+	ctx := context.Background()
+
+	// The user is escaped in the path.
+	const user = "bob@example.com/work"
+	a := aeu2ftest.New()
+	if err := c.RegisterWith(ctx, user, a); err != nil {
+		t.Fatal(err)
+	}
+	regis, err := c.List(ctx, user)
+	if err != nil || len(regis) != 1 {
+		t.Fatalf("Expected one registration, got %v, %v", regis, err)
+	}
+	if regis, err := c.List(ctx, "bob@example.com"); err != nil || len(regis) != 0 {
+		t.Errorf("Expected no registrations of another user, got %v, %v", regis, err)
+	}
+
+	if token, err := c.AuthenticateWith(ctx, user, a); err != nil || token != "" {
+		t.Errorf("Expected success without a token, got %q, %v", token, err)
+	}
+	key, err := aeu2ftoken.NewHMACKey("k", []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Tokens = &aeu2ftoken.Issuer{Key: key}
+	if token, err := c.AuthenticateWith(ctx, user, a); err != nil || token == "" {
+		t.Errorf("Expected a token, got %q, %v", token, err)
+	}
+
+	if err := c.Delete(ctx, user, regis[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if regis, err := c.List(ctx, user); err != nil || len(regis) != 0 {
+		t.Errorf("Expected no registrations, got %v, %v", regis, err)
+	}
+	err = c.Delete(ctx, user, regis[0].ID)
+	if !errors.Is(err, aeu2f.ErrNoSuchRegistration) {
+		t.Errorf("Expected ErrNoSuchRegistration, got %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := newTestServer(t, aeu2fhttp.New(nil))
+	ctx := context.Background()
+
+	a := aeu2ftest.New()
+	req, err := c.RegisterChallenge(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := a.Register(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Register(ctx, "bob", resp); err != nil {
+		t.Fatal(err)
+	}
+	err = c.Register(ctx, "bob", resp)
+	if !errors.Is(err, aeu2f.ErrNoChallenge) {
+		t.Errorf("Expected ErrNoChallenge, got %v", err)
+	}
+	var cerr *Error
+	if !errors.As(err, &cerr) || cerr.StatusCode != http.StatusConflict || cerr.Message != aeu2f.ErrNoChallenge.Error() {
+		t.Errorf("Expected a 409 Error, got %#v", err)
+	}
+
+	// A bad signature fails, and with one free failure, locks bob out.
+	old := aeu2f.ThrottleFreeFailures
+	aeu2f.ThrottleFreeFailures = 1
+	t.Cleanup(func() { aeu2f.ThrottleFreeFailures = old })
+	reqs, err := c.SignChallenge(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sresp, err := a.SignAny(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sresp.SignatureData = sresp.SignatureData[:len(sresp.SignatureData)-4] + "AAAA"
+	_, err = c.Authenticate(ctx, "bob", sresp)
+	var verr *aeu2f.VerificationError
+	if !errors.As(err, &verr) {
+		t.Errorf("Expected a VerificationError, got %v", err)
+	}
+
+	_, err = c.AuthenticateWith(ctx, "bob", a)
+	var lerr *aeu2f.LockedOutError
+	if !errors.As(err, &lerr) || !lerr.Until.After(time.Now()) {
+		t.Errorf("Expected a LockedOutError, got %v", err)
+	}
+
+	if _, err := c.List(ctx, ""); !errors.As(err, &cerr) || cerr.StatusCode != http.StatusUnauthorized || cerr.Err != nil {
+		t.Errorf("Expected a 401 Error, got %#v", err)
+	}
+}
+
+func TestSessionIdentity(t *testing.T) {
+	c := newTestServer(t, aeu2fhttp.New(func(r *http.Request) (string, error) {
+		cookie, err := r.Cookie("user")
+		if err != nil {
+			return "", aeu2fhttp.ErrNoIdentity
+		}
+		return cookie.Value, nil
+	}))
+	ctx := context.Background()
+
+	a := aeu2ftest.New()
+	if err := c.RegisterWith(ctx, "", a); !errors.As(err, new(*Error)) {
+		t.Errorf("Expected an Error before logging in, got %v", err)
+	}
+
+	login := *c.BaseURL
+	login.Path, login.RawPath = "/login", ""
+	login.RawQuery = "user=bob"
+	resp, err := c.HTTPClient.Get(login.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if err := c.RegisterWith(ctx, "", a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AuthenticateWith(ctx, "", a); err != nil {
+		t.Error(err)
+	}
+	regis, err := c.List(ctx, "")
+	if err != nil || len(regis) != 1 {
+		t.Fatalf("Expected one registration, got %v, %v", regis, err)
+	}
+	if bobs, err := aeu2f.ListRegistrations(ctx, "bob"); err != nil || len(bobs) != 1 || bobs[0].ID != regis[0].ID {
+		t.Errorf("Expected bob's registration, got %+v", regis[0])
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, u := range []string{"", "example.com/u2f", "ftp://example.com", "http://%zz"} {
+		if _, err := New(u); err == nil {
+			t.Errorf("Expected an error for %q", u)
+		}
+	}
+
+	c, err := New("https://example.com/a%2Fb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := c.url("list", "x y/z"); u != "https://example.com/a%2Fb/list/x%20y%2Fz" {
+		t.Errorf("Unexpected URL %v", u)
+	}
+}
@@ -0,0 +1,973 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        v5.29.3
+// source: aeu2fpb/aeu2f.proto
+
+package aeu2fpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Device is a registered U2F device.
+type Device struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// key_handle is in websafe base64, without padding.
+	KeyHandle string                 `protobuf:"bytes,2,opt,name=key_handle,json=keyHandle,proto3" json:"key_handle,omitempty"`
+	Name      string                 `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	Counter   int64                  `protobuf:"varint,4,opt,name=counter,proto3" json:"counter,omitempty"`
+	Created   *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=created,proto3" json:"created,omitempty"`
+	// revoked is unset unless the device is revoked.
+	Revoked       *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=revoked,proto3" json:"revoked,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Device) Reset() {
+	*x = Device{}
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Device) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Device) ProtoMessage() {}
+
+func (x *Device) ProtoReflect() protoreflect.Message {
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Device.ProtoReflect.Descriptor instead.
+func (*Device) Descriptor() ([]byte, []int) {
+	return file_aeu2fpb_aeu2f_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Device) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Device) GetKeyHandle() string {
+	if x != nil {
+		return x.KeyHandle
+	}
+	return ""
+}
+
+func (x *Device) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Device) GetCounter() int64 {
+	if x != nil {
+		return x.Counter
+	}
+	return 0
+}
+
+func (x *Device) GetCreated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Created
+	}
+	return nil
+}
+
+func (x *Device) GetRevoked() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Revoked
+	}
+	return nil
+}
+
+// RegisterRequest is a U2F registration request.
+type RegisterRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	Challenge     string                 `protobuf:"bytes,2,opt,name=challenge,proto3" json:"challenge,omitempty"`
+	AppId         string                 `protobuf:"bytes,3,opt,name=app_id,json=appId,proto3" json:"app_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RegisterRequest) Reset() {
+	*x = RegisterRequest{}
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RegisterRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterRequest) ProtoMessage() {}
+
+func (x *RegisterRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterRequest.ProtoReflect.Descriptor instead.
+func (*RegisterRequest) Descriptor() ([]byte, []int) {
+	return file_aeu2fpb_aeu2f_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RegisterRequest) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *RegisterRequest) GetChallenge() string {
+	if x != nil {
+		return x.Challenge
+	}
+	return ""
+}
+
+func (x *RegisterRequest) GetAppId() string {
+	if x != nil {
+		return x.AppId
+	}
+	return ""
+}
+
+// SignRequest is a U2F sign request.
+type SignRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	Challenge     string                 `protobuf:"bytes,2,opt,name=challenge,proto3" json:"challenge,omitempty"`
+	KeyHandle     string                 `protobuf:"bytes,3,opt,name=key_handle,json=keyHandle,proto3" json:"key_handle,omitempty"`
+	AppId         string                 `protobuf:"bytes,4,opt,name=app_id,json=appId,proto3" json:"app_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SignRequest) Reset() {
+	*x = SignRequest{}
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SignRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SignRequest) ProtoMessage() {}
+
+func (x *SignRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SignRequest.ProtoReflect.Descriptor instead.
+func (*SignRequest) Descriptor() ([]byte, []int) {
+	return file_aeu2fpb_aeu2f_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SignRequest) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *SignRequest) GetChallenge() string {
+	if x != nil {
+		return x.Challenge
+	}
+	return ""
+}
+
+func (x *SignRequest) GetKeyHandle() string {
+	if x != nil {
+		return x.KeyHandle
+	}
+	return ""
+}
+
+func (x *SignRequest) GetAppId() string {
+	if x != nil {
+		return x.AppId
+	}
+	return ""
+}
+
+type BeginRegistrationRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	User          string                 `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BeginRegistrationRequest) Reset() {
+	*x = BeginRegistrationRequest{}
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BeginRegistrationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BeginRegistrationRequest) ProtoMessage() {}
+
+func (x *BeginRegistrationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BeginRegistrationRequest.ProtoReflect.Descriptor instead.
+func (*BeginRegistrationRequest) Descriptor() ([]byte, []int) {
+	return file_aeu2fpb_aeu2f_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *BeginRegistrationRequest) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+type BeginRegistrationResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Request       *RegisterRequest       `protobuf:"bytes,1,opt,name=request,proto3" json:"request,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BeginRegistrationResponse) Reset() {
+	*x = BeginRegistrationResponse{}
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BeginRegistrationResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BeginRegistrationResponse) ProtoMessage() {}
+
+func (x *BeginRegistrationResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BeginRegistrationResponse.ProtoReflect.Descriptor instead.
+func (*BeginRegistrationResponse) Descriptor() ([]byte, []int) {
+	return file_aeu2fpb_aeu2f_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *BeginRegistrationResponse) GetRequest() *RegisterRequest {
+	if x != nil {
+		return x.Request
+	}
+	return nil
+}
+
+// FinishRegistrationRequest holds the U2F registration response.
+type FinishRegistrationRequest struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	User             string                 `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	RegistrationData string                 `protobuf:"bytes,2,opt,name=registration_data,json=registrationData,proto3" json:"registration_data,omitempty"`
+	ClientData       string                 `protobuf:"bytes,3,opt,name=client_data,json=clientData,proto3" json:"client_data,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *FinishRegistrationRequest) Reset() {
+	*x = FinishRegistrationRequest{}
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FinishRegistrationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FinishRegistrationRequest) ProtoMessage() {}
+
+func (x *FinishRegistrationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FinishRegistrationRequest.ProtoReflect.Descriptor instead.
+func (*FinishRegistrationRequest) Descriptor() ([]byte, []int) {
+	return file_aeu2fpb_aeu2f_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *FinishRegistrationRequest) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+func (x *FinishRegistrationRequest) GetRegistrationData() string {
+	if x != nil {
+		return x.RegistrationData
+	}
+	return ""
+}
+
+func (x *FinishRegistrationRequest) GetClientData() string {
+	if x != nil {
+		return x.ClientData
+	}
+	return ""
+}
+
+type FinishRegistrationResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Device        *Device                `protobuf:"bytes,1,opt,name=device,proto3" json:"device,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FinishRegistrationResponse) Reset() {
+	*x = FinishRegistrationResponse{}
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FinishRegistrationResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FinishRegistrationResponse) ProtoMessage() {}
+
+func (x *FinishRegistrationResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FinishRegistrationResponse.ProtoReflect.Descriptor instead.
+func (*FinishRegistrationResponse) Descriptor() ([]byte, []int) {
+	return file_aeu2fpb_aeu2f_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *FinishRegistrationResponse) GetDevice() *Device {
+	if x != nil {
+		return x.Device
+	}
+	return nil
+}
+
+type BeginAuthenticationRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	User          string                 `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BeginAuthenticationRequest) Reset() {
+	*x = BeginAuthenticationRequest{}
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BeginAuthenticationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BeginAuthenticationRequest) ProtoMessage() {}
+
+func (x *BeginAuthenticationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BeginAuthenticationRequest.ProtoReflect.Descriptor instead.
+func (*BeginAuthenticationRequest) Descriptor() ([]byte, []int) {
+	return file_aeu2fpb_aeu2f_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *BeginAuthenticationRequest) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+type BeginAuthenticationResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Requests      []*SignRequest         `protobuf:"bytes,1,rep,name=requests,proto3" json:"requests,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BeginAuthenticationResponse) Reset() {
+	*x = BeginAuthenticationResponse{}
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BeginAuthenticationResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BeginAuthenticationResponse) ProtoMessage() {}
+
+func (x *BeginAuthenticationResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BeginAuthenticationResponse.ProtoReflect.Descriptor instead.
+func (*BeginAuthenticationResponse) Descriptor() ([]byte, []int) {
+	return file_aeu2fpb_aeu2f_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *BeginAuthenticationResponse) GetRequests() []*SignRequest {
+	if x != nil {
+		return x.Requests
+	}
+	return nil
+}
+
+// FinishAuthenticationRequest holds the U2F sign response.
+type FinishAuthenticationRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	User          string                 `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	KeyHandle     string                 `protobuf:"bytes,2,opt,name=key_handle,json=keyHandle,proto3" json:"key_handle,omitempty"`
+	SignatureData string                 `protobuf:"bytes,3,opt,name=signature_data,json=signatureData,proto3" json:"signature_data,omitempty"`
+	ClientData    string                 `protobuf:"bytes,4,opt,name=client_data,json=clientData,proto3" json:"client_data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FinishAuthenticationRequest) Reset() {
+	*x = FinishAuthenticationRequest{}
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FinishAuthenticationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FinishAuthenticationRequest) ProtoMessage() {}
+
+func (x *FinishAuthenticationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FinishAuthenticationRequest.ProtoReflect.Descriptor instead.
+func (*FinishAuthenticationRequest) Descriptor() ([]byte, []int) {
+	return file_aeu2fpb_aeu2f_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *FinishAuthenticationRequest) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+func (x *FinishAuthenticationRequest) GetKeyHandle() string {
+	if x != nil {
+		return x.KeyHandle
+	}
+	return ""
+}
+
+func (x *FinishAuthenticationRequest) GetSignatureData() string {
+	if x != nil {
+		return x.SignatureData
+	}
+	return ""
+}
+
+func (x *FinishAuthenticationRequest) GetClientData() string {
+	if x != nil {
+		return x.ClientData
+	}
+	return ""
+}
+
+// FinishAuthenticationResponse is the device that signed.
+type FinishAuthenticationResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Device        *Device                `protobuf:"bytes,1,opt,name=device,proto3" json:"device,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FinishAuthenticationResponse) Reset() {
+	*x = FinishAuthenticationResponse{}
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FinishAuthenticationResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FinishAuthenticationResponse) ProtoMessage() {}
+
+func (x *FinishAuthenticationResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FinishAuthenticationResponse.ProtoReflect.Descriptor instead.
+func (*FinishAuthenticationResponse) Descriptor() ([]byte, []int) {
+	return file_aeu2fpb_aeu2f_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *FinishAuthenticationResponse) GetDevice() *Device {
+	if x != nil {
+		return x.Device
+	}
+	return nil
+}
+
+type ListDevicesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	User          string                 `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDevicesRequest) Reset() {
+	*x = ListDevicesRequest{}
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDevicesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDevicesRequest) ProtoMessage() {}
+
+func (x *ListDevicesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDevicesRequest.ProtoReflect.Descriptor instead.
+func (*ListDevicesRequest) Descriptor() ([]byte, []int) {
+	return file_aeu2fpb_aeu2f_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ListDevicesRequest) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+type ListDevicesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Devices       []*Device              `protobuf:"bytes,1,rep,name=devices,proto3" json:"devices,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDevicesResponse) Reset() {
+	*x = ListDevicesResponse{}
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDevicesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDevicesResponse) ProtoMessage() {}
+
+func (x *ListDevicesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDevicesResponse.ProtoReflect.Descriptor instead.
+func (*ListDevicesResponse) Descriptor() ([]byte, []int) {
+	return file_aeu2fpb_aeu2f_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ListDevicesResponse) GetDevices() []*Device {
+	if x != nil {
+		return x.Devices
+	}
+	return nil
+}
+
+type RevokeDeviceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	User          string                 `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	Id            int64                  `protobuf:"varint,2,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RevokeDeviceRequest) Reset() {
+	*x = RevokeDeviceRequest{}
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RevokeDeviceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RevokeDeviceRequest) ProtoMessage() {}
+
+func (x *RevokeDeviceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RevokeDeviceRequest.ProtoReflect.Descriptor instead.
+func (*RevokeDeviceRequest) Descriptor() ([]byte, []int) {
+	return file_aeu2fpb_aeu2f_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *RevokeDeviceRequest) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+func (x *RevokeDeviceRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type RevokeDeviceResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Device        *Device                `protobuf:"bytes,1,opt,name=device,proto3" json:"device,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RevokeDeviceResponse) Reset() {
+	*x = RevokeDeviceResponse{}
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RevokeDeviceResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RevokeDeviceResponse) ProtoMessage() {}
+
+func (x *RevokeDeviceResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_aeu2fpb_aeu2f_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RevokeDeviceResponse.ProtoReflect.Descriptor instead.
+func (*RevokeDeviceResponse) Descriptor() ([]byte, []int) {
+	return file_aeu2fpb_aeu2f_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *RevokeDeviceResponse) GetDevice() *Device {
+	if x != nil {
+		return x.Device
+	}
+	return nil
+}
+
+var File_aeu2fpb_aeu2f_proto protoreflect.FileDescriptor
+
+const file_aeu2fpb_aeu2f_proto_rawDesc = "" +
+	"\n" +
+	"\x13aeu2fpb/aeu2f.proto\x12\baeu2f.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xd1\x01\n" +
+	"\x06Device\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x1d\n" +
+	"\n" +
+	"key_handle\x18\x02 \x01(\tR\tkeyHandle\x12\x12\n" +
+	"\x04name\x18\x03 \x01(\tR\x04name\x12\x18\n" +
+	"\acounter\x18\x04 \x01(\x03R\acounter\x124\n" +
+	"\acreated\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\acreated\x124\n" +
+	"\arevoked\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\arevoked\"`\n" +
+	"\x0fRegisterRequest\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\tR\aversion\x12\x1c\n" +
+	"\tchallenge\x18\x02 \x01(\tR\tchallenge\x12\x15\n" +
+	"\x06app_id\x18\x03 \x01(\tR\x05appId\"{\n" +
+	"\vSignRequest\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\tR\aversion\x12\x1c\n" +
+	"\tchallenge\x18\x02 \x01(\tR\tchallenge\x12\x1d\n" +
+	"\n" +
+	"key_handle\x18\x03 \x01(\tR\tkeyHandle\x12\x15\n" +
+	"\x06app_id\x18\x04 \x01(\tR\x05appId\".\n" +
+	"\x18BeginRegistrationRequest\x12\x12\n" +
+	"\x04user\x18\x01 \x01(\tR\x04user\"P\n" +
+	"\x19BeginRegistrationResponse\x123\n" +
+	"\arequest\x18\x01 \x01(\v2\x19.aeu2f.v1.RegisterRequestR\arequest\"}\n" +
+	"\x19FinishRegistrationRequest\x12\x12\n" +
+	"\x04user\x18\x01 \x01(\tR\x04user\x12+\n" +
+	"\x11registration_data\x18\x02 \x01(\tR\x10registrationData\x12\x1f\n" +
+	"\vclient_data\x18\x03 \x01(\tR\n" +
+	"clientData\"F\n" +
+	"\x1aFinishRegistrationResponse\x12(\n" +
+	"\x06device\x18\x01 \x01(\v2\x10.aeu2f.v1.DeviceR\x06device\"0\n" +
+	"\x1aBeginAuthenticationRequest\x12\x12\n" +
+	"\x04user\x18\x01 \x01(\tR\x04user\"P\n" +
+	"\x1bBeginAuthenticationResponse\x121\n" +
+	"\brequests\x18\x01 \x03(\v2\x15.aeu2f.v1.SignRequestR\brequests\"\x98\x01\n" +
+	"\x1bFinishAuthenticationRequest\x12\x12\n" +
+	"\x04user\x18\x01 \x01(\tR\x04user\x12\x1d\n" +
+	"\n" +
+	"key_handle\x18\x02 \x01(\tR\tkeyHandle\x12%\n" +
+	"\x0esignature_data\x18\x03 \x01(\tR\rsignatureData\x12\x1f\n" +
+	"\vclient_data\x18\x04 \x01(\tR\n" +
+	"clientData\"H\n" +
+	"\x1cFinishAuthenticationResponse\x12(\n" +
+	"\x06device\x18\x01 \x01(\v2\x10.aeu2f.v1.DeviceR\x06device\"(\n" +
+	"\x12ListDevicesRequest\x12\x12\n" +
+	"\x04user\x18\x01 \x01(\tR\x04user\"A\n" +
+	"\x13ListDevicesResponse\x12*\n" +
+	"\adevices\x18\x01 \x03(\v2\x10.aeu2f.v1.DeviceR\adevices\"9\n" +
+	"\x13RevokeDeviceRequest\x12\x12\n" +
+	"\x04user\x18\x01 \x01(\tR\x04user\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\x03R\x02id\"@\n" +
+	"\x14RevokeDeviceResponse\x12(\n" +
+	"\x06device\x18\x01 \x01(\v2\x10.aeu2f.v1.DeviceR\x06device2\xaa\x04\n" +
+	"\x03U2F\x12\\\n" +
+	"\x11BeginRegistration\x12\".aeu2f.v1.BeginRegistrationRequest\x1a#.aeu2f.v1.BeginRegistrationResponse\x12_\n" +
+	"\x12FinishRegistration\x12#.aeu2f.v1.FinishRegistrationRequest\x1a$.aeu2f.v1.FinishRegistrationResponse\x12b\n" +
+	"\x13BeginAuthentication\x12$.aeu2f.v1.BeginAuthenticationRequest\x1a%.aeu2f.v1.BeginAuthenticationResponse\x12e\n" +
+	"\x14FinishAuthentication\x12%.aeu2f.v1.FinishAuthenticationRequest\x1a&.aeu2f.v1.FinishAuthenticationResponse\x12J\n" +
+	"\vListDevices\x12\x1c.aeu2f.v1.ListDevicesRequest\x1a\x1d.aeu2f.v1.ListDevicesResponse\x12M\n" +
+	"\fRevokeDevice\x12\x1d.aeu2f.v1.RevokeDeviceRequest\x1a\x1e.aeu2f.v1.RevokeDeviceResponseB2Z0github.com/brianmhunt/aeu2f-go/aeu2fgrpc/aeu2fpbb\x06proto3"
+
+var (
+	file_aeu2fpb_aeu2f_proto_rawDescOnce sync.Once
+	file_aeu2fpb_aeu2f_proto_rawDescData []byte
+)
+
+func file_aeu2fpb_aeu2f_proto_rawDescGZIP() []byte {
+	file_aeu2fpb_aeu2f_proto_rawDescOnce.Do(func() {
+		file_aeu2fpb_aeu2f_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_aeu2fpb_aeu2f_proto_rawDesc), len(file_aeu2fpb_aeu2f_proto_rawDesc)))
+	})
+	return file_aeu2fpb_aeu2f_proto_rawDescData
+}
+
+var file_aeu2fpb_aeu2f_proto_msgTypes = make([]protoimpl.MessageInfo, 15)
+var file_aeu2fpb_aeu2f_proto_goTypes = []any{
+	(*Device)(nil),                       // 0: aeu2f.v1.Device
+	(*RegisterRequest)(nil),              // 1: aeu2f.v1.RegisterRequest
+	(*SignRequest)(nil),                  // 2: aeu2f.v1.SignRequest
+	(*BeginRegistrationRequest)(nil),     // 3: aeu2f.v1.BeginRegistrationRequest
+	(*BeginRegistrationResponse)(nil),    // 4: aeu2f.v1.BeginRegistrationResponse
+	(*FinishRegistrationRequest)(nil),    // 5: aeu2f.v1.FinishRegistrationRequest
+	(*FinishRegistrationResponse)(nil),   // 6: aeu2f.v1.FinishRegistrationResponse
+	(*BeginAuthenticationRequest)(nil),   // 7: aeu2f.v1.BeginAuthenticationRequest
+	(*BeginAuthenticationResponse)(nil),  // 8: aeu2f.v1.BeginAuthenticationResponse
+	(*FinishAuthenticationRequest)(nil),  // 9: aeu2f.v1.FinishAuthenticationRequest
+	(*FinishAuthenticationResponse)(nil), // 10: aeu2f.v1.FinishAuthenticationResponse
+	(*ListDevicesRequest)(nil),           // 11: aeu2f.v1.ListDevicesRequest
+	(*ListDevicesResponse)(nil),          // 12: aeu2f.v1.ListDevicesResponse
+	(*RevokeDeviceRequest)(nil),          // 13: aeu2f.v1.RevokeDeviceRequest
+	(*RevokeDeviceResponse)(nil),         // 14: aeu2f.v1.RevokeDeviceResponse
+	(*timestamppb.Timestamp)(nil),        // 15: google.protobuf.Timestamp
+}
+var file_aeu2fpb_aeu2f_proto_depIdxs = []int32{
+	15, // 0: aeu2f.v1.Device.created:type_name -> google.protobuf.Timestamp
+	15, // 1: aeu2f.v1.Device.revoked:type_name -> google.protobuf.Timestamp
+	1,  // 2: aeu2f.v1.BeginRegistrationResponse.request:type_name -> aeu2f.v1.RegisterRequest
+	0,  // 3: aeu2f.v1.FinishRegistrationResponse.device:type_name -> aeu2f.v1.Device
+	2,  // 4: aeu2f.v1.BeginAuthenticationResponse.requests:type_name -> aeu2f.v1.SignRequest
+	0,  // 5: aeu2f.v1.FinishAuthenticationResponse.device:type_name -> aeu2f.v1.Device
+	0,  // 6: aeu2f.v1.ListDevicesResponse.devices:type_name -> aeu2f.v1.Device
+	0,  // 7: aeu2f.v1.RevokeDeviceResponse.device:type_name -> aeu2f.v1.Device
+	3,  // 8: aeu2f.v1.U2F.BeginRegistration:input_type -> aeu2f.v1.BeginRegistrationRequest
+	5,  // 9: aeu2f.v1.U2F.FinishRegistration:input_type -> aeu2f.v1.FinishRegistrationRequest
+	7,  // 10: aeu2f.v1.U2F.BeginAuthentication:input_type -> aeu2f.v1.BeginAuthenticationRequest
+	9,  // 11: aeu2f.v1.U2F.FinishAuthentication:input_type -> aeu2f.v1.FinishAuthenticationRequest
+	11, // 12: aeu2f.v1.U2F.ListDevices:input_type -> aeu2f.v1.ListDevicesRequest
+	13, // 13: aeu2f.v1.U2F.RevokeDevice:input_type -> aeu2f.v1.RevokeDeviceRequest
+	4,  // 14: aeu2f.v1.U2F.BeginRegistration:output_type -> aeu2f.v1.BeginRegistrationResponse
+	6,  // 15: aeu2f.v1.U2F.FinishRegistration:output_type -> aeu2f.v1.FinishRegistrationResponse
+	8,  // 16: aeu2f.v1.U2F.BeginAuthentication:output_type -> aeu2f.v1.BeginAuthenticationResponse
+	10, // 17: aeu2f.v1.U2F.FinishAuthentication:output_type -> aeu2f.v1.FinishAuthenticationResponse
+	12, // 18: aeu2f.v1.U2F.ListDevices:output_type -> aeu2f.v1.ListDevicesResponse
+	14, // 19: aeu2f.v1.U2F.RevokeDevice:output_type -> aeu2f.v1.RevokeDeviceResponse
+	14, // [14:20] is the sub-list for method output_type
+	8,  // [8:14] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
+}
+
+func init() { file_aeu2fpb_aeu2f_proto_init() }
+func file_aeu2fpb_aeu2f_proto_init() {
+	if File_aeu2fpb_aeu2f_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_aeu2fpb_aeu2f_proto_rawDesc), len(file_aeu2fpb_aeu2f_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   15,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_aeu2fpb_aeu2f_proto_goTypes,
+		DependencyIndexes: file_aeu2fpb_aeu2f_proto_depIdxs,
+		MessageInfos:      file_aeu2fpb_aeu2f_proto_msgTypes,
+	}.Build()
+	File_aeu2fpb_aeu2f_proto = out.File
+	file_aeu2fpb_aeu2f_proto_goTypes = nil
+	file_aeu2fpb_aeu2f_proto_depIdxs = nil
+}
@@ -0,0 +1,344 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: aeu2fpb/aeu2f.proto
+
+package aeu2fpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	U2F_BeginRegistration_FullMethodName    = "/aeu2f.v1.U2F/BeginRegistration"
+	U2F_FinishRegistration_FullMethodName   = "/aeu2f.v1.U2F/FinishRegistration"
+	U2F_BeginAuthentication_FullMethodName  = "/aeu2f.v1.U2F/BeginAuthentication"
+	U2F_FinishAuthentication_FullMethodName = "/aeu2f.v1.U2F/FinishAuthentication"
+	U2F_ListDevices_FullMethodName          = "/aeu2f.v1.U2F/ListDevices"
+	U2F_RevokeDevice_FullMethodName         = "/aeu2f.v1.U2F/RevokeDevice"
+)
+
+// U2FClient is the client API for U2F service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// U2F runs the U2F registration and authentication ceremonies, and manages
+// the devices of users.  The U2F messages are those of the U2F JavaScript
+// API, passed between the browser and the server as they are.
+type U2FClient interface {
+	// BeginRegistration returns a registration challenge for the user.
+	BeginRegistration(ctx context.Context, in *BeginRegistrationRequest, opts ...grpc.CallOption) (*BeginRegistrationResponse, error)
+	// FinishRegistration checks the response to the registration challenge,
+	// and registers the device.
+	FinishRegistration(ctx context.Context, in *FinishRegistrationRequest, opts ...grpc.CallOption) (*FinishRegistrationResponse, error)
+	// BeginAuthentication returns a sign challenge for each device of the
+	// user, sharing one challenge.
+	BeginAuthentication(ctx context.Context, in *BeginAuthenticationRequest, opts ...grpc.CallOption) (*BeginAuthenticationResponse, error)
+	// FinishAuthentication checks the response to the sign challenge.
+	FinishAuthentication(ctx context.Context, in *FinishAuthenticationRequest, opts ...grpc.CallOption) (*FinishAuthenticationResponse, error)
+	// ListDevices returns the devices of the user.
+	ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error)
+	// RevokeDevice revokes a device of the user: it is kept, but no longer
+	// signs.
+	RevokeDevice(ctx context.Context, in *RevokeDeviceRequest, opts ...grpc.CallOption) (*RevokeDeviceResponse, error)
+}
+
+type u2FClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewU2FClient(cc grpc.ClientConnInterface) U2FClient {
+	return &u2FClient{cc}
+}
+
+func (c *u2FClient) BeginRegistration(ctx context.Context, in *BeginRegistrationRequest, opts ...grpc.CallOption) (*BeginRegistrationResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BeginRegistrationResponse)
+	err := c.cc.Invoke(ctx, U2F_BeginRegistration_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *u2FClient) FinishRegistration(ctx context.Context, in *FinishRegistrationRequest, opts ...grpc.CallOption) (*FinishRegistrationResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FinishRegistrationResponse)
+	err := c.cc.Invoke(ctx, U2F_FinishRegistration_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *u2FClient) BeginAuthentication(ctx context.Context, in *BeginAuthenticationRequest, opts ...grpc.CallOption) (*BeginAuthenticationResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BeginAuthenticationResponse)
+	err := c.cc.Invoke(ctx, U2F_BeginAuthentication_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *u2FClient) FinishAuthentication(ctx context.Context, in *FinishAuthenticationRequest, opts ...grpc.CallOption) (*FinishAuthenticationResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FinishAuthenticationResponse)
+	err := c.cc.Invoke(ctx, U2F_FinishAuthentication_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *u2FClient) ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDevicesResponse)
+	err := c.cc.Invoke(ctx, U2F_ListDevices_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *u2FClient) RevokeDevice(ctx context.Context, in *RevokeDeviceRequest, opts ...grpc.CallOption) (*RevokeDeviceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RevokeDeviceResponse)
+	err := c.cc.Invoke(ctx, U2F_RevokeDevice_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// U2FServer is the server API for U2F service.
+// All implementations must embed UnimplementedU2FServer
+// for forward compatibility.
+//
+// U2F runs the U2F registration and authentication ceremonies, and manages
+// the devices of users.  The U2F messages are those of the U2F JavaScript
+// API, passed between the browser and the server as they are.
+type U2FServer interface {
+	// BeginRegistration returns a registration challenge for the user.
+	BeginRegistration(context.Context, *BeginRegistrationRequest) (*BeginRegistrationResponse, error)
+	// FinishRegistration checks the response to the registration challenge,
+	// and registers the device.
+	FinishRegistration(context.Context, *FinishRegistrationRequest) (*FinishRegistrationResponse, error)
+	// BeginAuthentication returns a sign challenge for each device of the
+	// user, sharing one challenge.
+	BeginAuthentication(context.Context, *BeginAuthenticationRequest) (*BeginAuthenticationResponse, error)
+	// FinishAuthentication checks the response to the sign challenge.
+	FinishAuthentication(context.Context, *FinishAuthenticationRequest) (*FinishAuthenticationResponse, error)
+	// ListDevices returns the devices of the user.
+	ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error)
+	// RevokeDevice revokes a device of the user: it is kept, but no longer
+	// signs.
+	RevokeDevice(context.Context, *RevokeDeviceRequest) (*RevokeDeviceResponse, error)
+	mustEmbedUnimplementedU2FServer()
+}
+
+// UnimplementedU2FServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedU2FServer struct{}
+
+func (UnimplementedU2FServer) BeginRegistration(context.Context, *BeginRegistrationRequest) (*BeginRegistrationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BeginRegistration not implemented")
+}
+func (UnimplementedU2FServer) FinishRegistration(context.Context, *FinishRegistrationRequest) (*FinishRegistrationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FinishRegistration not implemented")
+}
+func (UnimplementedU2FServer) BeginAuthentication(context.Context, *BeginAuthenticationRequest) (*BeginAuthenticationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BeginAuthentication not implemented")
+}
+func (UnimplementedU2FServer) FinishAuthentication(context.Context, *FinishAuthenticationRequest) (*FinishAuthenticationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FinishAuthentication not implemented")
+}
+func (UnimplementedU2FServer) ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDevices not implemented")
+}
+func (UnimplementedU2FServer) RevokeDevice(context.Context, *RevokeDeviceRequest) (*RevokeDeviceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeDevice not implemented")
+}
+func (UnimplementedU2FServer) mustEmbedUnimplementedU2FServer() {}
+func (UnimplementedU2FServer) testEmbeddedByValue()             {}
+
+// UnsafeU2FServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to U2FServer will
+// result in compilation errors.
+type UnsafeU2FServer interface {
+	mustEmbedUnimplementedU2FServer()
+}
+
+func RegisterU2FServer(s grpc.ServiceRegistrar, srv U2FServer) {
+	// If the following call pancis, it indicates UnimplementedU2FServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&U2F_ServiceDesc, srv)
+}
+
+func _U2F_BeginRegistration_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BeginRegistrationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(U2FServer).BeginRegistration(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: U2F_BeginRegistration_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(U2FServer).BeginRegistration(ctx, req.(*BeginRegistrationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _U2F_FinishRegistration_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FinishRegistrationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(U2FServer).FinishRegistration(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: U2F_FinishRegistration_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(U2FServer).FinishRegistration(ctx, req.(*FinishRegistrationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _U2F_BeginAuthentication_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BeginAuthenticationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(U2FServer).BeginAuthentication(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: U2F_BeginAuthentication_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(U2FServer).BeginAuthentication(ctx, req.(*BeginAuthenticationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _U2F_FinishAuthentication_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FinishAuthenticationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(U2FServer).FinishAuthentication(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: U2F_FinishAuthentication_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(U2FServer).FinishAuthentication(ctx, req.(*FinishAuthenticationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _U2F_ListDevices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDevicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(U2FServer).ListDevices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: U2F_ListDevices_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(U2FServer).ListDevices(ctx, req.(*ListDevicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _U2F_RevokeDevice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeDeviceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(U2FServer).RevokeDevice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: U2F_RevokeDevice_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(U2FServer).RevokeDevice(ctx, req.(*RevokeDeviceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// U2F_ServiceDesc is the grpc.ServiceDesc for U2F service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var U2F_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "aeu2f.v1.U2F",
+	HandlerType: (*U2FServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "BeginRegistration",
+			Handler:    _U2F_BeginRegistration_Handler,
+		},
+		{
+			MethodName: "FinishRegistration",
+			Handler:    _U2F_FinishRegistration_Handler,
+		},
+		{
+			MethodName: "BeginAuthentication",
+			Handler:    _U2F_BeginAuthentication_Handler,
+		},
+		{
+			MethodName: "FinishAuthentication",
+			Handler:    _U2F_FinishAuthentication_Handler,
+		},
+		{
+			MethodName: "ListDevices",
+			Handler:    _U2F_ListDevices_Handler,
+		},
+		{
+			MethodName: "RevokeDevice",
+			Handler:    _U2F_RevokeDevice_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "aeu2fpb/aeu2f.proto",
+}
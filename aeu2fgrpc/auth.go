@@ -0,0 +1,109 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2fgrpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNoCaller is returned by an AuthFunc when the call does not authenticate
+// its caller.
+var ErrNoCaller = errors.New("aeu2fgrpc: caller not authenticated")
+
+// AuthFunc returns the name of the caller of a call, e.g. from its metadata
+// or its TLS client certificate.  Return ErrNoCaller (or any error) to refuse
+// the call with codes.Unauthenticated.
+type AuthFunc func(ctx context.Context, fullMethod string) (string, error)
+
+type callerContextKey struct{}
+
+// Caller returns the name of the caller authenticated by the interceptors,
+// or "".
+func Caller(ctx context.Context) string {
+	caller, _ := ctx.Value(callerContextKey{}).(string)
+	return caller
+}
+
+// --- authenticate ---
+// Return ctx with the caller of the call, or the status error refusing it.
+func authenticate(ctx context.Context, auth AuthFunc, fullMethod string) (context.Context, error) {
+	caller, err := auth(ctx, fullMethod)
+	if err != nil || caller == "" {
+		return nil, status.Error(codes.Unauthenticated, ErrNoCaller.Error())
+	}
+	return context.WithValue(ctx, callerContextKey{}, caller), nil
+}
+
+// UnaryAuthInterceptor refuses the unary calls whose caller auth does not
+// authenticate.
+func UnaryAuthInterceptor(auth AuthFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, auth, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuthInterceptor refuses the streams whose caller auth does not
+// authenticate.  U2F has no streaming calls, but other services, e.g.
+// reflection, may share the server.
+func StreamAuthInterceptor(auth AuthFunc) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), auth, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authStream{ss, ctx})
+	}
+}
+
+// authStream is a ServerStream with the context of its caller.
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context of the stream, with its caller.
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+// BearerTokens authenticates callers by the token of their authorization
+// metadata, "Bearer TOKEN".  tokens maps each token to its caller's name.
+func BearerTokens(tokens map[string]string) AuthFunc {
+	return func(ctx context.Context, fullMethod string) (string, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, v := range md.Get("authorization") {
+			token, ok := strings.CutPrefix(v, "Bearer ")
+			if !ok {
+				continue
+			}
+			// Compare with every token, so that the time taken does not
+			// tell which matched.
+			caller := ""
+			for t, name := range tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+					caller = name
+				}
+			}
+			if caller != "" {
+				return caller, nil
+			}
+		}
+		return "", ErrNoCaller
+	}
+}
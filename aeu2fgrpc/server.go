@@ -0,0 +1,214 @@
+//
+// Package aeu2fgrpc serves the aeu2f register, authenticate, list and revoke
+// operations over gRPC, for backends that are not written in Go.  The
+// service, U2F, is defined in aeu2fpb/aeu2f.proto.
+//
+// Serve it with e.g.
+// 	auth := aeu2fgrpc.BearerTokens(map[string]string{token: "billing"})
+// 	s := grpc.NewServer(
+// 		grpc.UnaryInterceptor(aeu2fgrpc.UnaryAuthInterceptor(auth)),
+// 		grpc.StreamInterceptor(aeu2fgrpc.StreamAuthInterceptor(auth)))
+// 	aeu2fpb.RegisterU2FServer(s, aeu2fgrpc.NewServer())
+//
+// Callers are trusted to name the user, as the backend of the user's
+// session, so authenticate them.  Failures lock out the user, as with the
+// other APIs, but not the caller's IP, which is that of the backend.
+//
+// License: MIT
+//
+package aeu2fgrpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative aeu2fpb/aeu2f.proto
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/brianmhunt/aeu2f-go"
+	"github.com/brianmhunt/aeu2f-go/aeu2fgrpc/aeu2fpb"
+	"github.com/tstranex/u2f"
+)
+
+// Server implements aeu2fpb.U2FServer with the aeu2f package.
+type Server struct {
+	aeu2fpb.UnimplementedU2FServer
+
+	// Context, if set, returns the context for the aeu2f package from the
+	// context of a call, e.g. with an App Engine context for the datastore.
+	Context func(ctx context.Context) context.Context
+}
+
+// NewServer returns a Server.
+func NewServer() *Server {
+	return &Server{}
+}
+
+// --- setup ---
+// Return the context of the call, for the user, or an error if no user is
+// given.
+func (s *Server) setup(ctx context.Context, userIdentity string) (context.Context, error) {
+	if userIdentity == "" {
+		return nil, status.Error(codes.InvalidArgument, "user is required")
+	}
+	if s.Context != nil {
+		ctx = s.Context(ctx)
+	}
+	return ctx, nil
+}
+
+// --- toStatus ---
+// Return the gRPC status error of an error from the aeu2f package, hiding
+// the details of server errors from the caller.
+func toStatus(err error) error {
+	var verr *aeu2f.VerificationError
+	var lerr *aeu2f.LockedOutError
+	switch {
+	case errors.As(err, &lerr):
+		return status.Error(codes.ResourceExhausted, lerr.Error())
+	case errors.As(err, &verr):
+		return status.Error(codes.PermissionDenied, verr.Error())
+	case err == aeu2f.ErrNoChallenge:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case err == aeu2f.ErrNoSuchRegistration:
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		aeu2f.Log.Error("aeu2fgrpc", "op", "aeu2fgrpc", "error", err.Error())
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+// --- device ---
+// Return the Device of regi.
+func device(regi *aeu2f.Registration) *aeu2fpb.Device {
+	return &aeu2fpb.Device{
+		Id:        regi.ID,
+		KeyHandle: base64.RawURLEncoding.EncodeToString(regi.KeyHandle),
+		Name:      regi.Name,
+		Counter:   regi.Counter,
+		Created:   timestamp(regi.Created),
+		Revoked:   timestamp(regi.Revoked),
+	}
+}
+
+// --- timestamp ---
+// Return the Timestamp of t, or nil for the zero time.
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// BeginRegistration implements aeu2fpb.U2FServer.
+func (s *Server) BeginRegistration(ctx context.Context, req *aeu2fpb.BeginRegistrationRequest) (*aeu2fpb.BeginRegistrationResponse, error) {
+	ctx, err := s.setup(ctx, req.GetUser())
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := aeu2f.NewRegistrationChallenge(ctx, req.GetUser())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &aeu2fpb.BeginRegistrationResponse{Request: &aeu2fpb.RegisterRequest{
+		Version:   c.Version,
+		Challenge: c.Challenge,
+		AppId:     c.AppID,
+	}}, nil
+}
+
+// FinishRegistration implements aeu2fpb.U2FServer.
+func (s *Server) FinishRegistration(ctx context.Context, req *aeu2fpb.FinishRegistrationRequest) (*aeu2fpb.FinishRegistrationResponse, error) {
+	ctx, err := s.setup(ctx, req.GetUser())
+	if err != nil {
+		return nil, err
+	}
+
+	regi, err := aeu2f.Register(ctx, req.GetUser(), u2f.RegisterResponse{
+		RegistrationData: req.GetRegistrationData(),
+		ClientData:       req.GetClientData(),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &aeu2fpb.FinishRegistrationResponse{Device: device(regi)}, nil
+}
+
+// BeginAuthentication implements aeu2fpb.U2FServer.
+func (s *Server) BeginAuthentication(ctx context.Context, req *aeu2fpb.BeginAuthenticationRequest) (*aeu2fpb.BeginAuthenticationResponse, error) {
+	ctx, err := s.setup(ctx, req.GetUser())
+	if err != nil {
+		return nil, err
+	}
+
+	reqs, err := aeu2f.NewSignChallenge(ctx, req.GetUser())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &aeu2fpb.BeginAuthenticationResponse{}
+	for _, r := range reqs {
+		resp.Requests = append(resp.Requests, &aeu2fpb.SignRequest{
+			Version:   r.Version,
+			Challenge: r.Challenge,
+			KeyHandle: r.KeyHandle,
+			AppId:     r.AppID,
+		})
+	}
+	return resp, nil
+}
+
+// FinishAuthentication implements aeu2fpb.U2FServer.
+func (s *Server) FinishAuthentication(ctx context.Context, req *aeu2fpb.FinishAuthenticationRequest) (*aeu2fpb.FinishAuthenticationResponse, error) {
+	ctx, err := s.setup(ctx, req.GetUser())
+	if err != nil {
+		return nil, err
+	}
+
+	regi, err := aeu2f.Authenticate(ctx, req.GetUser(), u2f.SignResponse{
+		KeyHandle:     req.GetKeyHandle(),
+		SignatureData: req.GetSignatureData(),
+		ClientData:    req.GetClientData(),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &aeu2fpb.FinishAuthenticationResponse{Device: device(regi)}, nil
+}
+
+// ListDevices implements aeu2fpb.U2FServer.
+func (s *Server) ListDevices(ctx context.Context, req *aeu2fpb.ListDevicesRequest) (*aeu2fpb.ListDevicesResponse, error) {
+	ctx, err := s.setup(ctx, req.GetUser())
+	if err != nil {
+		return nil, err
+	}
+
+	regis, err := aeu2f.ListRegistrations(ctx, req.GetUser())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &aeu2fpb.ListDevicesResponse{}
+	for _, regi := range regis {
+		resp.Devices = append(resp.Devices, device(regi))
+	}
+	return resp, nil
+}
+
+// RevokeDevice implements aeu2fpb.U2FServer.
+func (s *Server) RevokeDevice(ctx context.Context, req *aeu2fpb.RevokeDeviceRequest) (*aeu2fpb.RevokeDeviceResponse, error) {
+	ctx, err := s.setup(ctx, req.GetUser())
+	if err != nil {
+		return nil, err
+	}
+
+	regi, err := aeu2f.RevokeRegistration(ctx, req.GetUser(), req.GetId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &aeu2fpb.RevokeDeviceResponse{Device: device(regi)}, nil
+}
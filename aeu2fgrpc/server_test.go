@@ -0,0 +1,201 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2fgrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/brianmhunt/aeu2f-go"
+	"github.com/brianmhunt/aeu2f-go/aeu2fgrpc/aeu2fpb"
+	"github.com/brianmhunt/aeu2f-go/aeu2ftest"
+	"github.com/tstranex/u2f"
+)
+
+const testToken = "test token"
+
+// newTestClient serves a Server over an in-process connection, with a new
+// MemoryStore, and returns its client.
+func newTestClient(t *testing.T) aeu2fpb.U2FClient {
+	oldStorage, oldAppID, oldFacets := aeu2f.Storage, aeu2f.AppID, aeu2f.TrustedFacets
+	aeu2f.Storage = aeu2f.NewMemoryStore()
+	aeu2f.AppID, aeu2f.TrustedFacets = "https://example.com", []string{"https://example.com"}
+	t.Cleanup(func() { aeu2f.Storage, aeu2f.AppID, aeu2f.TrustedFacets = oldStorage, oldAppID, oldFacets })
+
+	auth := BearerTokens(map[string]string{testToken: "test"})
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryAuthInterceptor(auth)),
+		grpc.StreamInterceptor(StreamAuthInterceptor(auth)))
+	aeu2fpb.RegisterU2FServer(s, NewServer())
+
+	lis := bufconn.Listen(1024 * 1024)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return aeu2fpb.NewU2FClient(conn)
+}
+
+// authorized returns a context with the test token.
+func authorized() context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+testToken)
+}
+
+// expectCode fails the test unless err is a status with the code.
+func expectCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Errorf("Expected %v, got %v", code, err)
+	}
+}
+
+// register registers a new key of the user, and returns it.
+func register(t *testing.T, c aeu2fpb.U2FClient, user string) (*aeu2ftest.Authenticator, *aeu2fpb.Device) {
+	ctx := authorized()
+	begin, err := c.BeginRegistration(ctx, &aeu2fpb.BeginRegistrationRequest{User: user})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := begin.GetRequest()
+	if req.GetAppId() != "https://example.com" || req.GetChallenge() == "" {
+		t.Fatalf("Unexpected registration request %v", req)
+	}
+
+	a := aeu2ftest.New()
+	resp, err := a.Register(&u2f.RegisterRequest{Version: req.GetVersion(), Challenge: req.GetChallenge(), AppID: req.GetAppId()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	finish, err := c.FinishRegistration(ctx, &aeu2fpb.FinishRegistrationRequest{
+		User:             user,
+		RegistrationData: resp.RegistrationData,
+		ClientData:       resp.ClientData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, finish.GetDevice()
+}
+
+// signResponse returns the response of a to a new sign challenge.
+func signResponse(t *testing.T, c aeu2fpb.U2FClient, user string, a *aeu2ftest.Authenticator) *aeu2fpb.FinishAuthenticationRequest {
+	begin, err := c.BeginAuthentication(authorized(), &aeu2fpb.BeginAuthenticationRequest{User: user})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reqs []*u2f.SignRequest
+	for _, r := range begin.GetRequests() {
+		reqs = append(reqs, &u2f.SignRequest{Version: r.GetVersion(), Challenge: r.GetChallenge(), KeyHandle: r.GetKeyHandle(), AppID: r.GetAppId()})
+	}
+	resp, err := a.SignAny(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &aeu2fpb.FinishAuthenticationRequest{
+		User:          user,
+		KeyHandle:     resp.KeyHandle,
+		SignatureData: resp.SignatureData,
+		ClientData:    resp.ClientData,
+	}
+}
+
+func TestCeremonies(t *testing.T) {
+	c := newTestClient(t)
+	ctx := authorized()
+
+	a, d := register(t, c, "bob")
+	if d.GetId() == 0 || d.GetKeyHandle() == "" || d.GetCreated() == nil || d.GetRevoked() != nil {
+		t.Errorf("Unexpected device %v", d)
+	}
+
+	resp := signResponse(t, c, "bob", a)
+	finish, err := c.FinishAuthentication(ctx, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finish.GetDevice().GetId() != d.GetId() || finish.GetDevice().GetCounter() == 0 {
+		t.Errorf("Expected the device to sign, got %v", finish.GetDevice())
+	}
+	_, err = c.FinishAuthentication(ctx, resp)
+	expectCode(t, err, codes.FailedPrecondition)
+
+	list, err := c.ListDevices(ctx, &aeu2fpb.ListDevicesRequest{User: "bob"})
+	if err != nil || len(list.GetDevices()) != 1 || list.GetDevices()[0].GetId() != d.GetId() {
+		t.Errorf("Expected bob's device, got %v, %v", list, err)
+	}
+
+	// A response to a challenge made before the device was revoked.
+	resp = signResponse(t, c, "bob", a)
+	revoked, err := c.RevokeDevice(ctx, &aeu2fpb.RevokeDeviceRequest{User: "bob", Id: d.GetId()})
+	if err != nil || revoked.GetDevice().GetRevoked() == nil {
+		t.Fatalf("Expected the device to be revoked, got %v, %v", revoked, err)
+	}
+	_, err = c.FinishAuthentication(ctx, resp)
+	expectCode(t, err, codes.PermissionDenied)
+
+	begin, err := c.BeginAuthentication(ctx, &aeu2fpb.BeginAuthenticationRequest{User: "bob"})
+	if err != nil || len(begin.GetRequests()) != 0 {
+		t.Errorf("Expected no sign requests for the revoked device, got %v, %v", begin, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := newTestClient(t)
+	ctx := authorized()
+
+	_, err := c.BeginRegistration(ctx, &aeu2fpb.BeginRegistrationRequest{})
+	expectCode(t, err, codes.InvalidArgument)
+	_, err = c.FinishRegistration(ctx, &aeu2fpb.FinishRegistrationRequest{User: "bob"})
+	expectCode(t, err, codes.FailedPrecondition)
+	_, err = c.RevokeDevice(ctx, &aeu2fpb.RevokeDeviceRequest{User: "bob", Id: 1})
+	expectCode(t, err, codes.NotFound)
+
+	// Another user's device is not found either.
+	_, d := register(t, c, "bob")
+	_, err = c.RevokeDevice(ctx, &aeu2fpb.RevokeDeviceRequest{User: "alice", Id: d.GetId()})
+	expectCode(t, err, codes.NotFound)
+}
+
+func TestAuth(t *testing.T) {
+	c := newTestClient(t)
+	req := &aeu2fpb.ListDevicesRequest{User: "bob"}
+
+	_, err := c.ListDevices(context.Background(), req)
+	expectCode(t, err, codes.Unauthenticated)
+	for _, v := range []string{"Bearer wrong", testToken, "Basic " + testToken} {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", v)
+		_, err := c.ListDevices(ctx, req)
+		expectCode(t, err, codes.Unauthenticated)
+	}
+
+	// The handler sees the caller.
+	interceptor := UnaryAuthInterceptor(BearerTokens(map[string]string{testToken: "billing"}))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+testToken))
+	caller, err := interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: aeu2fpb.U2F_ListDevices_FullMethodName},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return Caller(ctx), nil
+		})
+	if err != nil || caller != "billing" {
+		t.Errorf("Expected the caller billing, got %v, %v", caller, err)
+	}
+}
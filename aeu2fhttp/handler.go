@@ -0,0 +1,369 @@
+//
+// Package aeu2fhttp provides ready-made net/http handlers for the aeu2f
+// register, authenticate, list and delete operations.
+//
+// Mount it with e.g.
+// 	h := aeu2fhttp.New(nil)
+// 	http.Handle("/u2f/", http.StripPrefix("/u2f", h))
+//
+// which serves
+// 	GET  /u2f/register/USER  - a new registration challenge
+// 	POST /u2f/register/USER  - the response to the registration challenge
+// 	GET  /u2f/auth/USER      - new sign challenges, one per key
+// 	POST /u2f/auth/USER      - the response to a sign challenge, answered
+// 	                           with a signed token if Tokens is set
+// 	GET  /u2f/list/USER      - the user's registrations
+// 	POST /u2f/delete/USER?id=ID - delete a registration (DELETE also works)
+// 	POST /u2f/stepup/USER    - a sign response, recorded in the session
+// 	GET  /u2f/openapi.json   - the OpenAPI document of the routes
+//
+// Request bodies must have only the fields of the U2F JavaScript API's
+// responses; others are rejected with 400 Bad Request, whose Error names the
+// field.
+//
+// Routes that need a recent U2F authentication can be wrapped with
+// RequireStepUp.
+//
+// License: MIT
+//
+package aeu2fhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine"
+
+	"github.com/brianmhunt/aeu2f-go"
+	"github.com/brianmhunt/aeu2f-go/aeu2ftoken"
+	"github.com/tstranex/u2f"
+)
+
+// DefaultMaxBodyBytes is the default limit on the size of a POST body.  U2F
+// responses are a few kilobytes at most.
+const DefaultMaxBodyBytes = 64 * 1024
+
+// ErrNoIdentity is returned by an IdentityFunc when the request does not
+// identify a user.
+var ErrNoIdentity = errors.New("aeu2fhttp: user identity not provided")
+
+// IdentityFunc returns the identity of the user making the request, e.g.
+// from a session cookie.  Return ErrNoIdentity (or any error) to reject the
+// request with 401 Unauthorized.
+type IdentityFunc func(r *http.Request) (string, error)
+
+// PathIdentity takes the user identity from the path, i.e. the USER in
+// /register/USER.  This is what aeu2f-demo does; in production one should
+// instead take the identity from the session.
+func PathIdentity(r *http.Request) (string, error) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	idx := strings.Index(path, "/")
+	if idx < 0 || idx == len(path)-1 {
+		return "", ErrNoIdentity
+	}
+	return path[idx+1:], nil
+}
+
+// TokenResponse is the body of a successful authentication when the Handler
+// issues tokens.
+type TokenResponse struct {
+	Token string `json:"token"`
+}
+
+// Error is the JSON body of every error response.
+type Error struct {
+	Status  int    `json:"status"`
+	Message string `json:"error"`
+
+	// Field, if set, is the field of the request body, or the query
+	// parameter, that is missing or invalid.
+	Field string `json:"field,omitempty"`
+}
+
+// Registration is a key as listed by /list/USER: the fields of
+// aeu2f.Registration the user may see, without its key material.
+type Registration struct {
+	ID      int64
+	Name    string
+	Created time.Time
+	Counter int64
+
+	// Revoked is when the key was revoked, or zero.
+	Revoked time.Time
+}
+
+// Handler serves the aeu2f routes.
+type Handler struct {
+	// Identity returns the user for the request.
+	Identity IdentityFunc
+
+	// Context returns the context.Context for the request.  It defaults to
+	// appengine.NewContext.
+	Context func(r *http.Request) context.Context
+
+	// MaxBodyBytes limits the size of POST bodies.
+	MaxBodyBytes int64
+
+	// Sessions records step-ups; it is needed by /stepup/ and RequireStepUp.
+	Sessions SessionStore
+
+	// Tokens, if set, mints the token returned after a successful
+	// authentication, instead of "success".
+	Tokens *aeu2ftoken.Issuer
+
+	mux *http.ServeMux
+}
+
+// New returns a Handler that identifies users with the given function, or
+// with PathIdentity when it is nil.
+func New(identity IdentityFunc) *Handler {
+	if identity == nil {
+		identity = PathIdentity
+	}
+
+	h := &Handler{
+		Identity:     identity,
+		Context:      appengine.NewContext,
+		MaxBodyBytes: DefaultMaxBodyBytes,
+		mux:          http.NewServeMux(),
+	}
+
+	for pattern, f := range h.routes() {
+		h.mux.HandleFunc(pattern, f)
+	}
+	return h
+}
+
+// routes returns the patterns served by h, and their handlers.  Each of them
+// must be in openapi.json.
+func (h *Handler) routes() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"/register/":    h.register,
+		"/auth/":        h.auth,
+		"/list/":        h.list,
+		"/delete/":      h.delete,
+		"/stepup/":      h.stepUp,
+		"/openapi.json": h.openAPI,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// --- writeJSON ---
+//
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// --- writeError ---
+//
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, Error{Status: status, Message: message})
+}
+
+// --- writeFieldError ---
+// Reject the request with 400 Bad Request because of the field.
+func writeFieldError(w http.ResponseWriter, ferr *fieldError) {
+	writeJSON(w, http.StatusBadRequest, Error{
+		Status:  http.StatusBadRequest,
+		Message: ferr.Message,
+		Field:   ferr.Field,
+	})
+}
+
+// --- writeMethodNotAllowed ---
+//
+func writeMethodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// --- fail ---
+// Report an error from the aeu2f package, hiding the details of server
+// errors from the client.
+func fail(ctx context.Context, w http.ResponseWriter, err error) {
+	var verr *aeu2f.VerificationError
+	var lerr *aeu2f.LockedOutError
+	switch {
+	case errors.As(err, &lerr):
+		retry := int(time.Until(lerr.Until).Seconds()) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		writeError(w, http.StatusTooManyRequests, lerr.Error())
+	case errors.As(err, &verr):
+		writeError(w, http.StatusForbidden, verr.Error())
+	case errors.Is(err, aeu2f.ErrNoChallenge):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, aeu2f.ErrNoSuchRegistration):
+		writeError(w, http.StatusNotFound, err.Error())
+	default:
+		aeu2f.Log.Error("aeu2fhttp", "op", "aeu2fhttp", "error", err.Error())
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+// --- setup ---
+// Return the context and user for the request, or write the error response
+// and return ok == false.
+func (h *Handler) setup(w http.ResponseWriter, r *http.Request) (ctx context.Context, userIdentity string, ok bool) {
+	userIdentity, err := h.Identity(r)
+	if err != nil || userIdentity == "" {
+		writeError(w, http.StatusUnauthorized, ErrNoIdentity.Error())
+		return nil, "", false
+	}
+	return aeu2f.WithRequest(h.Context(r), r), userIdentity, true
+}
+
+// --- register ---
+//
+func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		writeMethodNotAllowed(w, "GET, POST")
+		return
+	}
+
+	ctx, userIdentity, ok := h.setup(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == "GET" {
+		req, err := aeu2f.NewRegistrationChallenge(ctx, userIdentity)
+		if err != nil {
+			fail(ctx, w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, req)
+		return
+	}
+
+	var regResp registerResponse
+	if !h.decode(w, r, &regResp) {
+		return
+	}
+
+	err := aeu2f.StoreResponse(ctx, userIdentity, u2f.RegisterResponse{
+		RegistrationData: regResp.RegistrationData,
+		ClientData:       regResp.ClientData,
+	})
+	if err != nil {
+		fail(ctx, w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "success")
+}
+
+// --- auth ---
+//
+func (h *Handler) auth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		writeMethodNotAllowed(w, "GET, POST")
+		return
+	}
+
+	ctx, userIdentity, ok := h.setup(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == "GET" {
+		reqs, err := aeu2f.NewSignChallenge(ctx, userIdentity)
+		if err != nil {
+			fail(ctx, w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, reqs)
+		return
+	}
+
+	var signResp signResponse
+	if !h.decode(w, r, &signResp) {
+		return
+	}
+
+	regi, err := aeu2f.Authenticate(ctx, userIdentity, signResp.u2f())
+	if err != nil {
+		fail(ctx, w, err)
+		return
+	}
+
+	if h.Tokens == nil {
+		writeJSON(w, http.StatusOK, "success")
+		return
+	}
+
+	tok, err := h.Tokens.Issue(userIdentity, regi.ID, time.Now())
+	if err != nil {
+		fail(ctx, w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, TokenResponse{tok})
+}
+
+// --- list ---
+// Return the registrations of the user.
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, "GET")
+		return
+	}
+
+	ctx, userIdentity, ok := h.setup(w, r)
+	if !ok {
+		return
+	}
+
+	regis, err := aeu2f.ListRegistrations(ctx, userIdentity)
+	if err != nil {
+		fail(ctx, w, err)
+		return
+	}
+
+	keys := []*Registration{}
+	for _, regi := range regis {
+		keys = append(keys, &Registration{
+			ID:      regi.ID,
+			Name:    regi.Name,
+			Created: regi.Created,
+			Counter: regi.Counter,
+			Revoked: regi.Revoked,
+		})
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// --- delete ---
+// Delete the registration given by the `id` query parameter.
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" && r.Method != "DELETE" {
+		writeMethodNotAllowed(w, "POST, DELETE")
+		return
+	}
+
+	ctx, userIdentity, ok := h.setup(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		writeFieldError(w, &fieldError{"id", "invalid registration id"})
+		return
+	}
+
+	if err := aeu2f.DeleteRegistration(ctx, userIdentity, id); err != nil {
+		fail(ctx, w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "success")
+}
@@ -0,0 +1,209 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2fhttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/appengine/aetest"
+
+	"github.com/brianmhunt/aeu2f-go"
+	"github.com/brianmhunt/aeu2f-go/aeu2ftest"
+	"github.com/tstranex/u2f"
+)
+
+// newTestHandler returns a Handler whose requests all use ctx.
+func newTestHandler(ctx context.Context) *Handler {
+	h := New(nil)
+	h.Context = func(r *http.Request) context.Context { return ctx }
+	return h
+}
+
+func serve(h http.Handler, method, url, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) Error {
+	var e Error
+	if err := json.NewDecoder(w.Body).Decode(&e); err != nil {
+		t.Fatalf("Expected a JSON error body: %v", err)
+	}
+	if e.Status != w.Code {
+		t.Errorf("Expected body status %v to match %v", e.Status, w.Code)
+	}
+	return e
+}
+
+func TestPathIdentity(t *testing.T) {
+	for path, want := range map[string]string{
+		"/register/bob": "bob",
+		"/auth/a/b":     "a/b",
+		"/list/":        "",
+		"/list":         "",
+	} {
+		r := httptest.NewRequest("GET", path, nil)
+		got, err := PathIdentity(r)
+		if got != want {
+			t.Errorf("PathIdentity(%v) = %v, expected %v", path, got, want)
+		}
+		if want == "" && err != ErrNoIdentity {
+			t.Errorf("PathIdentity(%v) expected ErrNoIdentity, got %v", path, err)
+		}
+	}
+}
+
+func TestRegisterChallenge(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	aeu2f.AppID = "https://example.com"
+	w := serve(newTestHandler(ctx), "GET", "/register/bob", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v: %v", w.Code, w.Body)
+	}
+
+	var req u2f.RegisterRequest
+	if err := json.NewDecoder(w.Body).Decode(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.AppID != aeu2f.AppID || req.Challenge == "" {
+		t.Errorf("Unexpected register request: %+v", req)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	h := newTestHandler(ctx)
+	h.MaxBodyBytes = 16
+
+	for _, tc := range []struct {
+		method, url, body string
+		status            int
+	}{
+		{"PUT", "/register/bob", "", http.StatusMethodNotAllowed},
+		{"POST", "/list/bob", "", http.StatusMethodNotAllowed},
+		{"GET", "/register/", "", http.StatusUnauthorized},
+		{"POST", "/auth/bob", "{", http.StatusBadRequest},
+		{"POST", "/auth/bob", `{"keyHandle": "0123456789abcdef"}`, http.StatusRequestEntityTooLarge},
+		{"POST", "/auth/bob", `{}`, http.StatusBadRequest},
+		{"DELETE", "/delete/bob?id=x", "", http.StatusBadRequest},
+		{"DELETE", "/delete/bob?id=1", "", http.StatusNotFound},
+	} {
+		w := serve(h, tc.method, tc.url, tc.body)
+		if w.Code != tc.status {
+			t.Errorf("%v %v: expected %v, got %v", tc.method, tc.url, tc.status, w.Code)
+			continue
+		}
+		decodeError(t, w)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	// Requests are refused before the store is used.
+	h := newTestHandler(context.Background())
+
+	for _, tc := range []struct {
+		url, body, field string
+	}{
+		{"/register/bob", `{"registrationData": "AAAA", "clientData": "AAAA", "extra": 1}`, "extra"},
+		{"/register/bob", `{"registrationData": "AAAA"}`, "clientData"},
+		{"/register/bob", `{"registrationData": "AA+/", "clientData": "AAAA"}`, "registrationData"},
+		{"/register/bob", `{"version": "U2F_V3", "registrationData": "AAAA", "clientData": "AAAA"}`, "version"},
+		{"/register/bob", `{"registrationData": 1, "clientData": "AAAA"}`, "registrationData"},
+		{"/register/bob", `{"registrationData": "AAAA", "clientData": "AAAA"} {}`, ""},
+		{"/register/bob", `[]`, ""},
+		{"/auth/bob", `{"keyHandle": "AAAA", "signatureData": "AAAA", "clientData": "AAAA", "extra": 1}`, "extra"},
+		{"/auth/bob", `{"keyHandle": "AAAA", "signatureData": "AAAA", "clientData": "AAAA", "errorCode": "0"}`, "errorCode"},
+		{"/auth/bob", `{"keyHandle": "AAAA", "clientData": "AAAA"}`, "signatureData"},
+		{"/auth/bob", `{"keyHandle": "AAAA", "signatureData": "AAAA", "clientData": "AA A"}`, "clientData"},
+		{"/delete/bob?id=x", ``, "id"},
+	} {
+		w := serve(h, "POST", tc.url, tc.body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("POST %v %v: expected %v, got %v", tc.url, tc.body, http.StatusBadRequest, w.Code)
+			continue
+		}
+		if e := decodeError(t, w); e.Field != tc.field || e.Message == "" {
+			t.Errorf("POST %v %v: expected an error of field %q, got %+v", tc.url, tc.body, tc.field, e)
+		}
+	}
+}
+
+func TestListEmpty(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	w := serve(newTestHandler(ctx), "GET", "/list/nobody", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v", w.Code)
+	}
+	if body := strings.TrimSpace(w.Body.String()); body != "[]" {
+		t.Errorf("Expected an empty list, got %v", body)
+	}
+}
+
+// TestU2FAPIResponses posts the responses as u2f-api.js gives them to its
+// callbacks, with the error code and request ID it adds.
+func TestU2FAPIResponses(t *testing.T) {
+	old := aeu2f.Storage
+	aeu2f.Storage = aeu2f.NewMemoryStore()
+	t.Cleanup(func() { aeu2f.Storage = old })
+
+	appID := "https://example.com"
+	h := newTestHandler(aeu2f.WithAppID(context.Background(), appID, []string{appID}))
+	a := aeu2ftest.New()
+
+	w := serve(h, "GET", "/register/bob", "")
+	var regReq u2f.RegisterRequest
+	if err := json.NewDecoder(w.Body).Decode(&regReq); err != nil {
+		t.Fatal(err)
+	}
+	regResp, err := a.Register(&regReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := fmt.Sprintf(`{"registrationData":%q,"version":"U2F_V2","clientData":%q,"requestId":1}`,
+		regResp.RegistrationData, regResp.ClientData)
+	if w := serve(h, "POST", "/register/bob", body); w.Code != http.StatusOK {
+		t.Fatalf("Register: expected 200, got %v: %v", w.Code, w.Body)
+	}
+
+	w = serve(h, "GET", "/auth/bob", "")
+	var signReqs []*u2f.SignRequest
+	if err := json.NewDecoder(w.Body).Decode(&signReqs); err != nil {
+		t.Fatal(err)
+	}
+	signResp, err := a.SignAny(signReqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = fmt.Sprintf(`{"errorCode":0,"keyHandle":%q,"signatureData":%q,"clientData":%q,"requestId":2}`,
+		signResp.KeyHandle, signResp.SignatureData, signResp.ClientData)
+	if w := serve(h, "POST", "/auth/bob", body); w.Code != http.StatusOK {
+		t.Errorf("Authenticate: expected 200, got %v: %v", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,29 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2fhttp
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// OpenAPI is the OpenAPI 3 document of the register, auth, list and delete
+// routes, in JSON.  The Handler serves it at /openapi.json.
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+// --- openAPI ---
+//
+func (h *Handler) openAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		writeMethodNotAllowed(w, "GET, HEAD")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(OpenAPI)
+}
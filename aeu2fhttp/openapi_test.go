@@ -0,0 +1,190 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2fhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/tstranex/u2f"
+)
+
+// openAPISchema is the part of a schema of the OpenAPI document checked
+// against the Go types.
+type openAPISchema struct {
+	Required             []string
+	Properties           map[string]json.RawMessage
+	AdditionalProperties *bool
+}
+
+// openAPIDoc is the part of the OpenAPI document checked against the
+// Handler.
+type openAPIDoc struct {
+	OpenAPI    string
+	Paths      map[string]map[string]json.RawMessage
+	Components struct {
+		Schemas map[string]openAPISchema
+	}
+}
+
+// jsonFields returns the JSON field names of the struct type t, and those
+// that are always encoded.
+func jsonFields(t reflect.Type) (fields, required []string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, name)
+		if opts != "omitempty" {
+			required = append(required, name)
+		}
+	}
+	return fields, required
+}
+
+// sorted returns a sorted copy of ss.
+func sorted(ss []string) []string {
+	ss = append([]string(nil), ss...)
+	sort.Strings(ss)
+	return ss
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	var doc openAPIDoc
+	if err := json.Unmarshal(OpenAPI, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("Expected OpenAPI 3, got %q", doc.OpenAPI)
+	}
+
+	for name, tc := range map[string]struct {
+		v interface{}
+		// request bodies must list their required fields, and refuse
+		// others.
+		request bool
+	}{
+		"RegisterRequest":  {u2f.RegisterRequest{}, false},
+		"RegisterResponse": {registerResponse{}, true},
+		"SignRequest":      {u2f.SignRequest{}, false},
+		"SignResponse":     {signResponse{}, true},
+		"TokenResponse":    {TokenResponse{}, false},
+		"Registration":     {Registration{}, false},
+		"Error":            {Error{}, false},
+	} {
+		schema, ok := doc.Components.Schemas[name]
+		if !ok {
+			t.Errorf("Expected a schema %v", name)
+			continue
+		}
+		fields, required := jsonFields(reflect.TypeOf(tc.v))
+		var props []string
+		for p := range schema.Properties {
+			props = append(props, p)
+		}
+		if got, want := sorted(props), sorted(fields); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: expected properties %v, got %v", name, want, got)
+		}
+		if !tc.request {
+			continue
+		}
+		if got, want := sorted(schema.Required), sorted(required); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: expected required %v, got %v", name, want, got)
+		}
+		if schema.AdditionalProperties == nil || *schema.AdditionalProperties {
+			t.Errorf("%v: expected additionalProperties to be false", name)
+		}
+	}
+
+	// Every reference is to a component.
+	var refs []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, e := range v {
+				if s, ok := e.(string); ok && k == "$ref" {
+					refs = append(refs, s)
+				}
+				walk(e)
+			}
+		case []interface{}:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	var all map[string]interface{}
+	json.Unmarshal(OpenAPI, &all)
+	walk(all)
+	components := all["components"].(map[string]interface{})
+	for _, ref := range refs {
+		parts := strings.Split(strings.TrimPrefix(ref, "#/components/"), "/")
+		kind, _ := components[parts[0]].(map[string]interface{})
+		if len(parts) != 2 || kind == nil || kind[parts[1]] == nil {
+			t.Errorf("Unresolved reference %v", ref)
+		}
+	}
+}
+
+func TestOpenAPIPaths(t *testing.T) {
+	var doc openAPIDoc
+	if err := json.Unmarshal(OpenAPI, &doc); err != nil {
+		t.Fatal(err)
+	}
+	h := New(nil)
+	h.Sessions = &CookieSessions{Key: []byte("0123456789abcdef0123456789abcdef")}
+
+	// Every route but the document itself is documented, and nothing else.
+	var routes, paths []string
+	for pattern := range h.routes() {
+		if pattern != "/openapi.json" {
+			routes = append(routes, pattern+"{user}")
+		}
+	}
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	if got, want := sorted(paths), sorted(routes); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected paths %v, got %v", want, got)
+	}
+
+	for path, ops := range doc.Paths {
+
+		// The methods are those the route allows.
+		var methods []string
+		for m := range ops {
+			if m != "parameters" {
+				methods = append(methods, strings.ToUpper(m))
+			}
+		}
+		route := strings.Replace(path, "{user}", "bob", 1)
+		w := serve(h, "PUT", route, "")
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("PUT %v: expected %v, got %v", route, http.StatusMethodNotAllowed, w.Code)
+			continue
+		}
+		allow := strings.Split(w.Header().Get("Allow"), ", ")
+		if got, want := sorted(methods), sorted(allow); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: expected methods %v, got %v", path, want, got)
+		}
+	}
+	w := serve(h, "GET", "/openapi.json", "")
+	if w.Code != http.StatusOK || w.Body.String() != string(OpenAPI) {
+		t.Errorf("Expected GET /openapi.json to serve OpenAPI, got %v", w.Code)
+	}
+}
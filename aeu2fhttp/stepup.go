@@ -0,0 +1,286 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2fhttp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/brianmhunt/aeu2f-go"
+	"github.com/tstranex/u2f"
+)
+
+// DefaultStepUpMaxAge is how recent a step-up must be, unless configured
+// otherwise in StepUpOptions.
+const DefaultStepUpMaxAge = 5 * time.Minute
+
+// StepUp records a successful U2F authentication for a session.
+type StepUp struct {
+	UserIdentity string
+
+	// RegistrationID is the ID of the registration whose key satisfied the
+	// step-up.
+	RegistrationID int64
+	At             time.Time
+}
+
+// SessionStore keeps the most recent StepUp of a session.
+type SessionStore interface {
+	// StepUp returns the StepUp of the request's session, or nil if there is
+	// none.
+	StepUp(r *http.Request) (*StepUp, error)
+
+	// SetStepUp records s for the request's session.
+	SetStepUp(w http.ResponseWriter, r *http.Request, s *StepUp) error
+}
+
+// StepUpOptions configure Handler.RequireStepUp.
+type StepUpOptions struct {
+	// MaxAge is how recent the step-up must be; defaults to
+	// DefaultStepUpMaxAge.
+	MaxAge time.Duration
+
+	// RedirectURL, if set, is where browsers are sent to step up, with the
+	// original URL in the `next` query parameter.  Otherwise (and for
+	// non-browser requests) the response is a JSON StepUpChallenge.
+	RedirectURL string
+
+	// StepUpURL is the path of the Handler's /stepup/ route, to which the
+	// client should POST its response; it is included in the challenge.
+	StepUpURL string
+}
+
+// StepUpChallenge is the 401 Unauthorized body of a request that needs a
+// step-up.
+type StepUpChallenge struct {
+	Error
+	SignRequests []*u2f.SignRequest `json:"signRequests"`
+	StepUpURL    string             `json:"stepUpURL,omitempty"`
+}
+
+type stepUpContextKey struct{}
+
+// StepUpFromRequest returns the StepUp that allowed the request through
+// RequireStepUp, or nil.
+func StepUpFromRequest(r *http.Request) *StepUp {
+	s, _ := r.Context().Value(stepUpContextKey{}).(*StepUp)
+	return s
+}
+
+// RequireStepUp wraps next so that it is only served when the user's session
+// has stepped up (with a U2F authentication) within opts.MaxAge.
+//
+// The Handler's Sessions must be set; the client steps up by POSTing a
+// SignResponse to the Handler's /stepup/ route.
+func (h *Handler) RequireStepUp(next http.Handler, opts StepUpOptions) http.Handler {
+	if opts.MaxAge == 0 {
+		opts.MaxAge = DefaultStepUpMaxAge
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.Sessions == nil {
+			writeError(w, http.StatusInternalServerError, "step-up sessions not configured")
+			return
+		}
+
+		ctx, userIdentity, ok := h.setup(w, r)
+		if !ok {
+			return
+		}
+
+		s, err := h.Sessions.StepUp(r)
+		if err != nil {
+			aeu2f.Log.Error("aeu2fhttp.stepup", "op", "aeu2fhttp.stepup", "error", err.Error())
+			s = nil
+		}
+
+		if s != nil && s.UserIdentity == userIdentity && time.Since(s.At) <= opts.MaxAge {
+			r = r.WithContext(context.WithValue(r.Context(), stepUpContextKey{}, s))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if opts.RedirectURL != "" && r.Method == "GET" &&
+			strings.Contains(r.Header.Get("Accept"), "text/html") {
+			u := opts.RedirectURL + "?next=" + url.QueryEscape(r.URL.RequestURI())
+			http.Redirect(w, r, u, http.StatusSeeOther)
+			return
+		}
+
+		reqs, err := aeu2f.NewSignChallenge(ctx, userIdentity)
+		if err != nil {
+			fail(ctx, w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusUnauthorized, StepUpChallenge{
+			Error:        Error{Status: http.StatusUnauthorized, Message: "step-up authentication required"},
+			SignRequests: reqs,
+			StepUpURL:    opts.StepUpURL,
+		})
+	})
+}
+
+// --- stepUp ---
+// Verify a SignResponse and record the step-up in the session.
+func (h *Handler) stepUp(w http.ResponseWriter, r *http.Request) {
+	if h.Sessions == nil {
+		writeError(w, http.StatusNotFound, "step-up sessions not configured")
+		return
+	}
+
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+
+	ctx, userIdentity, ok := h.setup(w, r)
+	if !ok {
+		return
+	}
+
+	var signResp signResponse
+	if !h.decode(w, r, &signResp) {
+		return
+	}
+
+	regi, err := aeu2f.Authenticate(ctx, userIdentity, signResp.u2f())
+	if err != nil {
+		fail(ctx, w, err)
+		return
+	}
+
+	s := &StepUp{UserIdentity: userIdentity, RegistrationID: regi.ID, At: time.Now()}
+	if err := h.Sessions.SetStepUp(w, r, s); err != nil {
+		fail(ctx, w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "success")
+}
+
+// CookieSessions is a SessionStore that keeps the StepUp in an HMAC-signed
+// cookie, so it needs no server-side state.  The cookie is signed together
+// with the ID of the application's session, which Session must give.
+type CookieSessions struct {
+	// Name of the cookie; defaults to "aeu2f-stepup".
+	Name string
+
+	// Key signs the cookie.  It must be secret, and at least
+	// MinCookieKeyBytes; with a shorter key, no step-up is set or accepted.
+	Key []byte
+
+	// Session returns the ID of the request's session, e.g. from the
+	// application's session cookie.  It is required: a step-up is only
+	// accepted in the session it was set in, so that a copied cookie gives
+	// no step-up to another session, even of the same user.
+	Session func(r *http.Request) (string, error)
+}
+
+func (c *CookieSessions) name() string {
+	if c.Name == "" {
+		return "aeu2f-stepup"
+	}
+	return c.Name
+}
+
+// MinCookieKeyBytes is the least length of CookieSessions.Key.
+const MinCookieKeyBytes = 32
+
+// ErrShortCookieKey is returned by CookieSessions whose Key is shorter than
+// MinCookieKeyBytes; they neither sign nor accept cookies.
+var ErrShortCookieKey = errors.New("aeu2fhttp: step-up cookie key shorter than 32 bytes")
+
+// ErrNoSession is returned by CookieSessions without a Session, or for a
+// request without a session; no step-up is set or accepted.
+var ErrNoSession = errors.New("aeu2fhttp: no session for the step-up cookie")
+
+// --- sign ---
+// Return the MAC of the cookie payload in the request's session.
+func (c *CookieSessions) sign(r *http.Request, payload string) (string, error) {
+	if len(c.Key) < MinCookieKeyBytes {
+		return "", ErrShortCookieKey
+	}
+	if c.Session == nil {
+		return "", ErrNoSession
+	}
+	session, err := c.Session(r)
+	if err != nil {
+		return "", err
+	}
+	if session == "" {
+		return "", ErrNoSession
+	}
+
+	// The payload is base64, so cannot run into the session.
+	mac := hmac.New(sha256.New, c.Key)
+	mac.Write([]byte(payload + "\x00" + session))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// StepUp implements SessionStore.
+func (c *CookieSessions) StepUp(r *http.Request) (*StepUp, error) {
+	cookie, err := r.Cookie(c.name())
+	if err == http.ErrNoCookie {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("aeu2fhttp: invalid step-up cookie")
+	}
+	mac, err := c.sign(r, parts[0])
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(mac)) {
+		return nil, errors.New("aeu2fhttp: invalid step-up cookie signature")
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var s StepUp
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// SetStepUp implements SessionStore.
+func (c *CookieSessions) SetStepUp(w http.ResponseWriter, r *http.Request, s *StepUp) error {
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(buf)
+	mac, err := c.sign(r, payload)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.name(),
+		Value:    payload + "." + mac,
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
@@ -0,0 +1,204 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2fhttp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/aetest"
+)
+
+// memorySessions is a SessionStore holding a single StepUp.
+type memorySessions struct {
+	s *StepUp
+}
+
+func (m *memorySessions) StepUp(r *http.Request) (*StepUp, error) { return m.s, nil }
+
+func (m *memorySessions) SetStepUp(w http.ResponseWriter, r *http.Request, s *StepUp) error {
+	m.s = s
+	return nil
+}
+
+var protected = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if s := StepUpFromRequest(r); s == nil || s.RegistrationID != 42 {
+		http.Error(w, "expected the step-up in the request", http.StatusTeapot)
+		return
+	}
+	w.Write([]byte("payout"))
+})
+
+// sessionRequest returns a request in the given session, as cookieSession
+// finds it.
+func sessionRequest(session string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	if session != "" {
+		r.AddCookie(&http.Cookie{Name: "session", Value: session})
+	}
+	return r
+}
+
+func cookieSession(r *http.Request) (string, error) {
+	cookie, err := r.Cookie("session")
+	if err != nil {
+		return "", nil
+	}
+	return cookie.Value, nil
+}
+
+func TestCookieSessions(t *testing.T) {
+	c := &CookieSessions{Key: []byte("0123456789abcdef0123456789abcdef"), Session: cookieSession}
+	at := time.Now().Round(time.Second)
+
+	w := httptest.NewRecorder()
+	r := sessionRequest("s1")
+	if err := c.SetStepUp(w, r, &StepUp{"bob", 42, at}); err != nil {
+		t.Fatal(err)
+	}
+
+	r = sessionRequest("s1")
+	if s, err := c.StepUp(r); s != nil || err != nil {
+		t.Errorf("Expected no step-up without a cookie, got %+v, %v", s, err)
+	}
+
+	cookie := w.Result().Cookies()[0]
+	if !cookie.Secure || !cookie.HttpOnly {
+		t.Errorf("Expected a secure, http-only cookie: %+v", cookie)
+	}
+
+	r.AddCookie(cookie)
+	s, err := c.StepUp(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.UserIdentity != "bob" || s.RegistrationID != 42 || !s.At.Equal(at) {
+		t.Errorf("Unexpected step-up %+v", s)
+	}
+
+	// Copied to another session, or to a request without one.
+	r = sessionRequest("s2")
+	r.AddCookie(cookie)
+	if s, err := c.StepUp(r); s != nil || err == nil {
+		t.Errorf("Expected the cookie to be rejected in another session, got %+v", s)
+	}
+	r = sessionRequest("")
+	r.AddCookie(cookie)
+	if s, err := c.StepUp(r); s != nil || err != ErrNoSession {
+		t.Errorf("Expected ErrNoSession without a session, got %+v, %v", s, err)
+	}
+	if err := (&CookieSessions{Key: c.Key}).SetStepUp(w, sessionRequest("s1"), s); err != ErrNoSession {
+		t.Errorf("Expected ErrNoSession without Session, got %v", err)
+	}
+
+	// Tamper with the payload.
+	r = sessionRequest("s1")
+	cookie.Value = "e30" + cookie.Value[strings.Index(cookie.Value, "."):]
+	r.AddCookie(cookie)
+	if _, err := c.StepUp(r); err == nil {
+		t.Error("Expected a tampered cookie to be rejected")
+	}
+
+	// A short key neither signs nor verifies, even its own cookies.
+	short := &CookieSessions{Key: c.Key[:MinCookieKeyBytes-1], Session: cookieSession}
+	w = httptest.NewRecorder()
+	if err := short.SetStepUp(w, r, &StepUp{"bob", 42, at}); err != ErrShortCookieKey {
+		t.Errorf("Expected ErrShortCookieKey signing, got %v", err)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("Expected no cookie with a short key")
+	}
+	r = sessionRequest("s1")
+	r.AddCookie(&http.Cookie{Name: "aeu2f-stepup", Value: "e30.x"})
+	if s, err := short.StepUp(r); s != nil || err != ErrShortCookieKey {
+		t.Errorf("Expected ErrShortCookieKey verifying, got %+v, %v", s, err)
+	}
+}
+
+func TestRequireStepUp(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	sessions := &memorySessions{}
+	h := newTestHandler(ctx)
+	h.Sessions = sessions
+	guarded := h.RequireStepUp(protected, StepUpOptions{
+		MaxAge:      time.Minute,
+		RedirectURL: "/login/stepup",
+	})
+
+	// Fresh
+	sessions.s = &StepUp{"bob", 42, time.Now()}
+	w := serve(guarded, "POST", "/payout/bob", "")
+	if w.Code != http.StatusOK || w.Body.String() != "payout" {
+		t.Errorf("Expected a fresh step-up to be allowed, got %v: %v", w.Code, w.Body)
+	}
+
+	// Another user's step-up
+	w = serve(guarded, "GET", "/payout/alice", "")
+	if w.Code == http.StatusOK {
+		t.Error("Expected bob's step-up not to be accepted for alice")
+	}
+
+	// Stale, from a browser
+	sessions.s.At = time.Now().Add(-2 * time.Minute)
+	r := httptest.NewRequest("GET", "/payout/bob?amount=5", nil)
+	r.Header.Set("Accept", "text/html")
+	w = httptest.NewRecorder()
+	guarded.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a redirect, got %v", w.Code)
+	}
+	if loc := w.Header().Get("Location"); loc != "/login/stepup?next=%2Fpayout%2Fbob%3Famount%3D5" {
+		t.Errorf("Unexpected redirect to %v", loc)
+	}
+}
+
+func TestRequireStepUpChallenge(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	h := newTestHandler(ctx)
+	h.Sessions = &memorySessions{}
+	guarded := h.RequireStepUp(protected, StepUpOptions{StepUpURL: "/u2f/stepup/bob"})
+
+	w := serve(guarded, "POST", "/payout/bob", "")
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401, got %v: %v", w.Code, w.Body)
+	}
+
+	var c StepUpChallenge
+	if err := json.NewDecoder(w.Body).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Status != http.StatusUnauthorized || c.StepUpURL != "/u2f/stepup/bob" || c.SignRequests == nil {
+		t.Errorf("Unexpected challenge %+v", c)
+	}
+}
+
+func TestStepUpRoute(t *testing.T) {
+	h := newTestHandler(context.Background())
+	if w := serve(h, "POST", "/stepup/bob", "{}"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without Sessions, got %v", w.Code)
+	}
+
+	h.Sessions = &memorySessions{}
+	if w := serve(h, "GET", "/stepup/bob", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %v", w.Code)
+	}
+}
@@ -0,0 +1,151 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2fhttp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tstranex/u2f"
+)
+
+// fieldError is a request that fails validation, because of the given field.
+type fieldError struct {
+	Field   string
+	Message string
+}
+
+// u2fVersion is the only version of the U2F protocol.
+const u2fVersion = "U2F_V2"
+
+// requestBody is a JSON request body, which checks its fields once decoded.
+type requestBody interface {
+	validate() *fieldError
+}
+
+// registerResponse is the body of POST /register/USER: the response of the
+// U2F JavaScript API to the registration challenge.  Unlike
+// u2f.RegisterResponse, it has the version, error code and request ID that
+// the API includes; the last two are ignored.
+type registerResponse struct {
+	Version          string `json:"version,omitempty"`
+	RegistrationData string `json:"registrationData"`
+	ClientData       string `json:"clientData"`
+	ErrorCode        int    `json:"errorCode,omitempty"`
+	RequestID        int64  `json:"requestId,omitempty"`
+}
+
+func (r *registerResponse) validate() *fieldError {
+	if r.Version != "" && r.Version != u2fVersion {
+		return &fieldError{"version", "unsupported version"}
+	}
+	return firstFieldError(
+		base64Field("registrationData", r.RegistrationData),
+		base64Field("clientData", r.ClientData))
+}
+
+// signResponse is the body of POST /auth/USER and /stepup/USER: the response
+// of the U2F JavaScript API to a sign challenge.  As for registerResponse,
+// the error code and request ID are ignored.
+type signResponse struct {
+	KeyHandle     string `json:"keyHandle"`
+	SignatureData string `json:"signatureData"`
+	ClientData    string `json:"clientData"`
+	ErrorCode     int    `json:"errorCode,omitempty"`
+	RequestID     int64  `json:"requestId,omitempty"`
+}
+
+// u2f returns the response as u2f takes it.
+func (r *signResponse) u2f() u2f.SignResponse {
+	return u2f.SignResponse{
+		KeyHandle:     r.KeyHandle,
+		SignatureData: r.SignatureData,
+		ClientData:    r.ClientData,
+	}
+}
+
+func (r *signResponse) validate() *fieldError {
+	return firstFieldError(
+		base64Field("keyHandle", r.KeyHandle),
+		base64Field("signatureData", r.SignatureData),
+		base64Field("clientData", r.ClientData))
+}
+
+// --- base64Field ---
+// Return the error of a required field that is not websafe base64, padded
+// or not, as the U2F JavaScript API gives.
+func base64Field(field, value string) *fieldError {
+	if value == "" {
+		return &fieldError{field, "required"}
+	}
+	if _, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "=")); err != nil {
+		return &fieldError{field, "not websafe base64"}
+	}
+	return nil
+}
+
+// --- firstFieldError ---
+//
+func firstFieldError(errs ...*fieldError) *fieldError {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- jsonFieldError ---
+// Return the fieldError of a JSON decoding error, naming the field if the
+// error does.
+func jsonFieldError(err error) *fieldError {
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return &fieldError{typeErr.Field, "expected " + typeErr.Type.String()}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, uerr := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		if uerr == nil {
+			return &fieldError{field, "unknown field"}
+		}
+	}
+	return &fieldError{"", "invalid JSON: " + err.Error()}
+}
+
+// --- decode ---
+// Decode the JSON body of r into v, which must be one JSON object with only
+// the fields of v, and validate it, writing the error response if it fails.
+func (h *Handler) decode(w http.ResponseWriter, r *http.Request, v requestBody) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, h.MaxBodyBytes))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.Decode(&json.RawMessage{}) != io.EOF {
+		err = errors.New("unexpected data after the JSON object")
+	}
+
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil:
+	case errors.As(err, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+		return false
+	default:
+		writeFieldError(w, jsonFieldError(err))
+		return false
+	}
+
+	if ferr := v.validate(); ferr != nil {
+		writeFieldError(w, ferr)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,276 @@
+//
+// Package aeu2ftest provides a software U2F authenticator, to test
+// registration and authentication end to end without a hardware token.
+//
+// 	a := aeu2ftest.New()
+// 	req, _ := aeu2f.NewRegistrationChallenge(ctx, user)
+// 	resp, _ := a.Register(req)
+// 	aeu2f.StoreResponse(ctx, user, *resp)
+//
+// 	reqs, _ := aeu2f.NewSignChallenge(ctx, user)
+// 	signResp, _ := a.SignAny(reqs)
+// 	aeu2f.Sign(ctx, user, *signResp)
+//
+// Like a hardware token, an Authenticator keeps no per-registration state:
+// each key is derived from its device secret and the key handle, and the key
+// handle is authenticated, so it is only accepted for the AppID it was made
+// for.
+//
+// License: MIT
+//
+package aeu2ftest
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/tstranex/u2f"
+)
+
+// Client data types, as set by the browser.
+const (
+	TypeRegister = "navigator.id.finishEnrollment"
+	TypeSign     = "navigator.id.getAssertion"
+)
+
+// ErrUnknownKeyHandle is returned for a key handle the authenticator did not
+// make, or made for another AppID.
+var ErrUnknownKeyHandle = errors.New("aeu2ftest: unknown key handle")
+
+// Authenticator is a software U2F token.
+type Authenticator struct {
+	// Counter is incremented before each signature, and is shared by all
+	// keys, as on most hardware tokens.  Set it back to simulate a cloned
+	// token.
+	Counter uint32
+
+	// Origin is the facet ID the browser reports in the client data.  It
+	// defaults to the AppID of the request.
+	Origin string
+
+	// NoUserPresence makes signatures that do not assert the user touched
+	// the token.
+	NoUserPresence bool
+
+	// ClientDataType, if set, replaces the type the browser reports in the
+	// client data, e.g. to answer a sign request with TypeRegister.
+	ClientDataType string
+
+	secret          []byte
+	registrations   uint64
+	attestationKey  *ecdsa.PrivateKey
+	attestationCert []byte
+}
+
+// New returns an Authenticator with a random device secret.
+func New() *Authenticator {
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		panic(err)
+	}
+	return NewFromSeed(seed)
+}
+
+// NewFromSeed returns an Authenticator whose keys, key handles, attestation
+// certificate and signatures are all derived from seed, so that a test makes
+// the same ones every run.
+func NewFromSeed(seed []byte) *Authenticator {
+	a := &Authenticator{secret: append([]byte{}, seed...)}
+	a.attestationKey = a.deriveKey([]byte("attestation"))
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "aeu2ftest attestation"},
+		NotBefore:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	// With no random source, the certificate is signed as by sign.
+	cert, err := x509.CreateCertificate(nil, tmpl, tmpl, &a.attestationKey.PublicKey, a.attestationKey)
+	if err != nil {
+		panic(err)
+	}
+	a.attestationCert = cert
+	return a
+}
+
+// AttestationCert returns the DER encoded, self-signed attestation
+// certificate the authenticator includes in registrations.
+func (a *Authenticator) AttestationCert() []byte {
+	return a.attestationCert
+}
+
+// --- mac ---
+// Return the HMAC of the parts under the device secret.
+func (a *Authenticator) mac(parts ...[]byte) []byte {
+	h := hmac.New(sha256.New, a.secret)
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// --- deriveKey ---
+// Return the P-256 key derived from the device secret and label.
+func (a *Authenticator) deriveKey(label []byte) *ecdsa.PrivateKey {
+	n := new(big.Int).Sub(elliptic.P256().Params().N, big.NewInt(1))
+	d := new(big.Int).SetBytes(a.mac([]byte("key"), label))
+	d.Mod(d, n).Add(d, big.NewInt(1))
+
+	k, err := ecdh.P256().NewPrivateKey(d.FillBytes(make([]byte, 32)))
+	if err != nil {
+		panic(err)
+	}
+	pub := k.PublicKey().Bytes()
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(pub[1:33]),
+			Y:     new(big.Int).SetBytes(pub[33:]),
+		},
+		D: d,
+	}
+}
+
+// --- keyHandle ---
+// Return a new key handle for the AppID: a nonce, and a MAC binding it to
+// the AppID.
+func (a *Authenticator) keyHandle(appParam []byte) []byte {
+	a.registrations++
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], a.registrations)
+	nonce := a.mac([]byte("nonce"), n[:])
+	return append(nonce, a.mac([]byte("handle"), appParam, nonce)...)
+}
+
+// --- unwrap ---
+// Return the key for a key handle, provided it was made for the AppID.
+func (a *Authenticator) unwrap(keyHandle, appParam []byte) (*ecdsa.PrivateKey, error) {
+	if len(keyHandle) != 64 || !hmac.Equal(keyHandle[32:], a.mac([]byte("handle"), appParam, keyHandle[:32])) {
+		return nil, ErrUnknownKeyHandle
+	}
+	return a.deriveKey(keyHandle), nil
+}
+
+// --- clientData ---
+// Return the client data the browser would send, and its hash.
+func (a *Authenticator) clientData(typ, challenge, appID string) ([]byte, []byte) {
+	origin := a.Origin
+	if origin == "" {
+		origin = appID
+	}
+	if a.ClientDataType != "" {
+		typ = a.ClientDataType
+	}
+	cd, _ := json.Marshal(u2f.ClientData{Typ: typ, Challenge: challenge, Origin: origin})
+	sum := sha256.Sum256(cd)
+	return cd, sum[:]
+}
+
+// --- sign ---
+// Return the ASN.1 ECDSA signature of the SHA-256 of msg.  It is the
+// deterministic signature of RFC 6979, since the key is derived from the
+// seed, and the random source of ecdsa.SignASN1 cannot be.
+func sign(key *ecdsa.PrivateKey, msg []byte) ([]byte, error) {
+	digest := sha256.Sum256(msg)
+	sig, err := key.Sign(nil, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("key.Sign error: %v", err)
+	}
+	return sig, nil
+}
+
+
+// Register answers a registration request with a new key.
+func (a *Authenticator) Register(req *u2f.RegisterRequest) (*u2f.RegisterResponse, error) {
+	appParam := sha256.Sum256([]byte(req.AppID))
+	clientData, challengeParam := a.clientData(TypeRegister, req.Challenge, req.AppID)
+
+	kh := a.keyHandle(appParam[:])
+	key := a.deriveKey(kh)
+	pub := elliptic.Marshal(elliptic.P256(), key.X, key.Y)
+
+	msg := []byte{0}
+	msg = append(msg, appParam[:]...)
+	msg = append(msg, challengeParam...)
+	msg = append(msg, kh...)
+	msg = append(msg, pub...)
+	sig, err := sign(a.attestationKey, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	data := []byte{5}
+	data = append(data, pub...)
+	data = append(data, byte(len(kh)))
+	data = append(data, kh...)
+	data = append(data, a.attestationCert...)
+	data = append(data, sig...)
+
+	return &u2f.RegisterResponse{
+		RegistrationData: base64.RawURLEncoding.EncodeToString(data),
+		ClientData:       base64.RawURLEncoding.EncodeToString(clientData),
+	}, nil
+}
+
+
+// Sign answers a sign request, incrementing Counter, or returns
+// ErrUnknownKeyHandle.
+func (a *Authenticator) Sign(req *u2f.SignRequest) (*u2f.SignResponse, error) {
+	kh, err := base64.RawURLEncoding.DecodeString(req.KeyHandle)
+	if err != nil {
+		return nil, ErrUnknownKeyHandle
+	}
+	appParam := sha256.Sum256([]byte(req.AppID))
+	key, err := a.unwrap(kh, appParam[:])
+	if err != nil {
+		return nil, err
+	}
+
+	a.Counter++
+	raw := []byte{1, 0, 0, 0, 0}
+	if a.NoUserPresence {
+		raw[0] = 0
+	}
+	binary.BigEndian.PutUint32(raw[1:], a.Counter)
+
+	clientData, challengeParam := a.clientData(TypeSign, req.Challenge, req.AppID)
+	msg := append(appParam[:], raw...)
+	msg = append(msg, challengeParam...)
+	sig, err := sign(key, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &u2f.SignResponse{
+		KeyHandle:     req.KeyHandle,
+		SignatureData: base64.RawURLEncoding.EncodeToString(append(raw, sig...)),
+		ClientData:    base64.RawURLEncoding.EncodeToString(clientData),
+	}, nil
+}
+
+
+// SignAny answers the first of the requests for one of the authenticator's
+// keys, as the browser does with the requests from aeu2f.NewSignChallenge.
+func (a *Authenticator) SignAny(reqs []*u2f.SignRequest) (*u2f.SignResponse, error) {
+	for _, req := range reqs {
+		resp, err := a.Sign(req)
+		if err != ErrUnknownKeyHandle {
+			return resp, err
+		}
+	}
+	return nil, ErrUnknownKeyHandle
+}
@@ -0,0 +1,174 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2ftest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/tstranex/u2f"
+)
+
+const testAppID = "https://aeu2f.example.com"
+
+// register registers a new key of a with u2f.
+func register(t *testing.T, a *Authenticator) *u2f.Registration {
+	c, err := u2f.NewChallenge(testAppID, []string{testAppID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := a.Register(c.RegisterRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := u2f.Register(*resp, *c, &u2f.Config{SkipAttestationVerify: true})
+	if err != nil {
+		t.Fatalf("u2f.Register: %v", err)
+	}
+	return reg
+}
+
+// authenticate signs a new challenge for reg with a, and verifies it with
+// u2f.
+func authenticate(t *testing.T, a *Authenticator, reg *u2f.Registration, counter uint32) (uint32, error) {
+	c, err := u2f.NewChallenge(testAppID, []string{testAppID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := a.Sign(c.SignRequest(*reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reg.Authenticate(*resp, *c, counter)
+}
+
+func TestRegisterSign(t *testing.T) {
+	a := New()
+	reg := register(t, a)
+
+	if !bytes.Equal(reg.AttestationCert.Raw, a.AttestationCert()) {
+		t.Error("Expected the attestation certificate in the registration")
+	}
+
+	for want := uint32(1); want <= 3; want++ {
+		got, err := authenticate(t, a, reg, want-1)
+		if err != nil {
+			t.Fatalf("Authenticate: %v", err)
+		}
+		if got != want {
+			t.Errorf("Expected counter %v, got %v", want, got)
+		}
+	}
+}
+
+func TestSignAny(t *testing.T) {
+	a, b := New(), New()
+	regA, regB := register(t, a), register(t, b)
+
+	c, _ := u2f.NewChallenge(testAppID, []string{testAppID})
+	resp, err := b.SignAny([]*u2f.SignRequest{c.SignRequest(*regA), c.SignRequest(*regB)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := regB.Authenticate(*resp, *c, 0); err != nil {
+		t.Errorf("Expected b to answer its own request: %v", err)
+	}
+
+	if _, err := a.SignAny([]*u2f.SignRequest{c.SignRequest(*regB)}); err != ErrUnknownKeyHandle {
+		t.Errorf("Expected ErrUnknownKeyHandle, got %v", err)
+	}
+}
+
+func TestKeyHandleBoundToAppID(t *testing.T) {
+	a := New()
+	reg := register(t, a)
+
+	c, _ := u2f.NewChallenge("https://evil.example.com", nil)
+	if _, err := a.Sign(c.SignRequest(*reg)); err != ErrUnknownKeyHandle {
+		t.Errorf("Expected ErrUnknownKeyHandle, got %v", err)
+	}
+}
+
+func TestNewFromSeed(t *testing.T) {
+	seed := []byte("aeu2ftest seed")
+	reg1, reg2 := register(t, NewFromSeed(seed)), register(t, NewFromSeed(seed))
+	if !bytes.Equal(reg1.KeyHandle, reg2.KeyHandle) || reg1.PubKey.X.Cmp(reg2.PubKey.X) != 0 {
+		t.Error("Expected the same seed to make the same keys")
+	}
+
+	a := NewFromSeed(seed)
+	if r1, r2 := register(t, a), register(t, a); bytes.Equal(r1.KeyHandle, r2.KeyHandle) {
+		t.Error("Expected each registration to make a new key")
+	}
+
+	// The responses, attestation and signatures included, are the same
+	// byte for byte.
+	c, err := u2f.NewChallenge(testAppID, []string{testAppID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a1, a2 := NewFromSeed(seed), NewFromSeed(seed)
+	if !bytes.Equal(a1.AttestationCert(), a2.AttestationCert()) {
+		t.Error("Expected the same seed to make the same attestation certificate")
+	}
+	var resps [2][2]string
+	for i, a := range []*Authenticator{a1, a2} {
+		resp, err := a.Register(c.RegisterRequest())
+		if err != nil {
+			t.Fatal(err)
+		}
+		reg, err := u2f.Register(*resp, *c, &u2f.Config{SkipAttestationVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := a.Sign(c.SignRequest(*reg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resps[i] = [2]string{resp.RegistrationData, sig.SignatureData}
+	}
+	if resps[0] != resps[1] {
+		t.Errorf("Expected the same seed to make the same responses, got %v", resps)
+	}
+}
+
+func TestEdgeCases(t *testing.T) {
+	a := New()
+	reg := register(t, a)
+
+	a.NoUserPresence = true
+	if _, err := authenticate(t, a, reg, 0); err == nil {
+		t.Error("Expected a signature without user presence to fail")
+	}
+	a.NoUserPresence = false
+
+	a.Origin = "https://evil.example.com"
+	if _, err := authenticate(t, a, reg, 0); err == nil {
+		t.Error("Expected an untrusted origin to fail")
+	}
+	a.Origin = ""
+
+	a.ClientDataType = TypeRegister
+	c, _ := u2f.NewChallenge(testAppID, []string{testAppID})
+	resp, err := a.Sign(c.SignRequest(*reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cd, _ := base64.RawURLEncoding.DecodeString(resp.ClientData)
+	var clientData u2f.ClientData
+	if err := json.Unmarshal(cd, &clientData); err != nil || clientData.Typ != TypeRegister {
+		t.Errorf("Expected client data of type %v, got %s", TypeRegister, cd)
+	}
+	a.ClientDataType = ""
+
+	a.Counter = 0
+	if _, err := authenticate(t, a, reg, 10); err == nil {
+		t.Error("Expected a counter going backwards to fail")
+	}
+}
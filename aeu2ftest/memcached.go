@@ -0,0 +1,237 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2ftest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memcached is a stand-in for a memcached server, to test e.g.
+// aeu2f.MemcacheCache without one:
+//
+// 	m, _ := aeu2ftest.NewMemcached()
+// 	defer m.Close()
+// 	cache := aeu2f.NewMemcacheCache(m.Addr(), time.Minute)
+//
+// It speaks the get, set, delete and quit commands of the memcache text
+// protocol, and keeps every item until it expires or is deleted.
+type Memcached struct {
+	l net.Listener
+
+	mu     sync.Mutex
+	items  map[string]memcachedItem
+	conns  map[net.Conn]bool
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type memcachedItem struct {
+	flags   string
+	data    []byte
+	expires time.Time // zero for never
+}
+
+// NewMemcached starts a Memcached on a port of localhost.
+func NewMemcached() (*Memcached, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Memcached{l: l, items: map[string]memcachedItem{}, conns: map[net.Conn]bool{}}
+	m.wg.Add(1)
+	go m.accept()
+	return m, nil
+}
+
+// Addr returns the host:port m listens on.
+func (m *Memcached) Addr() string {
+	return m.l.Addr().String()
+}
+
+// Len returns the number of unexpired items.
+func (m *Memcached) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, it := range m.items {
+		if !it.expired() {
+			n++
+		}
+	}
+	return n
+}
+
+// Close stops m, closing its connections.
+func (m *Memcached) Close() error {
+	err := m.l.Close()
+	m.mu.Lock()
+	m.closed = true
+	for c := range m.conns {
+		c.Close()
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+	return err
+}
+
+func (it memcachedItem) expired() bool {
+	return !it.expires.IsZero() && time.Now().After(it.expires)
+}
+
+// --- accept ---
+// Serve each connection, until the listener is closed.
+func (m *Memcached) accept() {
+	defer m.wg.Done()
+	for {
+		c, err := m.l.Accept()
+		if err != nil {
+			return
+		}
+
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			c.Close()
+			return
+		}
+		m.conns[c] = true
+		m.wg.Add(1)
+		m.mu.Unlock()
+		go func() {
+			defer m.wg.Done()
+			m.serve(c)
+			c.Close()
+
+			m.mu.Lock()
+			delete(m.conns, c)
+			m.mu.Unlock()
+		}()
+	}
+}
+
+// --- serve ---
+// Answer the commands on c, until it is closed or sends quit.
+func (m *Memcached) serve(c net.Conn) {
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			io.WriteString(c, "ERROR\r\n")
+			continue
+		}
+
+		var resp string
+		switch f[0] {
+		case "get", "gets":
+			resp = m.get(f[1:])
+		case "set":
+			// set <key> <flags> <exptime> <bytes> [noreply]
+			if len(f) < 5 {
+				resp = "CLIENT_ERROR bad command line format\r\n"
+				break
+			}
+			n, err := strconv.Atoi(f[4])
+			if err != nil || n < 0 {
+				resp = "CLIENT_ERROR bad data chunk\r\n"
+				break
+			}
+			data := make([]byte, n+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			if string(data[n:]) != "\r\n" {
+				resp = "CLIENT_ERROR bad data chunk\r\n"
+				break
+			}
+			resp = m.set(f[1], f[2], f[3], data[:n])
+		case "delete":
+			if len(f) < 2 {
+				resp = "ERROR\r\n"
+				break
+			}
+			resp = m.delete(f[1])
+		case "quit":
+			return
+		default:
+			resp = "ERROR\r\n"
+		}
+
+		if f[len(f)-1] == "noreply" {
+			continue
+		}
+		if _, err := io.WriteString(c, resp); err != nil {
+			return
+		}
+	}
+}
+
+// --- get ---
+func (m *Memcached) get(keys []string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	for _, k := range keys {
+		it, ok := m.items[k]
+		if !ok || it.expired() {
+			continue
+		}
+		fmt.Fprintf(&b, "VALUE %s %s %d\r\n%s\r\n", k, it.flags, len(it.data), it.data)
+	}
+	b.WriteString("END\r\n")
+	return b.String()
+}
+
+// --- set ---
+func (m *Memcached) set(key, flags, exptime string, data []byte) string {
+	exp, err := strconv.ParseInt(exptime, 10, 64)
+	if err != nil {
+		return "CLIENT_ERROR bad command line format\r\n"
+	}
+
+	// As memcached: up to 30 days is relative, more is a Unix time, and
+	// negative has already expired.
+	it := memcachedItem{flags: flags, data: data}
+	switch {
+	case exp < 0:
+		it.expires = time.Unix(0, 0)
+	case exp > 30*24*60*60:
+		it.expires = time.Unix(exp, 0)
+	case exp > 0:
+		it.expires = time.Now().Add(time.Duration(exp) * time.Second)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items[key] = it
+	return "STORED\r\n"
+}
+
+// --- delete ---
+func (m *Memcached) delete(key string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if it, ok := m.items[key]; !ok || it.expired() {
+		return "NOT_FOUND\r\n"
+	}
+	delete(m.items, key)
+	return "DELETED\r\n"
+}
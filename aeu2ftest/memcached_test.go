@@ -0,0 +1,63 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2ftest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestMemcached(t *testing.T) {
+	m, err := NewMemcached()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	c, err := net.Dial("tcp", m.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r := bufio.NewReader(c)
+
+	cases := []struct {
+		send, want string
+	}{
+		{"get k\r\n", "END\r\n"},
+		{"set k 7 0 5\r\nhello\r\n", "STORED\r\n"},
+		{"get k other\r\n", "VALUE k 7 5\r\nhello\r\nEND\r\n"},
+		{"set gone 0 -1 1\r\nx\r\n", "STORED\r\n"},
+		{"get gone\r\n", "END\r\n"},
+		{"delete k\r\n", "DELETED\r\n"},
+		{"delete k\r\n", "NOT_FOUND\r\n"},
+		{"set k 0 0 1 noreply\r\nx\r\nget k\r\n", "VALUE k 0 1\r\nx\r\nEND\r\n"},
+		{"bogus\r\n", "ERROR\r\n"},
+	}
+	for _, tc := range cases {
+		if _, err := io.WriteString(c, tc.send); err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		for i := strings.Count(tc.want, "\n"); i > 0; i-- {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			got += line
+		}
+		if got != tc.want {
+			t.Errorf("%q: expected %q, got %q", tc.send, tc.want, got)
+		}
+	}
+	if n := m.Len(); n != 1 {
+		t.Errorf("Expected 1 item, got %v", n)
+	}
+}
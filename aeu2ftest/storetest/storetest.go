@@ -0,0 +1,771 @@
+//
+// Package storetest checks that an aeu2f.Store behaves as the datastore
+// does: strongly consistent per user, with atomic counter, TOTP and throttle
+// updates, challenges and recovery codes that can be consumed once, and
+// deletion only by the owning user.
+//
+// 	func TestStore(t *testing.T) {
+// 		storetest.RunStoreTests(t, func(t *testing.T) (context.Context, aeu2f.Store) {
+// 			return context.Background(), NewMyStore()
+// 		})
+// 	}
+//
+// License: MIT
+//
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tstranex/u2f"
+
+	"github.com/brianmhunt/aeu2f-go"
+)
+
+// Factory returns an empty Store, and the context to use it with.  It is
+// called for each test, which may register cleanup with t.Cleanup.
+type Factory func(t *testing.T) (context.Context, aeu2f.Store)
+
+// concurrency is the number of goroutines racing in the concurrency tests.
+const concurrency = 10
+
+// RunStoreTests runs the conformance tests against stores from factory, each
+// as a subtest.
+func RunStoreTests(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, context.Context, aeu2f.Store)
+	}{
+		{"Challenges", testChallenges},
+		{"CountChallenges", testCountChallenges},
+		{"DeleteChallenges", testDeleteChallenges},
+		{"Registrations", testRegistrations},
+		{"UpdateRegistration", testUpdateRegistration},
+		{"CompareAndSwapCounter", testCompareAndSwapCounter},
+		{"DeleteRegistration", testDeleteRegistration},
+		{"RecoveryCodes", testRecoveryCodes},
+		{"TOTPs", testTOTPs},
+		{"Throttles", testThrottles},
+		{"Users", testUsers},
+		{"Copies", testCopies},
+		{"ConcurrentCounter", testConcurrentCounter},
+		{"ConcurrentRecoveryCode", testConcurrentRecoveryCode},
+		{"ConcurrentTOTP", testConcurrentTOTP},
+		{"ConcurrentThrottle", testConcurrentThrottle},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, s := factory(t)
+			tt.fn(t, ctx, s)
+		})
+	}
+}
+
+// --- sameTime ---
+// Report whether a and b are equal to the microsecond, the precision of the
+// datastore.
+func sameTime(a, b time.Time) bool {
+	return a.Truncate(time.Microsecond).Equal(b.Truncate(time.Microsecond))
+}
+
+// --- now ---
+// Return the current time, to the microsecond.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+// --- newChallenge ---
+func newChallenge(t *testing.T) *u2f.Challenge {
+	c, err := u2f.NewChallenge("https://aeu2f.example.com", []string{"https://aeu2f.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Timestamp = now()
+	return c
+}
+
+// --- putRegistration ---
+func putRegistration(t *testing.T, ctx context.Context, s aeu2f.Store, user string, counter int64) *aeu2f.Registration {
+	regi := &aeu2f.Registration{
+		UserIdentity:         user,
+		U2FRegistrationBytes: []byte("registration of " + user),
+		KeyHandle:            []byte("key handle of " + user),
+		PublicKey:            []byte("public key of " + user),
+		Counter:              counter,
+		Created:              now(),
+		Name:                 "key of " + user,
+		Properties:           []byte(`{"owner":"` + user + `"}`),
+	}
+	id, err := s.PutRegistration(ctx, regi)
+	if err != nil {
+		t.Fatalf("PutRegistration: %v", err)
+	}
+	if id == 0 {
+		t.Fatal("Expected PutRegistration to return a non-zero ID")
+	}
+	regi.ID = id
+	return regi
+}
+
+// --- registrationIDs ---
+// Return the sorted IDs of the user's registrations.
+func registrationIDs(t *testing.T, ctx context.Context, s aeu2f.Store, user string) []int64 {
+	regis, err := s.Registrations(ctx, user)
+	if err != nil {
+		t.Fatalf("Registrations: %v", err)
+	}
+	ids := []int64{}
+	for _, regi := range regis {
+		ids = append(ids, regi.ID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// --- sorted ---
+func sorted(ids ...int64) []int64 {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// --- counter ---
+// Return the stored counter of the user's registration with the given ID.
+func counter(t *testing.T, ctx context.Context, s aeu2f.Store, user string, id int64) int64 {
+	regis, err := s.Registrations(ctx, user)
+	if err != nil {
+		t.Fatalf("Registrations: %v", err)
+	}
+	for _, regi := range regis {
+		if regi.ID == id {
+			return regi.Counter
+		}
+	}
+	t.Fatalf("No registration %v", id)
+	return 0
+}
+
+func testChallenges(t *testing.T, ctx context.Context, s aeu2f.Store) {
+	if _, err := s.GetChallenge(ctx, aeu2f.ChallengeRegister, "alice"); err != aeu2f.ErrNoChallenge {
+		t.Errorf("Expected ErrNoChallenge before any challenge, got %v", err)
+	}
+
+	reg, sign := newChallenge(t), newChallenge(t)
+	if err := s.PutChallenge(ctx, aeu2f.ChallengeRegister, "alice", reg); err != nil {
+		t.Fatalf("PutChallenge: %v", err)
+	}
+	if err := s.PutChallenge(ctx, aeu2f.ChallengeSign, "alice", sign); err != nil {
+		t.Fatalf("PutChallenge: %v", err)
+	}
+
+	got, err := s.GetChallenge(ctx, aeu2f.ChallengeRegister, "alice")
+	if err != nil {
+		t.Fatalf("GetChallenge: %v", err)
+	}
+	if !reflect.DeepEqual(got.Challenge, reg.Challenge) || got.AppID != reg.AppID ||
+		!reflect.DeepEqual(got.TrustedFacets, reg.TrustedFacets) || !sameTime(got.Timestamp, reg.Timestamp) {
+		t.Errorf("Expected challenge %+v, got %+v", reg, got)
+	}
+	if got, _ := s.GetChallenge(ctx, aeu2f.ChallengeSign, "alice"); got == nil || !reflect.DeepEqual(got.Challenge, sign.Challenge) {
+		t.Error("Expected the kinds of challenge to be stored separately")
+	}
+	if _, err := s.GetChallenge(ctx, aeu2f.ChallengeRegister, "bob"); err != aeu2f.ErrNoChallenge {
+		t.Errorf("Expected ErrNoChallenge for another user, got %v", err)
+	}
+
+	// A new challenge replaces the old.
+	reg2 := newChallenge(t)
+	if err := s.PutChallenge(ctx, aeu2f.ChallengeRegister, "alice", reg2); err != nil {
+		t.Fatalf("PutChallenge: %v", err)
+	}
+	if got, _ := s.GetChallenge(ctx, aeu2f.ChallengeRegister, "alice"); got == nil || !reflect.DeepEqual(got.Challenge, reg2.Challenge) {
+		t.Error("Expected the new challenge to replace the old")
+	}
+
+	// Consumed challenges are gone, and deleting again is not an error.
+	for i := 0; i < 2; i++ {
+		if err := s.DeleteChallenge(ctx, aeu2f.ChallengeRegister, "alice"); err != nil {
+			t.Fatalf("DeleteChallenge: %v", err)
+		}
+	}
+	if _, err := s.GetChallenge(ctx, aeu2f.ChallengeRegister, "alice"); err != aeu2f.ErrNoChallenge {
+		t.Errorf("Expected ErrNoChallenge after deletion, got %v", err)
+	}
+	if _, err := s.GetChallenge(ctx, aeu2f.ChallengeSign, "alice"); err != nil {
+		t.Errorf("Expected the sign challenge to remain, got %v", err)
+	}
+}
+
+func testCountChallenges(t *testing.T, ctx context.Context, s aeu2f.Store) {
+	old, recent := newChallenge(t), newChallenge(t)
+	old.Timestamp = now().Add(-time.Hour)
+	for user, c := range map[string]*u2f.Challenge{"alice": old, "bob": recent, "carol": recent} {
+		if err := s.PutChallenge(ctx, aeu2f.ChallengeSign, user, c); err != nil {
+			t.Fatalf("PutChallenge: %v", err)
+		}
+	}
+	if err := s.PutChallenge(ctx, aeu2f.ChallengeRegister, "dave", recent); err != nil {
+		t.Fatalf("PutChallenge: %v", err)
+	}
+
+	since := now().Add(-time.Minute)
+	if n, err := s.CountChallenges(ctx, aeu2f.ChallengeSign, since); err != nil || n != 2 {
+		t.Errorf("Expected 2 recent sign challenges, got %v, %v", n, err)
+	}
+	if n, err := s.CountChallenges(ctx, aeu2f.ChallengeRegister, since); err != nil || n != 1 {
+		t.Errorf("Expected 1 recent registration challenge, got %v, %v", n, err)
+	}
+
+	if err := s.DeleteChallenge(ctx, aeu2f.ChallengeSign, "bob"); err != nil {
+		t.Fatalf("DeleteChallenge: %v", err)
+	}
+	if n, err := s.CountChallenges(ctx, aeu2f.ChallengeSign, since); err != nil || n != 1 {
+		t.Errorf("Expected 1 sign challenge after deletion, got %v, %v", n, err)
+	}
+}
+
+func testDeleteChallenges(t *testing.T, ctx context.Context, s aeu2f.Store) {
+	old, recent := newChallenge(t), newChallenge(t)
+	old.Timestamp = now().Add(-time.Hour)
+	for user, c := range map[string]*u2f.Challenge{"alice": old, "bob": old, "carol": recent} {
+		if err := s.PutChallenge(ctx, aeu2f.ChallengeSign, user, c); err != nil {
+			t.Fatalf("PutChallenge: %v", err)
+		}
+	}
+	if err := s.PutChallenge(ctx, aeu2f.ChallengeRegister, "dave", old); err != nil {
+		t.Fatalf("PutChallenge: %v", err)
+	}
+
+	before := now().Add(-time.Minute)
+	if n, err := s.DeleteChallenges(ctx, aeu2f.ChallengeSign, before); err != nil || n != 2 {
+		t.Errorf("Expected 2 old sign challenges deleted, got %v, %v", n, err)
+	}
+	for user, want := range map[string]error{"alice": aeu2f.ErrNoChallenge, "bob": aeu2f.ErrNoChallenge, "carol": nil} {
+		if _, err := s.GetChallenge(ctx, aeu2f.ChallengeSign, user); err != want {
+			t.Errorf("Expected GetChallenge for %v to return %v, got %v", user, want, err)
+		}
+	}
+	if _, err := s.GetChallenge(ctx, aeu2f.ChallengeRegister, "dave"); err != nil {
+		t.Errorf("Expected the registration challenge to remain, got %v", err)
+	}
+	if n, err := s.DeleteChallenges(ctx, aeu2f.ChallengeSign, before); err != nil || n != 0 {
+		t.Errorf("Expected nothing more to delete, got %v, %v", n, err)
+	}
+}
+
+func testRegistrations(t *testing.T, ctx context.Context, s aeu2f.Store) {
+	if ids := registrationIDs(t, ctx, s, "alice"); len(ids) != 0 {
+		t.Errorf("Expected no registrations, got %v", ids)
+	}
+
+	a1 := putRegistration(t, ctx, s, "alice", 0)
+	a2 := putRegistration(t, ctx, s, "alice", 7)
+	b1 := putRegistration(t, ctx, s, "bob", 0)
+	if a1.ID == a2.ID || a1.ID == b1.ID || a2.ID == b1.ID {
+		t.Errorf("Expected distinct IDs, got %v, %v and %v", a1.ID, a2.ID, b1.ID)
+	}
+
+	if got, want := registrationIDs(t, ctx, s, "alice"), sorted(a1.ID, a2.ID); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected registrations %v, got %v", want, got)
+	}
+	if got, want := registrationIDs(t, ctx, s, "bob"), sorted(b1.ID); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected registrations %v, got %v", want, got)
+	}
+
+	regis, _ := s.Registrations(ctx, "alice")
+	for _, regi := range regis {
+		want := a1
+		if regi.ID == a2.ID {
+			want = a2
+		}
+		if regi.UserIdentity != want.UserIdentity || regi.Counter != want.Counter ||
+			!reflect.DeepEqual(regi.U2FRegistrationBytes, want.U2FRegistrationBytes) ||
+			!reflect.DeepEqual(regi.KeyHandle, want.KeyHandle) ||
+			!reflect.DeepEqual(regi.PublicKey, want.PublicKey) ||
+			!reflect.DeepEqual(regi.Properties, want.Properties) ||
+			regi.Name != want.Name || !regi.Revoked.IsZero() ||
+			!sameTime(regi.Created, want.Created) {
+			t.Errorf("Expected registration %+v, got %+v", want, regi)
+		}
+	}
+}
+
+func testUpdateRegistration(t *testing.T, ctx context.Context, s aeu2f.Store) {
+	a := putRegistration(t, ctx, s, "alice", 5)
+
+	revoked := now()
+	got, err := s.UpdateRegistration(ctx, "alice", a.ID, func(regi *aeu2f.Registration) error {
+		regi.Name = "backup"
+		regi.Revoked = revoked
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateRegistration: %v", err)
+	}
+	if got.ID != a.ID || got.Name != "backup" || !sameTime(got.Revoked, revoked) || got.Counter != 5 ||
+		!reflect.DeepEqual(got.KeyHandle, a.KeyHandle) {
+		t.Errorf("Expected the updated registration, got %+v", got)
+	}
+
+	// An update that fails is not stored, and its error is returned.
+	errUpdate := errors.New("update failed")
+	if _, err := s.UpdateRegistration(ctx, "alice", a.ID, func(regi *aeu2f.Registration) error {
+		regi.Name = "lost"
+		return errUpdate
+	}); err != errUpdate {
+		t.Errorf("Expected the update's error, got %v", err)
+	}
+	regis, _ := s.Registrations(ctx, "alice")
+	if len(regis) != 1 || regis[0].Name != "backup" || !sameTime(regis[0].Revoked, revoked) {
+		t.Errorf("Expected the failed update not to be stored, got %+v", regis)
+	}
+
+	if _, err := s.UpdateRegistration(ctx, "bob", a.ID, func(*aeu2f.Registration) error { return nil }); err != aeu2f.ErrNoSuchRegistration {
+		t.Errorf("Expected ErrNoSuchRegistration updating another user's, got %v", err)
+	}
+	if err := s.DeleteRegistration(ctx, "alice", a.ID); err != nil {
+		t.Fatalf("DeleteRegistration: %v", err)
+	}
+	if _, err := s.UpdateRegistration(ctx, "alice", a.ID, func(*aeu2f.Registration) error { return nil }); err != aeu2f.ErrNoSuchRegistration {
+		t.Errorf("Expected ErrNoSuchRegistration updating a deleted registration, got %v", err)
+	}
+}
+
+func testCompareAndSwapCounter(t *testing.T, ctx context.Context, s aeu2f.Store) {
+	regi := putRegistration(t, ctx, s, "alice", 5)
+
+	cur, ok, err := s.CompareAndSwapCounter(ctx, regi.ID, 5, 6)
+	if err != nil || !ok {
+		t.Fatalf("Expected the swap to succeed, got %v, %v", ok, err)
+	}
+	if cur.ID != regi.ID || cur.Counter != 6 || cur.UserIdentity != "alice" {
+		t.Errorf("Expected the updated registration, got %+v", cur)
+	}
+	if c := counter(t, ctx, s, "alice", regi.ID); c != 6 {
+		t.Errorf("Expected counter 6, got %v", c)
+	}
+
+	// A stale counter fails, and returns the current registration.
+	cur, ok, err = s.CompareAndSwapCounter(ctx, regi.ID, 5, 7)
+	if err != nil || ok {
+		t.Fatalf("Expected the swap to fail, got %v, %v", ok, err)
+	}
+	if cur.ID != regi.ID || cur.Counter != 6 {
+		t.Errorf("Expected the current registration, got %+v", cur)
+	}
+	if c := counter(t, ctx, s, "alice", regi.ID); c != 6 {
+		t.Errorf("Expected counter 6, got %v", c)
+	}
+
+	if _, _, err := s.CompareAndSwapCounter(ctx, regi.ID+1000, 0, 1); err != aeu2f.ErrNoSuchRegistration {
+		t.Errorf("Expected ErrNoSuchRegistration, got %v", err)
+	}
+
+	// A revoked registration's counter is not swapped, even from the
+	// current counter.
+	if _, err := s.UpdateRegistration(ctx, "alice", regi.ID, func(r *aeu2f.Registration) error {
+		r.Revoked = now()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cur, ok, err = s.CompareAndSwapCounter(ctx, regi.ID, 6, 7)
+	if err != aeu2f.ErrRevoked || ok {
+		t.Errorf("Expected ErrRevoked, got %v, %v", ok, err)
+	}
+	if cur == nil || cur.ID != regi.ID || cur.Revoked.IsZero() {
+		t.Errorf("Expected the revoked registration, got %+v", cur)
+	}
+	if c := counter(t, ctx, s, "alice", regi.ID); c != 6 {
+		t.Errorf("Expected counter 6, got %v", c)
+	}
+}
+
+func testDeleteRegistration(t *testing.T, ctx context.Context, s aeu2f.Store) {
+	a := putRegistration(t, ctx, s, "alice", 0)
+	b := putRegistration(t, ctx, s, "bob", 0)
+
+	if err := s.DeleteRegistration(ctx, "bob", a.ID); err != aeu2f.ErrNoSuchRegistration {
+		t.Errorf("Expected ErrNoSuchRegistration for another user's, got %v", err)
+	}
+	if got := registrationIDs(t, ctx, s, "alice"); !reflect.DeepEqual(got, []int64{a.ID}) {
+		t.Errorf("Expected another user's deletion to leave the registration, got %v", got)
+	}
+
+	if err := s.DeleteRegistration(ctx, "alice", a.ID); err != nil {
+		t.Fatalf("DeleteRegistration: %v", err)
+	}
+	if got := registrationIDs(t, ctx, s, "alice"); len(got) != 0 {
+		t.Errorf("Expected no registrations after deletion, got %v", got)
+	}
+	if got := registrationIDs(t, ctx, s, "bob"); !reflect.DeepEqual(got, []int64{b.ID}) {
+		t.Errorf("Expected the other user's registration to remain, got %v", got)
+	}
+
+	if err := s.DeleteRegistration(ctx, "alice", a.ID); err != aeu2f.ErrNoSuchRegistration {
+		t.Errorf("Expected ErrNoSuchRegistration deleting twice, got %v", err)
+	}
+	if _, _, err := s.CompareAndSwapCounter(ctx, a.ID, 0, 1); err != aeu2f.ErrNoSuchRegistration {
+		t.Errorf("Expected ErrNoSuchRegistration swapping a deleted counter, got %v", err)
+	}
+}
+
+// --- recoveryCodes ---
+// Store n recovery codes for the user, with hashes prefixed by tag.
+func recoveryCodes(t *testing.T, ctx context.Context, s aeu2f.Store, user, tag string, n int) {
+	rcs := make([]*aeu2f.RecoveryCode, n)
+	for i := range rcs {
+		rcs[i] = &aeu2f.RecoveryCode{
+			UserIdentity: user,
+			Salt:         []byte(fmt.Sprint("salt", i)),
+			Hash:         []byte(fmt.Sprint(tag, i)),
+			Created:      now(),
+		}
+	}
+	if err := s.ReplaceRecoveryCodes(ctx, user, rcs); err != nil {
+		t.Fatalf("ReplaceRecoveryCodes: %v", err)
+	}
+}
+
+// --- loadRecoveryCodes ---
+func loadRecoveryCodes(t *testing.T, ctx context.Context, s aeu2f.Store, user string) []*aeu2f.RecoveryCode {
+	rcs, err := s.RecoveryCodes(ctx, user)
+	if err != nil {
+		t.Fatalf("RecoveryCodes: %v", err)
+	}
+	return rcs
+}
+
+func testRecoveryCodes(t *testing.T, ctx context.Context, s aeu2f.Store) {
+	if rcs := loadRecoveryCodes(t, ctx, s, "alice"); len(rcs) != 0 {
+		t.Errorf("Expected no recovery codes, got %v", len(rcs))
+	}
+
+	recoveryCodes(t, ctx, s, "alice", "old", 3)
+	recoveryCodes(t, ctx, s, "bob", "bob", 2)
+
+	rcs := loadRecoveryCodes(t, ctx, s, "alice")
+	if len(rcs) != 3 {
+		t.Fatalf("Expected 3 recovery codes, got %v", len(rcs))
+	}
+	ids := map[int64]bool{}
+	for _, rc := range rcs {
+		if rc.ID == 0 || ids[rc.ID] {
+			t.Errorf("Expected distinct, non-zero IDs, got %v", rc.ID)
+		}
+		ids[rc.ID] = true
+		if rc.UserIdentity != "alice" || len(rc.Salt) == 0 || string(rc.Hash[:3]) != "old" {
+			t.Errorf("Unexpected recovery code %+v", rc)
+		}
+	}
+
+	// Replacing removes all of the old codes, and only the user's.
+	recoveryCodes(t, ctx, s, "alice", "new", 2)
+	rcs = loadRecoveryCodes(t, ctx, s, "alice")
+	if len(rcs) != 2 {
+		t.Fatalf("Expected 2 recovery codes after replacing, got %v", len(rcs))
+	}
+	for _, rc := range rcs {
+		if string(rc.Hash[:3]) != "new" {
+			t.Errorf("Expected only new codes, got %q", rc.Hash)
+		}
+	}
+	if n := len(loadRecoveryCodes(t, ctx, s, "bob")); n != 2 {
+		t.Errorf("Expected another user's codes to remain, got %v", n)
+	}
+
+	// A code can be deleted once, and only by its user.
+	id := rcs[0].ID
+	if err := s.DeleteRecoveryCode(ctx, "bob", id); err != aeu2f.ErrNoSuchRegistration {
+		t.Errorf("Expected ErrNoSuchRegistration for another user's, got %v", err)
+	}
+	if err := s.DeleteRecoveryCode(ctx, "alice", id); err != nil {
+		t.Fatalf("DeleteRecoveryCode: %v", err)
+	}
+	if err := s.DeleteRecoveryCode(ctx, "alice", id); err != aeu2f.ErrNoSuchRegistration {
+		t.Errorf("Expected ErrNoSuchRegistration using a code twice, got %v", err)
+	}
+	rcs = loadRecoveryCodes(t, ctx, s, "alice")
+	if len(rcs) != 1 || rcs[0].ID == id {
+		t.Errorf("Expected the other code to remain, got %v", rcs)
+	}
+
+	// Replacing with none removes them all.
+	if err := s.ReplaceRecoveryCodes(ctx, "alice", nil); err != nil {
+		t.Fatalf("ReplaceRecoveryCodes: %v", err)
+	}
+	if n := len(loadRecoveryCodes(t, ctx, s, "alice")); n != 0 {
+		t.Errorf("Expected no codes, got %v", n)
+	}
+}
+
+// --- putTOTP ---
+func putTOTP(t *testing.T, ctx context.Context, s aeu2f.Store, user string) *aeu2f.TOTP {
+	totp := &aeu2f.TOTP{UserIdentity: user, Secret: []byte("secret of " + user), Created: now()}
+	id, err := s.PutTOTP(ctx, totp)
+	if err != nil {
+		t.Fatalf("PutTOTP: %v", err)
+	}
+	if id == 0 {
+		t.Fatal("Expected PutTOTP to return a non-zero ID")
+	}
+	totp.ID = id
+	return totp
+}
+
+func testTOTPs(t *testing.T, ctx context.Context, s aeu2f.Store) {
+	a := putTOTP(t, ctx, s, "alice")
+	b := putTOTP(t, ctx, s, "bob")
+
+	totps, err := s.TOTPs(ctx, "alice")
+	if err != nil {
+		t.Fatalf("TOTPs: %v", err)
+	}
+	if len(totps) != 1 || totps[0].ID != a.ID || string(totps[0].Secret) != "secret of alice" ||
+		totps[0].Confirmed || !sameTime(totps[0].Created, a.Created) {
+		t.Errorf("Expected the user's TOTP, got %+v", totps)
+	}
+
+	got, err := s.UpdateTOTP(ctx, "alice", a.ID, func(totp *aeu2f.TOTP) error {
+		totp.Confirmed = true
+		totp.LastStep = 42
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateTOTP: %v", err)
+	}
+	if got.ID != a.ID || !got.Confirmed || got.LastStep != 42 || string(got.Secret) != "secret of alice" {
+		t.Errorf("Expected the updated TOTP, got %+v", got)
+	}
+
+	// An update that fails is not stored, and its error is returned.
+	errUpdate := errors.New("update failed")
+	if _, err := s.UpdateTOTP(ctx, "alice", a.ID, func(totp *aeu2f.TOTP) error {
+		totp.LastStep = 99
+		return errUpdate
+	}); err != errUpdate {
+		t.Errorf("Expected the update's error, got %v", err)
+	}
+	totps, _ = s.TOTPs(ctx, "alice")
+	if len(totps) != 1 || !totps[0].Confirmed || totps[0].LastStep != 42 {
+		t.Errorf("Expected the failed update not to be stored, got %+v", totps)
+	}
+
+	if _, err := s.UpdateTOTP(ctx, "bob", a.ID, func(*aeu2f.TOTP) error { return nil }); err != aeu2f.ErrNoSuchRegistration {
+		t.Errorf("Expected ErrNoSuchRegistration updating another user's, got %v", err)
+	}
+
+	if err := s.DeleteTOTP(ctx, "bob", a.ID); err != aeu2f.ErrNoSuchRegistration {
+		t.Errorf("Expected ErrNoSuchRegistration deleting another user's, got %v", err)
+	}
+	if err := s.DeleteTOTP(ctx, "alice", a.ID); err != nil {
+		t.Fatalf("DeleteTOTP: %v", err)
+	}
+	if err := s.DeleteTOTP(ctx, "alice", a.ID); err != aeu2f.ErrNoSuchRegistration {
+		t.Errorf("Expected ErrNoSuchRegistration deleting twice, got %v", err)
+	}
+	if _, err := s.UpdateTOTP(ctx, "alice", a.ID, func(*aeu2f.TOTP) error { return nil }); err != aeu2f.ErrNoSuchRegistration {
+		t.Errorf("Expected ErrNoSuchRegistration updating a deleted TOTP, got %v", err)
+	}
+	if totps, _ := s.TOTPs(ctx, "bob"); len(totps) != 1 || totps[0].ID != b.ID {
+		t.Errorf("Expected the other user's TOTP to remain, got %+v", totps)
+	}
+}
+
+func testThrottles(t *testing.T, ctx context.Context, s aeu2f.Store) {
+	th, err := s.GetThrottle(ctx, "user:alice")
+	if err != nil || th == nil || th.Failures != 0 || !th.LockedUntil.IsZero() {
+		t.Fatalf("Expected a zero throttle, got %+v, %v", th, err)
+	}
+
+	until := now().Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		err := s.UpdateThrottle(ctx, "user:alice", func(th *aeu2f.Throttle) {
+			th.Failures++
+			th.LastFailure = until.Add(-time.Minute)
+			th.LockedUntil = until
+		})
+		if err != nil {
+			t.Fatalf("UpdateThrottle: %v", err)
+		}
+	}
+
+	th, err = s.GetThrottle(ctx, "user:alice")
+	if err != nil || th.Failures != 2 || !sameTime(th.LockedUntil, until) {
+		t.Errorf("Expected 2 failures until %v, got %+v, %v", until, th, err)
+	}
+	if th, _ := s.GetThrottle(ctx, "ip:10.0.0.1"); th == nil || th.Failures != 0 {
+		t.Errorf("Expected throttles to be separate, got %+v", th)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.DeleteThrottle(ctx, "user:alice"); err != nil {
+			t.Fatalf("DeleteThrottle: %v", err)
+		}
+	}
+	if th, err := s.GetThrottle(ctx, "user:alice"); err != nil || th.Failures != 0 {
+		t.Errorf("Expected a zero throttle after deletion, got %+v, %v", th, err)
+	}
+}
+
+func testUsers(t *testing.T, ctx context.Context, s aeu2f.Store) {
+	if users, err := s.Users(ctx); err != nil || len(users) != 0 {
+		t.Errorf("Expected no users, got %v, %v", users, err)
+	}
+
+	putRegistration(t, ctx, s, "carol", 0)
+	putRegistration(t, ctx, s, "alice", 0)
+	putRegistration(t, ctx, s, "alice", 0)
+	recoveryCodes(t, ctx, s, "bob", "code", 2)
+	putTOTP(t, ctx, s, "dave")
+	putTOTP(t, ctx, s, "alice")
+
+	users, err := s.Users(ctx)
+	if want := []string{"alice", "bob", "carol", "dave"}; err != nil || !reflect.DeepEqual(users, want) {
+		t.Errorf("Expected users %v, got %v, %v", want, users, err)
+	}
+}
+
+func testCopies(t *testing.T, ctx context.Context, s aeu2f.Store) {
+	regi := putRegistration(t, ctx, s, "alice", 0)
+	regi.U2FRegistrationBytes[0] = 'X'
+	regi.KeyHandle[0], regi.PublicKey[0], regi.Properties[0] = 'X', 'X', 'X'
+	regi.Counter = 100
+
+	regis, _ := s.Registrations(ctx, "alice")
+	if len(regis) != 1 || regis[0].U2FRegistrationBytes[0] == 'X' || regis[0].KeyHandle[0] == 'X' ||
+		regis[0].PublicKey[0] == 'X' || regis[0].Properties[0] == 'X' || regis[0].Counter != 0 {
+		t.Fatalf("Expected the stored registration not to change with the caller's, got %+v", regis)
+	}
+	regis[0].Counter = 100
+	if c := counter(t, ctx, s, "alice", regi.ID); c != 0 {
+		t.Errorf("Expected the stored counter not to change with a returned registration, got %v", c)
+	}
+
+	c := newChallenge(t)
+	if err := s.PutChallenge(ctx, aeu2f.ChallengeSign, "alice", c); err != nil {
+		t.Fatalf("PutChallenge: %v", err)
+	}
+	want := append([]byte{}, c.Challenge...)
+	c.Challenge[0]++
+	if got, _ := s.GetChallenge(ctx, aeu2f.ChallengeSign, "alice"); got == nil || !reflect.DeepEqual(got.Challenge, want) {
+		t.Error("Expected the stored challenge not to change with the caller's")
+	}
+}
+
+// --- race ---
+// Run fn concurrently, and return the number of calls that report success.
+func race(fn func(i int) bool) int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	wins := 0
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if fn(i) {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return wins
+}
+
+func testConcurrentCounter(t *testing.T, ctx context.Context, s aeu2f.Store) {
+	regi := putRegistration(t, ctx, s, "alice", 0)
+
+	// Each goroutine swaps the same old counter for its own new one; one
+	// may win, and the others must see its counter.
+	var mu sync.Mutex
+	var winner int64
+	wins := race(func(i int) bool {
+		counter := int64(i + 1)
+		cur, ok, err := s.CompareAndSwapCounter(ctx, regi.ID, 0, counter)
+		if err != nil {
+			// A contended transaction may fail; it must not have swapped.
+			return false
+		}
+		if ok {
+			mu.Lock()
+			winner = counter
+			mu.Unlock()
+		} else if cur.Counter == 0 {
+			t.Errorf("Expected a failed swap to return the winner's counter, got 0")
+		}
+		return ok
+	})
+
+	if wins != 1 {
+		t.Fatalf("Expected exactly one swap to win, got %v", wins)
+	}
+	if c := counter(t, ctx, s, "alice", regi.ID); c != winner {
+		t.Errorf("Expected the winner's counter %v, got %v", winner, c)
+	}
+}
+
+func testConcurrentRecoveryCode(t *testing.T, ctx context.Context, s aeu2f.Store) {
+	recoveryCodes(t, ctx, s, "alice", "code", 2)
+	rcs := loadRecoveryCodes(t, ctx, s, "alice")
+	id := rcs[0].ID
+
+	wins := race(func(int) bool {
+		return s.DeleteRecoveryCode(ctx, "alice", id) == nil
+	})
+	if wins != 1 {
+		t.Errorf("Expected a recovery code to be used exactly once, got %v", wins)
+	}
+	if rcs := loadRecoveryCodes(t, ctx, s, "alice"); len(rcs) != 1 || rcs[0].ID == id {
+		t.Errorf("Expected only the other code to remain, got %v", rcs)
+	}
+}
+
+func testConcurrentTOTP(t *testing.T, ctx context.Context, s aeu2f.Store) {
+	totp := putTOTP(t, ctx, s, "alice")
+
+	// Each goroutine accepts the same step, as for a replayed code; at most
+	// one may.
+	errUsed := errors.New("code already used")
+	wins := race(func(int) bool {
+		_, err := s.UpdateTOTP(ctx, "alice", totp.ID, func(totp *aeu2f.TOTP) error {
+			if totp.LastStep >= 1 {
+				return errUsed
+			}
+			totp.LastStep = 1
+			return nil
+		})
+		return err == nil
+	})
+	if wins != 1 {
+		t.Errorf("Expected a step to be accepted exactly once, got %v", wins)
+	}
+}
+
+func testConcurrentThrottle(t *testing.T, ctx context.Context, s aeu2f.Store) {
+	wins := race(func(int) bool {
+		return s.UpdateThrottle(ctx, "user:alice", func(th *aeu2f.Throttle) {
+			th.Failures++
+		}) == nil
+	})
+	if wins == 0 {
+		t.Fatal("Expected some updates to succeed")
+	}
+
+	// No successful update may be lost.
+	th, err := s.GetThrottle(ctx, "user:alice")
+	if err != nil {
+		t.Fatalf("GetThrottle: %v", err)
+	}
+	if th.Failures != int64(wins) {
+		t.Errorf("Expected %v failures, got %v", wins, th.Failures)
+	}
+}
@@ -0,0 +1,311 @@
+//
+// Package aeu2ftoken mints and verifies signed, short-lived tokens (JWS
+// compact serialization, i.e. JWTs) that prove a user completed U2F
+// authentication.
+//
+// The issuing server, after aeu2f.Authenticate succeeds:
+// 	issuer := &aeu2ftoken.Issuer{Key: key, Issuer: "https://login.example.com"}
+// 	tok, err := issuer.Issue(userIdentity, regi.ID, time.Now())
+//
+// A downstream service:
+// 	verifier := &aeu2ftoken.Verifier{Keys: keys, Issuer: "https://login.example.com"}
+// 	claims, err := verifier.Verify(tok)
+//
+// Keys carry an ID (the JWS "kid"), so keys can be rotated by giving the
+// Verifier both the old and the new key while tokens signed by the old one
+// expire.
+//
+// This package does not depend on App Engine, so that verifiers need not.
+//
+// License: MIT
+//
+package aeu2ftoken
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Signature algorithms, as JWS "alg" values.
+const (
+	HS256 = "HS256"
+	EdDSA = "EdDSA"
+)
+
+// MethodHardwareKey is the RFC 8176 authentication method reference for
+// proof of possession of a hardware key, i.e. U2F.
+const MethodHardwareKey = "hwk"
+
+// DefaultTTL is how long an issued token is valid, unless configured
+// otherwise.
+const DefaultTTL = 5 * time.Minute
+
+var (
+	// ErrInvalidToken is returned for a token that is malformed, or whose
+	// signature does not verify.
+	ErrInvalidToken = errors.New("aeu2ftoken: invalid token")
+
+	// ErrUnknownKey is returned for a token signed with a key ID the
+	// Verifier does not have.
+	ErrUnknownKey = errors.New("aeu2ftoken: unknown key ID")
+
+	// ErrExpired is returned for a token past its expiry.
+	ErrExpired = errors.New("aeu2ftoken: token expired")
+
+	// ErrNoKey is returned when signing without a key.
+	ErrNoKey = errors.New("aeu2ftoken: no key")
+)
+
+// Claims are the contents of a token.
+type Claims struct {
+	Issuer   string `json:"iss,omitempty"`
+	Audience string `json:"aud,omitempty"`
+	ID       string `json:"jti"`
+
+	// Subject is the user identity.
+	Subject string `json:"sub"`
+
+	// RegistrationID is the aeu2f.Registration ID of the key used.
+	RegistrationID int64 `json:"u2f_rid,omitempty"`
+
+	// AuthTime is when the user authenticated, in Unix seconds.
+	AuthTime int64 `json:"auth_time"`
+
+	// Methods are the RFC 8176 authentication methods used.
+	Methods []string `json:"amr"`
+
+	IssuedAt  int64 `json:"iat"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+// Key signs or verifies tokens.
+type Key struct {
+	// ID identifies the key, for rotation.
+	ID string
+
+	alg     string
+	secret  []byte
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+// MinHMACSecretBytes is the least length of an HS256 secret.
+const MinHMACSecretBytes = 32
+
+// NewHMACKey returns an HS256 key.  The secret must be at least
+// MinHMACSecretBytes random bytes, and must be shared with verifiers.
+func NewHMACKey(id string, secret []byte) (*Key, error) {
+	if len(secret) < MinHMACSecretBytes {
+		return nil, fmt.Errorf("aeu2ftoken: key %q: HMAC secret shorter than %d bytes", id, MinHMACSecretBytes)
+	}
+	return &Key{ID: id, alg: HS256, secret: secret}, nil
+}
+
+// NewEd25519Key returns an EdDSA key that can sign, and verify.
+func NewEd25519Key(id string, private ed25519.PrivateKey) (*Key, error) {
+	if len(private) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("aeu2ftoken: key %q: Ed25519 private key of %d bytes, not %d", id, len(private), ed25519.PrivateKeySize)
+	}
+	return &Key{
+		ID:      id,
+		alg:     EdDSA,
+		private: private,
+		public:  private.Public().(ed25519.PublicKey),
+	}, nil
+}
+
+// NewEd25519PublicKey returns an EdDSA key that can only verify; it is what
+// downstream services should be given.
+func NewEd25519PublicKey(id string, public ed25519.PublicKey) (*Key, error) {
+	if len(public) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("aeu2ftoken: key %q: Ed25519 public key of %d bytes, not %d", id, len(public), ed25519.PublicKeySize)
+	}
+	return &Key{ID: id, alg: EdDSA, public: public}, nil
+}
+
+// Algorithm returns the JWS "alg" of the key.
+func (k *Key) Algorithm() string {
+	return k.alg
+}
+
+func (k *Key) sign(input []byte) ([]byte, error) {
+	switch {
+	case k.alg == HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case k.alg == EdDSA && k.private != nil:
+		return ed25519.Sign(k.private, input), nil
+	}
+	return nil, fmt.Errorf("aeu2ftoken: key %q cannot sign", k.ID)
+}
+
+func (k *Key) verify(input, sig []byte) bool {
+	switch k.alg {
+	case HS256:
+		expected, _ := k.sign(input)
+		return hmac.Equal(sig, expected)
+	case EdDSA:
+		return ed25519.Verify(k.public, input, sig)
+	}
+	return false
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// Issuer mints tokens.
+type Issuer struct {
+	Key *Key
+
+	// Issuer and Audience, if set, are the "iss" and "aud" claims.
+	Issuer   string
+	Audience string
+
+	// TTL is how long tokens are valid; defaults to DefaultTTL.
+	TTL time.Duration
+}
+
+// Issue returns a token for a user who authenticated with the given
+// registration at authTime.
+func (i *Issuer) Issue(userIdentity string, registrationID int64, authTime time.Time) (string, error) {
+	if i.Key == nil {
+		return "", ErrNoKey
+	}
+	ttl := i.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", fmt.Errorf("rand.Read error: %v", err)
+	}
+
+	now := time.Now()
+	return Sign(i.Key, &Claims{
+		Issuer:         i.Issuer,
+		Audience:       i.Audience,
+		ID:             b64.EncodeToString(jti),
+		Subject:        userIdentity,
+		RegistrationID: registrationID,
+		AuthTime:       authTime.Unix(),
+		Methods:        []string{MethodHardwareKey},
+		IssuedAt:       now.Unix(),
+		ExpiresAt:      now.Add(ttl).Unix(),
+	})
+}
+
+// Sign returns the claims signed with the given key.
+func Sign(key *Key, claims *Claims) (string, error) {
+	if key == nil {
+		return "", ErrNoKey
+	}
+	h, err := json.Marshal(header{Alg: key.alg, Typ: "JWT", Kid: key.ID})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	input := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+	sig, err := key.sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + b64.EncodeToString(sig), nil
+}
+
+// Verifier checks tokens.
+type Verifier struct {
+	// Keys are the keys tokens may be signed with, by ID.
+	Keys []*Key
+
+	// Issuer and Audience, if set, must match the token's claims.
+	Issuer   string
+	Audience string
+
+	// Leeway allows for clock skew between issuer and verifier.
+	Leeway time.Duration
+
+	// Now returns the current time; defaults to time.Now.
+	Now func() time.Time
+}
+
+func (v *Verifier) key(id string) *Key {
+	for _, k := range v.Keys {
+		if k != nil && k.ID == id {
+			return k
+		}
+	}
+	return nil
+}
+
+// Verify checks the token's signature, expiry, issuer and audience, and
+// returns its claims.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	if buf, err := b64.DecodeString(parts[0]); err != nil {
+		return nil, ErrInvalidToken
+	} else if err := json.Unmarshal(buf, &h); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key := v.key(h.Kid)
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+
+	// The algorithm is that of the key, never that of the token, so that
+	// e.g. "none" or an HMAC keyed with a public key cannot be forged.
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil || h.Alg != key.alg || !key.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if buf, err := b64.DecodeString(parts[1]); err != nil {
+		return nil, ErrInvalidToken
+	} else if err := json.Unmarshal(buf, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	if now.Add(-v.Leeway).Unix() >= claims.ExpiresAt {
+		return nil, ErrExpired
+	}
+
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return nil, fmt.Errorf("aeu2ftoken: unexpected issuer %q", claims.Issuer)
+	}
+	if v.Audience != "" && claims.Audience != v.Audience {
+		return nil, fmt.Errorf("aeu2ftoken: unexpected audience %q", claims.Audience)
+	}
+	if claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
@@ -0,0 +1,158 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2ftoken
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// mustKey returns the key, failing the test on an error.
+func mustKey(t *testing.T) func(*Key, error) *Key {
+	return func(k *Key, err error) *Key {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+}
+
+func TestHMACRoundTrip(t *testing.T) {
+	key := mustKey(t)(NewHMACKey("k1", testSecret))
+	issuer := &Issuer{Key: key, Issuer: "https://login", Audience: "payouts"}
+	authTime := time.Now().Add(-time.Second)
+
+	tok, err := issuer.Issue("bob", 42, authTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &Verifier{Keys: []*Key{key}, Issuer: "https://login", Audience: "payouts"}
+	claims, err := v.Verify(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != "bob" || claims.RegistrationID != 42 ||
+		claims.AuthTime != authTime.Unix() || claims.ID == "" {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+	if len(claims.Methods) != 1 || claims.Methods[0] != MethodHardwareKey {
+		t.Errorf("Expected amr [hwk], got %v", claims.Methods)
+	}
+
+	v.Audience = "other"
+	if _, err := v.Verify(tok); err == nil {
+		t.Error("Expected the audience to be checked")
+	}
+}
+
+func TestEd25519Rotation(t *testing.T) {
+	_, old, _ := ed25519.GenerateKey(nil)
+	pub, cur, _ := ed25519.GenerateKey(nil)
+
+	oldKey := mustKey(t)(NewEd25519Key("2016-01", old))
+	newKey := mustKey(t)(NewEd25519Key("2016-02", cur))
+
+	oldTok, err := (&Issuer{Key: oldKey}).Issue("bob", 1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTok, err := (&Issuer{Key: newKey}).Issue("bob", 1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A verifier with only public keys, during rotation.
+	oldPub := old.Public().(ed25519.PublicKey)
+	v := &Verifier{Keys: []*Key{
+		mustKey(t)(NewEd25519PublicKey("2016-01", oldPub)),
+		mustKey(t)(NewEd25519PublicKey("2016-02", pub)),
+	}}
+	for _, tok := range []string{oldTok, newTok} {
+		if _, err := v.Verify(tok); err != nil {
+			t.Errorf("Verify: %v", err)
+		}
+	}
+
+	// After rotation.
+	v.Keys = v.Keys[1:]
+	if _, err := v.Verify(oldTok); err != ErrUnknownKey {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+
+	if _, err := Sign(v.Keys[0], &Claims{Subject: "bob"}); err == nil {
+		t.Error("Expected a public key not to sign")
+	}
+}
+
+func TestRejections(t *testing.T) {
+	key := mustKey(t)(NewHMACKey("k1", testSecret))
+	now := time.Now()
+	v := &Verifier{Keys: []*Key{key}, Now: func() time.Time { return now }}
+
+	expired, _ := Sign(key, &Claims{Subject: "bob", ExpiresAt: now.Unix()})
+	if _, err := v.Verify(expired); err != ErrExpired {
+		t.Errorf("Expected ErrExpired, got %v", err)
+	}
+	v.Leeway = time.Minute
+	if _, err := v.Verify(expired); err != nil {
+		t.Errorf("Expected leeway to allow the token, got %v", err)
+	}
+
+	good, _ := Sign(key, &Claims{Subject: "bob", ExpiresAt: now.Add(time.Minute).Unix()})
+	parts := strings.Split(good, ".")
+
+	// alg "none", with the signature stripped
+	none := b64.EncodeToString([]byte(`{"alg":"none","typ":"JWT","kid":"k1"}`))
+	forged, _ := Sign(&Key{ID: "k1", alg: HS256, secret: []byte("guess")}, &Claims{Subject: "alice", ExpiresAt: now.Add(time.Minute).Unix()})
+
+	for name, tok := range map[string]string{
+		"empty":     "",
+		"two parts": parts[0] + "." + parts[1],
+		"none":      none + "." + parts[1] + ".",
+		"tampered":  parts[0] + "." + b64.EncodeToString([]byte(`{"sub":"alice"}`)) + "." + parts[2],
+		"forged":    forged,
+	} {
+		if _, err := v.Verify(tok); err != ErrInvalidToken {
+			t.Errorf("%v: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+}
+
+func TestKeyValidation(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	for name, err := range map[string]error{
+		"short HMAC secret": second(NewHMACKey("k", testSecret[:MinHMACSecretBytes-1])),
+		"empty HMAC secret": second(NewHMACKey("k", nil)),
+		"short private key": second(NewEd25519Key("k", priv[:ed25519.SeedSize])),
+		"nil private key":   second(NewEd25519Key("k", nil)),
+		"long public key":   second(NewEd25519PublicKey("k", append(pub, 0))),
+		"nil public key":    second(NewEd25519PublicKey("k", nil)),
+	} {
+		if err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+
+	if _, err := (&Issuer{}).Issue("bob", 1, time.Now()); err != ErrNoKey {
+		t.Errorf("Expected ErrNoKey from an Issuer without a key, got %v", err)
+	}
+	if _, err := Sign(nil, &Claims{Subject: "bob"}); err != ErrNoKey {
+		t.Errorf("Expected ErrNoKey signing without a key, got %v", err)
+	}
+}
+
+// second returns the error of a key constructor.
+func second(_ *Key, err error) error {
+	return err
+}
@@ -0,0 +1,77 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2fval
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+)
+
+// ErrNoClient is returned by a ClientFunc when the request does not
+// authenticate a client.
+var ErrNoClient = errors.New("aeu2fval: client not authenticated")
+
+// Client is an application using the API, as a u2fval client.  Its users
+// and their devices are its own: another client does not see them, even
+// for the same user names.
+type Client struct {
+	// Name identifies the client; it may not contain a "/".
+	Name string `json:"name"`
+
+	// AppID is the U2F application ID of the client's challenges, and
+	// TrustedFacets their trusted facets, which default to AppID alone.
+	AppID         string   `json:"app_id"`
+	TrustedFacets []string `json:"trusted_facets"`
+
+	// Secret is the client's password, for BasicAuth.
+	Secret string `json:"secret"`
+}
+
+// ClientFunc returns the client making the request.  Return ErrNoClient (or
+// any error) to reject the request with 401 Unauthorized.
+type ClientFunc func(r *http.Request) (*Client, error)
+
+// BasicAuth authenticates the clients with HTTP basic authentication, by
+// their Name and Secret.
+func BasicAuth(clients ...Client) ClientFunc {
+	byName := clientsByName(clients)
+	return func(r *http.Request) (*Client, error) {
+		name, secret, ok := r.BasicAuth()
+		c := byName[name]
+		if !ok || c == nil || c.Secret == "" ||
+			subtle.ConstantTimeCompare([]byte(secret), []byte(c.Secret)) != 1 {
+			return nil, ErrNoClient
+		}
+		return c, nil
+	}
+}
+
+// ProxyAuth takes the client's Name from the header, e.g. X-Remote-User,
+// set by a proxy in front of the Handler that has authenticated the client,
+// as u2fval does with REMOTE_USER.  The proxy must remove the header from
+// the requests it receives.
+func ProxyAuth(header string, clients ...Client) ClientFunc {
+	byName := clientsByName(clients)
+	return func(r *http.Request) (*Client, error) {
+		if c := byName[r.Header.Get(header)]; c != nil {
+			return c, nil
+		}
+		return nil, ErrNoClient
+	}
+}
+
+// --- clientsByName ---
+//
+func clientsByName(clients []Client) map[string]*Client {
+	byName := map[string]*Client{}
+	for i := range clients {
+		c := clients[i]
+		byName[c.Name] = &c
+	}
+	return byName
+}
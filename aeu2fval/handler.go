@@ -0,0 +1,571 @@
+//
+// Package aeu2fval serves a REST API compatible with Yubico's u2fval, on
+// top of the aeu2f package, so that applications written against u2fval
+// can use aeu2f without changes.
+//
+// Mount it with e.g.
+// 	h := aeu2fval.New(aeu2fval.BasicAuth(clients...))
+// 	http.Handle("/u2fval/", http.StripPrefix("/u2fval", h))
+//
+// which serves, to the authenticated client,
+// 	GET    /u2fval/                - the client's trusted facets
+// 	GET    /u2fval/USER/           - the user's devices
+// 	DELETE /u2fval/USER/           - delete the user's devices
+// 	GET    /u2fval/USER/register   - a registration request
+// 	POST   /u2fval/USER/register   - the registration response, answered
+// 	                                 with the new device
+// 	GET    /u2fval/USER/sign       - a sign request for the user's devices,
+// 	                                 or those given by handle parameters
+// 	POST   /u2fval/USER/sign       - the sign response, answered with the
+// 	                                 device that signed
+// 	GET    /u2fval/USER/HANDLE     - the device
+// 	POST   /u2fval/USER/HANDLE     - set properties of the device
+// 	DELETE /u2fval/USER/HANDLE     - delete the device
+// 	GET    /u2fval/USER/HANDLE/certificate - its attestation certificate
+//
+// A device's handle is the ID of its aeu2f registration, and a revoked
+// registration is a compromised device.  The users of a client are stored as
+// CLIENT/USER, so clients do not see each other's, and its challenges are for
+// the client's AppID.
+//
+// Unlike u2fval, properties are only set by the POST of the registration or
+// sign response, not by the GET of the request, and handle parameters only
+// choose the devices asked to sign: any device of the user may answer.
+//
+// License: MIT
+//
+package aeu2fval
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine"
+
+	"github.com/brianmhunt/aeu2f-go"
+	"github.com/tstranex/u2f"
+)
+
+// DefaultMaxBodyBytes is the default limit on the size of a POST body.
+const DefaultMaxBodyBytes = 64 * 1024
+
+// The errorCode of u2fval errors.
+const (
+	ErrorBadInput          = 10
+	ErrorNoEligibleDevices = 11
+	ErrorDeviceCompromised = 12
+
+	// errorInternal is a server error, which u2fval gives no code.
+	errorInternal = -1
+)
+
+// Error is the JSON body of every error response.  Devices lists the
+// compromised devices of an ErrorNoEligibleDevices.
+type Error struct {
+	Code    int      `json:"errorCode"`
+	Message string   `json:"errorMessage"`
+	Devices []Device `json:"devices,omitempty"`
+}
+
+// Device describes a registered device.
+type Device struct {
+	Handle      string          `json:"handle"`
+	Compromised bool            `json:"compromised"`
+	Created     time.Time       `json:"created"`
+	Properties  json.RawMessage `json:"properties"`
+}
+
+// registeredKey is a key of a registration or sign request.
+type registeredKey struct {
+	Version   string `json:"version"`
+	KeyHandle string `json:"keyHandle"`
+	AppID     string `json:"appId"`
+}
+
+// registerRequest is the body of GET /USER/register.
+type registerRequest struct {
+	AppID            string                `json:"appId"`
+	RegisterRequests []u2f.RegisterRequest `json:"registerRequests"`
+	RegisteredKeys   []registeredKey       `json:"registeredKeys"`
+}
+
+// signRequest is the body of GET /USER/sign.
+type signRequest struct {
+	AppID          string          `json:"appId"`
+	Challenge      string          `json:"challenge"`
+	RegisteredKeys []registeredKey `json:"registeredKeys"`
+}
+
+// Handler serves the u2fval API.
+type Handler struct {
+	// Client returns the client for the request.
+	Client ClientFunc
+
+	// Context returns the context.Context for the request.  It defaults to
+	// appengine.NewContext.
+	Context func(r *http.Request) context.Context
+
+	// MaxBodyBytes limits the size of POST bodies.
+	MaxBodyBytes int64
+}
+
+// New returns a Handler that authenticates clients with the given function.
+func New(client ClientFunc) *Handler {
+	return &Handler{
+		Client:       client,
+		Context:      appengine.NewContext,
+		MaxBodyBytes: DefaultMaxBodyBytes,
+	}
+}
+
+// request is a request to the API, for an authenticated client.
+type request struct {
+	ctx    context.Context
+	client *Client
+
+	// userIdentity is the aeu2f user, i.e. CLIENT/USER.
+	userIdentity string
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client, err := h.Client(r)
+	if err != nil || client == nil {
+		writeJSON(w, http.StatusUnauthorized, Error{Code: ErrorBadInput, Message: ErrNoClient.Error()})
+		return
+	}
+	if client.Name == "" || strings.Contains(client.Name, "/") {
+		aeu2f.Log.Error("aeu2fval", "op", "aeu2fval", "error", "invalid client name "+strconv.Quote(client.Name))
+		writeJSON(w, http.StatusInternalServerError, Error{Code: errorInternal, Message: "internal error"})
+		return
+	}
+	facets := client.TrustedFacets
+	if len(facets) == 0 {
+		facets = []string{client.AppID}
+	}
+	req := &request{
+		ctx:    aeu2f.WithAppID(aeu2f.WithRequest(h.Context(r), r), client.AppID, facets),
+		client: client,
+	}
+
+	// The user is a path segment, which may hold an escaped "/".
+	path := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	if len(path) == 1 && path[0] == "" {
+		h.trustedFacets(w, r, facets)
+		return
+	}
+	user, err := url.PathUnescape(path[0])
+	if err != nil || user == "" {
+		writeNotFound(w)
+		return
+	}
+	req.userIdentity = client.Name + "/" + user
+
+	switch {
+	case len(path) == 1 || len(path) == 2 && path[1] == "":
+		h.user(w, r, req)
+	case len(path) == 2 && path[1] == "register":
+		h.register(w, r, req)
+	case len(path) == 2 && path[1] == "sign":
+		h.sign(w, r, req)
+	case len(path) == 2:
+		h.device(w, r, req, path[1])
+	case len(path) == 3 && path[2] == "certificate":
+		h.certificate(w, r, req, path[1])
+	default:
+		writeNotFound(w)
+	}
+}
+
+// --- writeJSON ---
+//
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// --- writeBadInput ---
+//
+func writeBadInput(w http.ResponseWriter, message string) {
+	writeJSON(w, http.StatusBadRequest, Error{Code: ErrorBadInput, Message: message})
+}
+
+// --- writeNotFound ---
+//
+func writeNotFound(w http.ResponseWriter) {
+	writeJSON(w, http.StatusNotFound, Error{Code: ErrorBadInput, Message: "not found"})
+}
+
+// --- writeMethodNotAllowed ---
+//
+func writeMethodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeJSON(w, http.StatusMethodNotAllowed, Error{Code: ErrorBadInput, Message: "method not allowed"})
+}
+
+// --- fail ---
+// Report an error from the aeu2f package as u2fval would, hiding the
+// details of server errors from the client.
+func fail(ctx context.Context, w http.ResponseWriter, err error) {
+	var verr *aeu2f.VerificationError
+	var lerr *aeu2f.LockedOutError
+	switch {
+	case errors.As(err, &lerr):
+		retry := int(time.Until(lerr.Until).Seconds()) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		writeJSON(w, http.StatusTooManyRequests, Error{Code: ErrorBadInput, Message: lerr.Error()})
+	case errors.Is(err, aeu2f.ErrRevoked):
+		writeJSON(w, http.StatusBadRequest, Error{Code: ErrorDeviceCompromised, Message: "device compromised"})
+	case errors.As(err, &verr):
+		writeBadInput(w, verr.Error())
+	case err == aeu2f.ErrNoChallenge:
+		writeBadInput(w, err.Error())
+	case err == aeu2f.ErrNoSuchRegistration:
+		writeNotFound(w)
+	default:
+		aeu2f.Log.Error("aeu2fval", "op", "aeu2fval", "error", err.Error())
+		writeJSON(w, http.StatusInternalServerError, Error{Code: errorInternal, Message: "internal error"})
+	}
+}
+
+// --- decode ---
+// Decode the JSON body of r into v, writing the error response if it fails.
+func (h *Handler) decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body := http.MaxBytesReader(w, r.Body, h.MaxBodyBytes)
+	err := json.NewDecoder(body).Decode(v)
+
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &tooLarge):
+		writeJSON(w, http.StatusRequestEntityTooLarge, Error{Code: ErrorBadInput, Message: "request body too large"})
+	default:
+		writeBadInput(w, "invalid JSON: "+err.Error())
+	}
+	return false
+}
+
+// --- keyHandle ---
+// Return the websafe base64 key handle of regi.
+func keyHandle(regi *aeu2f.Registration) (string, error) {
+	if len(regi.KeyHandle) > 0 {
+		return base64.RawURLEncoding.EncodeToString(regi.KeyHandle), nil
+	}
+	var reg u2f.Registration
+	if err := reg.UnmarshalBinary(regi.U2FRegistrationBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(reg.KeyHandle), nil
+}
+
+// --- describe ---
+// Return the Device of regi.
+func describe(regi *aeu2f.Registration) Device {
+	props := json.RawMessage(regi.Properties)
+	if len(props) == 0 {
+		props = json.RawMessage("{}")
+	}
+	return Device{
+		Handle:      strconv.FormatInt(regi.ID, 10),
+		Compromised: !regi.Revoked.IsZero(),
+		Created:     regi.Created,
+		Properties:  props,
+	}
+}
+
+// --- lookup ---
+// Return the user's registration with the handle, or write the error
+// response and return nil.
+func lookup(w http.ResponseWriter, req *request, handle string) *aeu2f.Registration {
+	id, err := strconv.ParseInt(handle, 10, 64)
+	if err != nil {
+		writeNotFound(w)
+		return nil
+	}
+	regis, err := aeu2f.ListRegistrations(req.ctx, req.userIdentity)
+	if err != nil {
+		fail(req.ctx, w, err)
+		return nil
+	}
+	for _, regi := range regis {
+		if regi.ID == id {
+			return regi
+		}
+	}
+	writeNotFound(w)
+	return nil
+}
+
+// --- trustedFacets ---
+//
+func (h *Handler) trustedFacets(w http.ResponseWriter, r *http.Request, facets []string) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, "GET")
+		return
+	}
+	var tf u2f.TrustedFacets
+	tf.Version.Major, tf.Version.Minor = 1, 0
+	tf.Ids = facets
+	writeJSON(w, http.StatusOK, u2f.TrustedFacetsEndpoint{TrustedFacets: []u2f.TrustedFacets{tf}})
+}
+
+// --- user ---
+// List or delete the user's devices.
+func (h *Handler) user(w http.ResponseWriter, r *http.Request, req *request) {
+	if r.Method != "GET" && r.Method != "DELETE" {
+		writeMethodNotAllowed(w, "GET, DELETE")
+		return
+	}
+
+	regis, err := aeu2f.ListRegistrations(req.ctx, req.userIdentity)
+	if err != nil {
+		fail(req.ctx, w, err)
+		return
+	}
+
+	if r.Method == "GET" {
+		devices := []Device{}
+		for _, regi := range regis {
+			devices = append(devices, describe(regi))
+		}
+		writeJSON(w, http.StatusOK, devices)
+		return
+	}
+
+	for _, regi := range regis {
+		err := aeu2f.DeleteRegistration(req.ctx, req.userIdentity, regi.ID)
+		if err != nil && err != aeu2f.ErrNoSuchRegistration {
+			fail(req.ctx, w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// --- register ---
+//
+func (h *Handler) register(w http.ResponseWriter, r *http.Request, req *request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		writeMethodNotAllowed(w, "GET, POST")
+		return
+	}
+
+	if r.Method == "GET" {
+		regis, err := aeu2f.ListRegistrations(req.ctx, req.userIdentity)
+		if err != nil {
+			fail(req.ctx, w, err)
+			return
+		}
+		c, err := aeu2f.NewRegistrationChallenge(req.ctx, req.userIdentity)
+		if err != nil {
+			fail(req.ctx, w, err)
+			return
+		}
+
+		// The registered keys, so that they are not registered again.
+		keys := []registeredKey{}
+		for _, regi := range regis {
+			kh, err := keyHandle(regi)
+			if err != nil {
+				fail(req.ctx, w, err)
+				return
+			}
+			keys = append(keys, registeredKey{Version: c.Version, KeyHandle: kh, AppID: c.AppID})
+		}
+		writeJSON(w, http.StatusOK, registerRequest{
+			AppID:            c.AppID,
+			RegisterRequests: []u2f.RegisterRequest{*c},
+			RegisteredKeys:   keys,
+		})
+		return
+	}
+
+	var body struct {
+		RegisterResponse *u2f.RegisterResponse  `json:"registerResponse"`
+		Properties       map[string]interface{} `json:"properties"`
+	}
+	if !h.decode(w, r, &body) {
+		return
+	}
+	if body.RegisterResponse == nil {
+		writeBadInput(w, "registerResponse is missing")
+		return
+	}
+
+	regi, err := aeu2f.Register(req.ctx, req.userIdentity, *body.RegisterResponse)
+	if err != nil {
+		fail(req.ctx, w, err)
+		return
+	}
+	h.setProperties(w, req, regi, body.Properties)
+}
+
+// --- sign ---
+//
+func (h *Handler) sign(w http.ResponseWriter, r *http.Request, req *request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		writeMethodNotAllowed(w, "GET, POST")
+		return
+	}
+
+	if r.Method == "GET" {
+		h.signRequest(w, r, req)
+		return
+	}
+
+	var body struct {
+		SignResponse *u2f.SignResponse      `json:"signResponse"`
+		Properties   map[string]interface{} `json:"properties"`
+	}
+	if !h.decode(w, r, &body) {
+		return
+	}
+	if body.SignResponse == nil {
+		writeBadInput(w, "signResponse is missing")
+		return
+	}
+
+	regi, err := aeu2f.Authenticate(req.ctx, req.userIdentity, *body.SignResponse)
+	if err != nil {
+		fail(req.ctx, w, err)
+		return
+	}
+	h.setProperties(w, req, regi, body.Properties)
+}
+
+// --- signRequest ---
+// Return a sign request for the devices given by the handle parameters, or
+// all the user's.  Compromised devices are left out.
+func (h *Handler) signRequest(w http.ResponseWriter, r *http.Request, req *request) {
+	regis, err := aeu2f.ListRegistrations(req.ctx, req.userIdentity)
+	if err != nil {
+		fail(req.ctx, w, err)
+		return
+	}
+
+	handles := map[string]bool{}
+	for _, handle := range r.URL.Query()["handle"] {
+		handles[handle] = true
+	}
+	eligible := map[string]bool{}
+	compromised := []Device{}
+	for _, regi := range regis {
+		d := describe(regi)
+		if len(handles) > 0 && !handles[d.Handle] {
+			continue
+		}
+		if d.Compromised {
+			compromised = append(compromised, d)
+			continue
+		}
+		kh, err := keyHandle(regi)
+		if err != nil {
+			fail(req.ctx, w, err)
+			return
+		}
+		eligible[kh] = true
+	}
+	if len(eligible) == 0 {
+		writeJSON(w, http.StatusBadRequest, Error{
+			Code:    ErrorNoEligibleDevices,
+			Message: "no eligible devices",
+			Devices: compromised,
+		})
+		return
+	}
+
+	reqs, err := aeu2f.NewSignChallenge(req.ctx, req.userIdentity)
+	if err != nil {
+		fail(req.ctx, w, err)
+		return
+	}
+	sr := signRequest{RegisteredKeys: []registeredKey{}}
+	for _, sreq := range reqs {
+		sr.AppID, sr.Challenge = sreq.AppID, sreq.Challenge
+		if eligible[sreq.KeyHandle] {
+			sr.RegisteredKeys = append(sr.RegisteredKeys, registeredKey{
+				Version:   sreq.Version,
+				KeyHandle: sreq.KeyHandle,
+				AppID:     sreq.AppID,
+			})
+		}
+	}
+	writeJSON(w, http.StatusOK, sr)
+}
+
+// --- setProperties ---
+// Set the properties of regi, if any, and write its Device.
+func (h *Handler) setProperties(w http.ResponseWriter, req *request, regi *aeu2f.Registration, props map[string]interface{}) {
+	if len(props) > 0 {
+		var err error
+		if regi, err = aeu2f.UpdateProperties(req.ctx, req.userIdentity, regi.ID, props); err != nil {
+			fail(req.ctx, w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, describe(regi))
+}
+
+// --- device ---
+// Describe, update the properties of, or delete a device.
+func (h *Handler) device(w http.ResponseWriter, r *http.Request, req *request, handle string) {
+	if r.Method != "GET" && r.Method != "POST" && r.Method != "DELETE" {
+		writeMethodNotAllowed(w, "GET, POST, DELETE")
+		return
+	}
+
+	regi := lookup(w, req, handle)
+	if regi == nil {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, describe(regi))
+	case "POST":
+		var props map[string]interface{}
+		if !h.decode(w, r, &props) {
+			return
+		}
+		h.setProperties(w, req, regi, props)
+	case "DELETE":
+		if err := aeu2f.DeleteRegistration(req.ctx, req.userIdentity, regi.ID); err != nil {
+			fail(req.ctx, w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// --- certificate ---
+// Return the attestation certificate of a device, in PEM.
+func (h *Handler) certificate(w http.ResponseWriter, r *http.Request, req *request, handle string) {
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, "GET")
+		return
+	}
+
+	regi := lookup(w, req, handle)
+	if regi == nil {
+		return
+	}
+	cert, err := regi.AttestationCertificate()
+	if err != nil {
+		writeNotFound(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
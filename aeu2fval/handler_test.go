@@ -0,0 +1,272 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2fval
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/brianmhunt/aeu2f-go"
+	"github.com/brianmhunt/aeu2f-go/aeu2ftest"
+	"github.com/tstranex/u2f"
+)
+
+var testClients = []Client{
+	{Name: "app", AppID: "https://app.example.com", Secret: "app secret"},
+	{Name: "other", AppID: "https://other.example.com", Secret: "other secret"},
+}
+
+// newTestHandler returns a Handler of testClients, over a new MemoryStore.
+func newTestHandler(t *testing.T) *Handler {
+	old := aeu2f.Storage
+	aeu2f.Storage = aeu2f.NewMemoryStore()
+	t.Cleanup(func() { aeu2f.Storage = old })
+
+	h := New(BasicAuth(testClients...))
+	h.Context = func(r *http.Request) context.Context { return context.Background() }
+	return h
+}
+
+// serve serves the request of the client, and decodes its JSON response
+// into v, if given.
+func serve(t *testing.T, h http.Handler, client, method, url string, body, v interface{}) *httptest.ResponseRecorder {
+	var b []byte
+	if s, ok := body.(string); ok {
+		b = []byte(s)
+	} else if body != nil {
+		b, _ = json.Marshal(body)
+	}
+	r := httptest.NewRequest(method, url, strings.NewReader(string(b)))
+	for _, c := range testClients {
+		if c.Name == client {
+			r.SetBasicAuth(c.Name, c.Secret)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%v %v: expected JSON, got %v: %v", method, url, w.Code, w.Body)
+		}
+	}
+	return w
+}
+
+// register registers a new key of the user, with the properties, and
+// returns it and its Device.
+func register(t *testing.T, h http.Handler, client, user string, props map[string]interface{}) (*aeu2ftest.Authenticator, Device) {
+	var req registerRequest
+	if w := serve(t, h, client, "GET", "/"+user+"/register", nil, &req); w.Code != http.StatusOK {
+		t.Fatalf("Expected a registration request, got %v: %v", w.Code, w.Body)
+	}
+	if len(req.RegisterRequests) != 1 {
+		t.Fatalf("Expected one registration request, got %+v", req)
+	}
+
+	a := aeu2ftest.New()
+	rr := req.RegisterRequests[0]
+	rr.AppID = req.AppID
+	resp, err := a.Register(&rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d Device
+	body := map[string]interface{}{"registerResponse": resp, "properties": props}
+	if w := serve(t, h, client, "POST", "/"+user+"/register", body, &d); w.Code != http.StatusOK {
+		t.Fatalf("Expected the key to be registered, got %v: %v", w.Code, w.Body)
+	}
+	return a, d
+}
+
+// signResponse returns the response of a to a sign request for the user.
+func signResponse(t *testing.T, h http.Handler, client, user string, a *aeu2ftest.Authenticator) *u2f.SignResponse {
+	var req signRequest
+	if w := serve(t, h, client, "GET", "/"+user+"/sign", nil, &req); w.Code != http.StatusOK {
+		t.Fatalf("Expected a sign request, got %v: %v", w.Code, w.Body)
+	}
+	var reqs []*u2f.SignRequest
+	for _, k := range req.RegisteredKeys {
+		reqs = append(reqs, &u2f.SignRequest{Version: k.Version, Challenge: req.Challenge, KeyHandle: k.KeyHandle, AppID: k.AppID})
+	}
+	resp, err := a.SignAny(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestTrustedFacets(t *testing.T) {
+	h := newTestHandler(t)
+
+	var tf u2f.TrustedFacetsEndpoint
+	serve(t, h, "app", "GET", "/", nil, &tf)
+	if len(tf.TrustedFacets) != 1 || len(tf.TrustedFacets[0].Ids) != 1 || tf.TrustedFacets[0].Ids[0] != "https://app.example.com" {
+		t.Errorf("Expected the client's AppID as its facet, got %+v", tf)
+	}
+
+	var e Error
+	if w := serve(t, h, "", "GET", "/", nil, &e); w.Code != http.StatusUnauthorized || e.Code != ErrorBadInput {
+		t.Errorf("Expected 401 without a client, got %v: %+v", w.Code, e)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("app", "other secret")
+	w := httptest.NewRecorder()
+	if h.ServeHTTP(w, r); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with the wrong secret, got %v", w.Code)
+	}
+}
+
+func TestRegisterSign(t *testing.T) {
+	h := newTestHandler(t)
+
+	a, d := register(t, h, "app", "bob", map[string]interface{}{"name": "yubikey"})
+	if d.Handle == "" || d.Compromised || string(d.Properties) != `{"name":"yubikey"}` {
+		t.Errorf("Unexpected device %+v", d)
+	}
+
+	var devices []Device
+	if serve(t, h, "app", "GET", "/bob/", nil, &devices); len(devices) != 1 || devices[0].Handle != d.Handle {
+		t.Errorf("Expected bob's device, got %+v", devices)
+	}
+	if serve(t, h, "other", "GET", "/bob/", nil, &devices); len(devices) != 0 {
+		t.Errorf("Expected the other client not to see bob's device, got %+v", devices)
+	}
+
+	// The registration request lists the registered key.
+	var req registerRequest
+	serve(t, h, "app", "GET", "/bob/register", nil, &req)
+	if req.AppID != "https://app.example.com" || len(req.RegisteredKeys) != 1 {
+		t.Errorf("Expected the registered key, got %+v", req)
+	}
+
+	var signed Device
+	body := map[string]interface{}{"signResponse": signResponse(t, h, "app", "bob", a), "properties": map[string]interface{}{"name": nil, "os": "linux"}}
+	if w := serve(t, h, "app", "POST", "/bob/sign", body, &signed); w.Code != http.StatusOK {
+		t.Fatalf("Expected the key to sign, got %v: %v", w.Code, w.Body)
+	}
+	if signed.Handle != d.Handle || string(signed.Properties) != `{"os":"linux"}` {
+		t.Errorf("Unexpected device %+v", signed)
+	}
+
+	// The response is not accepted twice.
+	var e Error
+	if w := serve(t, h, "app", "POST", "/bob/sign", body, &e); w.Code != http.StatusBadRequest || e.Code != ErrorBadInput {
+		t.Errorf("Expected a replay to be refused, got %v: %+v", w.Code, e)
+	}
+}
+
+func TestDevice(t *testing.T) {
+	h := newTestHandler(t)
+	a, d := register(t, h, "app", "bob", nil)
+	register(t, h, "app", "bob", nil)
+	url := "/bob/" + d.Handle
+
+	var got Device
+	if serve(t, h, "app", "GET", url, nil, &got); got.Handle != d.Handle || string(got.Properties) != "{}" {
+		t.Errorf("Unexpected device %+v", got)
+	}
+	if serve(t, h, "app", "POST", url, `{"name": "backup"}`, &got); string(got.Properties) != `{"name":"backup"}` {
+		t.Errorf("Expected the properties to be set, got %+v", got)
+	}
+
+	w := serve(t, h, "app", "GET", url+"/certificate", nil, nil)
+	if block, _ := pem.Decode(w.Body.Bytes()); w.Code != http.StatusOK || block == nil || block.Type != "CERTIFICATE" {
+		t.Errorf("Expected a PEM certificate, got %v: %v", w.Code, w.Body)
+	}
+
+	// A response to a request made before the device was compromised.
+	resp := signResponse(t, h, "app", "bob", a)
+	id, _ := strconv.ParseInt(d.Handle, 10, 64)
+	if _, err := aeu2f.RevokeRegistration(context.Background(), "app/bob", id); err != nil {
+		t.Fatal(err)
+	}
+	var e Error
+	w = serve(t, h, "app", "POST", "/bob/sign", map[string]interface{}{"signResponse": resp}, &e)
+	if w.Code != http.StatusBadRequest || e.Code != ErrorDeviceCompromised {
+		t.Errorf("Expected the compromised device to be refused, got %v: %+v", w.Code, e)
+	}
+	w = serve(t, h, "app", "GET", "/bob/sign?handle="+d.Handle, nil, &e)
+	if w.Code != http.StatusBadRequest || e.Code != ErrorNoEligibleDevices || len(e.Devices) != 1 || !e.Devices[0].Compromised {
+		t.Errorf("Expected no eligible devices, got %v: %+v", w.Code, e)
+	}
+
+	if w := serve(t, h, "app", "DELETE", url, nil, nil); w.Code != http.StatusNoContent {
+		t.Errorf("Expected the device to be deleted, got %v", w.Code)
+	}
+	if w := serve(t, h, "app", "GET", url, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the device to be gone, got %v", w.Code)
+	}
+	if w := serve(t, h, "app", "DELETE", "/bob/", nil, nil); w.Code != http.StatusNoContent {
+		t.Errorf("Expected bob's devices to be deleted, got %v", w.Code)
+	}
+	var devices []Device
+	if serve(t, h, "app", "GET", "/bob/", nil, &devices); len(devices) != 0 {
+		t.Errorf("Expected no devices left, got %+v", devices)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	h := newTestHandler(t)
+	_, d := register(t, h, "app", "bob", nil)
+	h.MaxBodyBytes = 32
+
+	for _, tc := range []struct {
+		method, url, body string
+		status, code      int
+	}{
+		{"PUT", "/bob/register", "", http.StatusMethodNotAllowed, ErrorBadInput},
+		{"POST", "/bob/register", "{", http.StatusBadRequest, ErrorBadInput},
+		{"POST", "/bob/register", "{}", http.StatusBadRequest, ErrorBadInput},
+		{"POST", "/bob/sign", `{"signResponse": {"keyHandle": "0123456789abcdef"}}`, http.StatusRequestEntityTooLarge, ErrorBadInput},
+		{"POST", "/bob/sign", `{"signResponse": {}}`, http.StatusBadRequest, ErrorBadInput},
+		{"GET", "/alice/sign", "", http.StatusBadRequest, ErrorNoEligibleDevices},
+		{"GET", "/bob/sign?handle=0", "", http.StatusBadRequest, ErrorNoEligibleDevices},
+		{"GET", "/bob/x", "", http.StatusNotFound, ErrorBadInput},
+		{"GET", "/alice/" + d.Handle, "", http.StatusNotFound, ErrorBadInput},
+		{"GET", "/bob/" + d.Handle + "/other", "", http.StatusNotFound, ErrorBadInput},
+		{"PUT", "/bob/" + d.Handle, "", http.StatusMethodNotAllowed, ErrorBadInput},
+	} {
+		var e Error
+		w := serve(t, h, "app", tc.method, tc.url, tc.body, &e)
+		if w.Code != tc.status || e.Code != tc.code || e.Message == "" {
+			t.Errorf("%v %v: expected %v, code %v, got %v: %+v", tc.method, tc.url, tc.status, tc.code, w.Code, e)
+		}
+	}
+}
+
+func TestEscapedUser(t *testing.T) {
+	h := newTestHandler(t)
+	_, d := register(t, h, "app", "a%2Fb", nil)
+
+	regis, err := aeu2f.ListRegistrations(context.Background(), "app/a/b")
+	if err != nil || len(regis) != 1 {
+		t.Fatalf("Expected the user a/b, got %v, %v", regis, err)
+	}
+	var got Device
+	if serve(t, h, "app", "GET", "/a%2Fb/"+d.Handle, nil, &got); got.Handle != d.Handle {
+		t.Errorf("Unexpected device %+v", got)
+	}
+}
+
+func TestProxyAuth(t *testing.T) {
+	auth := ProxyAuth("X-Remote-User", testClients...)
+	r := httptest.NewRequest("GET", "/", nil)
+	if _, err := auth(r); err != ErrNoClient {
+		t.Errorf("Expected ErrNoClient without the header, got %v", err)
+	}
+	r.Header.Set("X-Remote-User", "other")
+	if c, err := auth(r); err != nil || c.AppID != "https://other.example.com" {
+		t.Errorf("Expected the other client, got %+v, %v", c, err)
+	}
+}
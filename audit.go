@@ -0,0 +1,171 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+
+	"github.com/tstranex/u2f"
+)
+
+// Audit event types.
+const (
+	AuditRegistrationChallenge = "registration.challenge"
+	AuditRegistration          = "registration.store"
+	AuditDeletion              = "registration.delete"
+	AuditRename                = "registration.rename"
+	AuditRevocation            = "registration.revoke"
+	AuditCounterReset          = "registration.counter_reset"
+	AuditImport                = "registration.import"
+	AuditProperties            = "registration.properties"
+	AuditSignChallenge         = "sign.challenge"
+	AuditSign                  = "sign"
+	AuditCounterRegression     = "sign.counter_regression"
+	AuditRecoveryCode          = "recovery_code"
+	AuditTOTP                  = "totp"
+	AuditTOTPDeletion          = "totp.delete"
+)
+
+// Audit event outcomes.
+const (
+	AuditSuccess   = "success"
+	AuditFailure   = "failure"
+	AuditLockedOut = "locked_out"
+)
+
+// AuditEvent records a registration or authentication event.
+type AuditEvent struct {
+	Time           time.Time
+	Type           string
+	UserIdentity   string
+	RegistrationID int64
+	IP             string
+	UserAgent      string `datastore:",noindex"`
+	Outcome        string
+
+	// Detail is the error, for failures.
+	Detail string `datastore:",noindex"`
+}
+
+// AuditSink receives every AuditEvent.
+type AuditSink interface {
+	Record(ctx context.Context, e *AuditEvent) error
+}
+
+// Audit is where audit events are written; e.g. DatastoreAudit{}.  When nil,
+// events are not recorded.
+//
+// A failure to record an event is logged, and does not fail the operation.
+var Audit AuditSink
+
+// --- audit ---
+// Record an event for the outcome err of an operation.
+func audit(ctx context.Context, typ, userIdentity string, registrationID int64, err error) {
+	if Audit == nil {
+		return
+	}
+
+	e := &AuditEvent{
+		Time:           time.Now(),
+		Type:           typ,
+		UserIdentity:   userIdentity,
+		RegistrationID: registrationID,
+		IP:             clientIP(ctx),
+		Outcome:        AuditSuccess,
+	}
+	if r := request(ctx); r != nil {
+		e.UserAgent = r.UserAgent()
+	}
+
+	switch err.(type) {
+	case nil:
+	case *LockedOutError:
+		e.Outcome = AuditLockedOut
+		e.Detail = err.Error()
+	default:
+		e.Outcome = AuditFailure
+		e.Detail = err.Error()
+	}
+
+	if err := Audit.Record(ctx, e); err != nil {
+		Log.Error("audit.record", "op", "audit.record", "type", e.Type,
+			"user", logUser(e.UserIdentity), "error", err.Error())
+	}
+}
+
+// --- signCounter ---
+// Return the counter claimed by a sign response, without verifying it.
+func signCounter(signResp u2f.SignResponse) (uint32, bool) {
+	s := signResp.SignatureData
+	for i := 0; i < len(s)%4; i++ {
+		s += "="
+	}
+	sd, err := base64.URLEncoding.DecodeString(s)
+	if err != nil || len(sd) < 5 {
+		return 0, false
+	}
+	return uint32(sd[1])<<24 | uint32(sd[2])<<16 | uint32(sd[3])<<8 | uint32(sd[4]), true
+}
+
+
+// DatastoreAudit is an AuditSink that stores events in the datastore, as
+// AuditEvent entities.
+//
+// Events are not stored under the parent key of the registrations, so that
+// they do not contend with them, and queries are eventually consistent.
+// Querying by user and time needs the composite index in aeu2f-demo's
+// index.yaml.
+type DatastoreAudit struct{}
+
+// Record implements AuditSink.
+func (DatastoreAudit) Record(ctx context.Context, e *AuditEvent) error {
+	k := datastore.NewIncompleteKey(ctx, "AuditEvent", nil)
+	if _, err := datastore.Put(ctx, k, e); err != nil {
+		return fmt.Errorf("datastore.Put error: %v", err)
+	}
+	return nil
+}
+
+// Query returns the events in [start, end), oldest first, for the given user
+// or, if userIdentity is "", for all users.
+func (DatastoreAudit) Query(ctx context.Context, userIdentity string, start, end time.Time) ([]*AuditEvent, error) {
+	q := datastore.NewQuery("AuditEvent").
+		Filter("Time >=", start).
+		Filter("Time <", end).
+		Order("Time")
+	if userIdentity != "" {
+		q = q.Filter("UserIdentity =", userIdentity)
+	}
+
+	events := []*AuditEvent{}
+	if _, err := q.GetAll(ctx, &events); err != nil {
+		return nil, fmt.Errorf("datastore GetAll error: %+v", err)
+	}
+	return events, nil
+}
+
+// String returns a one-line summary of the event.
+func (e *AuditEvent) String() string {
+	parts := []string{e.Time.Format(time.RFC3339), e.Type, e.Outcome,
+		fmt.Sprintf("user=%q", e.UserIdentity)}
+	if e.RegistrationID != 0 {
+		parts = append(parts, fmt.Sprintf("registration=%v", e.RegistrationID))
+	}
+	if e.IP != "" {
+		parts = append(parts, "ip="+e.IP)
+	}
+	if e.Detail != "" {
+		parts = append(parts, fmt.Sprintf("detail=%q", e.Detail))
+	}
+	return strings.Join(parts, " ")
+}
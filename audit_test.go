@@ -0,0 +1,140 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/aetest"
+
+	"github.com/tstranex/u2f"
+)
+
+// newConsistentContext returns a context whose datastore is strongly
+// consistent, and a function to close it.
+func newConsistentContext(t *testing.T) (context.Context, func()) {
+	inst, err := aetest.NewInstance(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := inst.NewRequest("GET", "/", nil)
+	if err != nil {
+		inst.Close()
+		t.Fatal(err)
+	}
+	return appengine.NewContext(r), func() { inst.Close() }
+}
+
+// memoryAudit is an AuditSink that keeps the events in memory.
+type memoryAudit struct {
+	events []*AuditEvent
+}
+
+func (m *memoryAudit) Record(ctx context.Context, e *AuditEvent) error {
+	m.events = append(m.events, e)
+	return nil
+}
+
+func TestSignCounter(t *testing.T) {
+	// User presence, counter 0x0102ff04, and a (bogus) signature.
+	resp := u2f.SignResponse{SignatureData: "AQEC_wQw"}
+	if c, ok := signCounter(resp); !ok || c != 0x0102ff04 {
+		t.Errorf("signCounter = %x, %v", c, ok)
+	}
+
+	if _, ok := signCounter(u2f.SignResponse{SignatureData: "AQ"}); ok {
+		t.Error("Expected short signature data to be rejected")
+	}
+}
+
+func TestAuditEvents(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	m := &memoryAudit{}
+	Audit = m
+	defer func() { Audit = nil }()
+
+	var testID = "test-id-🔒"
+
+	if _, err := NewRegistrationChallenge(ctx, testID); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteRegistration(ctx, testID, 1234); err != ErrNoSuchRegistration {
+		t.Fatalf("Expected ErrNoSuchRegistration, got %v", err)
+	}
+	if err := Sign(ctx, testID, u2f.SignResponse{}); err != ErrNoChallenge {
+		t.Fatalf("Expected ErrNoChallenge, got %v", err)
+	}
+	if err := DeleteTOTP(ctx, testID, 5678); err != ErrNoSuchRegistration {
+		t.Fatalf("Expected ErrNoSuchRegistration, got %v", err)
+	}
+
+	expected := []struct {
+		typ, outcome   string
+		registrationID int64
+	}{
+		{AuditRegistrationChallenge, AuditSuccess, 0},
+		{AuditDeletion, AuditFailure, 1234},
+		{AuditSign, AuditFailure, 0},
+		{AuditTOTPDeletion, AuditFailure, 5678},
+	}
+	if len(m.events) != len(expected) {
+		t.Fatalf("Expected %v events, got %v", len(expected), m.events)
+	}
+	for i, e := range m.events {
+		x := expected[i]
+		if e.Type != x.typ || e.Outcome != x.outcome || e.RegistrationID != x.registrationID ||
+			e.UserIdentity != testID || e.Time.IsZero() {
+			t.Errorf("Event %v: unexpected %v", i, e)
+		}
+	}
+	if m.events[2].Detail != ErrNoChallenge.Error() {
+		t.Errorf("Expected the error in the detail, got %q", m.events[2].Detail)
+	}
+}
+
+func TestDatastoreAudit(t *testing.T) {
+	ctx, done := newConsistentContext(t)
+	defer done()
+
+	var a DatastoreAudit
+	start := time.Now().Add(-time.Hour)
+	for i, user := range []string{"alice", "bob", "alice"} {
+		e := &AuditEvent{
+			Time:         start.Add(time.Duration(i) * time.Minute),
+			Type:         AuditSign,
+			UserIdentity: user,
+			Outcome:      AuditSuccess,
+		}
+		if err := a.Record(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := a.Query(ctx, "alice", start, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || !events[0].Time.Before(events[1].Time) {
+		t.Errorf("Expected alice's 2 events in order, got %v", events)
+	}
+
+	events, err = a.Query(ctx, "", start.Add(time.Second), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].UserIdentity != "bob" {
+		t.Errorf("Expected the last 2 events, got %v", events)
+	}
+}
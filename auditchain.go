@@ -0,0 +1,403 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// DefaultCheckpointEvery is how many events there are between signed
+// checkpoints, unless configured otherwise.
+const DefaultCheckpointEvery = 100
+
+// ChainedAuditEvent is an AuditEvent in a tamper-evident hash chain: each
+// event includes the hash of the one before it.
+type ChainedAuditEvent struct {
+	AuditEvent
+
+	// Seq is the position in the chain, from 1.
+	Seq      int64
+	PrevHash []byte `datastore:",noindex"`
+	Hash     []byte `datastore:",noindex"`
+}
+
+// AuditCheckpoint is a signature, by a server key, over the hash of the
+// chain up to and including event Seq.  Since the hash covers every prior
+// event, rewriting history means forging a checkpoint.
+type AuditCheckpoint struct {
+	Seq       int64
+	Hash      []byte `datastore:",noindex"`
+	Time      time.Time
+	KeyID     string
+	Signature []byte `datastore:",noindex"`
+}
+
+// AuditChainExport is the whole chain of a tenant, for verification
+// elsewhere, e.g. with cmd/aeu2f-auditverify.
+type AuditChainExport struct {
+	Tenant      string
+	Events      []*ChainedAuditEvent
+	Checkpoints []*AuditCheckpoint
+
+	// Head is the signed head of the chain, whose Time is that of the
+	// latest event, or nil if the chain has no key.
+	Head *AuditCheckpoint
+}
+
+// ChainBreak reports the first broken link in a chain.
+type ChainBreak struct {
+	Seq    int64
+	Reason string
+}
+
+func (b *ChainBreak) Error() string {
+	return fmt.Sprintf("aeu2f: audit chain broken at %v: %v", b.Seq, b.Reason)
+}
+
+// auditChainHead is the latest link of a tenant's chain, and its signature
+// if the chain has a key.
+type auditChainHead struct {
+	Seq  int64
+	Hash []byte `datastore:",noindex"`
+
+	Time      time.Time
+	KeyID     string
+	Signature []byte `datastore:",noindex"`
+}
+
+// ChainedAudit is an AuditSink that stores events in the datastore in a
+// hash chain per tenant, i.e. per datastore namespace, with periodic signed
+// checkpoints.
+//
+// Every event of a tenant is written in a transaction on the same entity
+// group, so this sustains about one event per second per tenant.  Record
+// retries on contention until the event is stored, or ctx is done.
+//
+// Each event also signs the head of the chain, so that deleting the latest
+// events can be detected; see VerifyAuditChain.
+type ChainedAudit struct {
+	// Key signs checkpoints and the head.  When nil, no checkpoints are
+	// made, and the chain cannot be verified.
+	Key   ed25519.PrivateKey
+	KeyID string
+
+	// CheckpointEvery defaults to DefaultCheckpointEvery.  There is also a
+	// checkpoint of the first event, so that short chains can be verified.
+	CheckpointEvery int64
+}
+
+// --- every ---
+//
+func (a *ChainedAudit) every() int64 {
+	if a.CheckpointEvery == 0 {
+		return DefaultCheckpointEvery
+	}
+	return a.CheckpointEvery
+}
+
+// checkpointAt returns whether event seq is checkpointed.
+func (a *ChainedAudit) checkpointAt(seq int64) bool {
+	return a.Key != nil && (seq == 1 || seq%a.every() == 0)
+}
+
+// --- writeField ---
+// Write a length-prefixed field, so that field boundaries are unambiguous.
+func writeField(buf *bytes.Buffer, b []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(b)))
+	buf.Write(b)
+}
+
+// chainHash returns the hash of an event, which covers the previous hash.
+func chainHash(e *ChainedAuditEvent) []byte {
+	var buf bytes.Buffer
+	writeField(&buf, e.PrevHash)
+	binary.Write(&buf, binary.BigEndian, e.Seq)
+	binary.Write(&buf, binary.BigEndian, e.Time.UnixNano())
+	binary.Write(&buf, binary.BigEndian, e.RegistrationID)
+	for _, s := range []string{e.Type, e.UserIdentity, e.IP, e.UserAgent, e.Outcome, e.Detail} {
+		writeField(&buf, []byte(s))
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	return sum[:]
+}
+
+// checkpointMessage returns what is signed for a checkpoint.
+func checkpointMessage(tenant string, cp *AuditCheckpoint) []byte {
+	return signedMessage("aeu2f audit checkpoint", tenant, cp)
+}
+
+// headMessage returns what is signed for the head, which differs from a
+// checkpoint's so that neither passes for the other.
+func headMessage(tenant string, h *AuditCheckpoint) []byte {
+	return signedMessage("aeu2f audit head", tenant, h)
+}
+
+// --- signedMessage ---
+//
+func signedMessage(label, tenant string, cp *AuditCheckpoint) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(label + "\x00")
+	writeField(&buf, []byte(tenant))
+	writeField(&buf, []byte(cp.KeyID))
+	binary.Write(&buf, binary.BigEndian, cp.Seq)
+	binary.Write(&buf, binary.BigEndian, cp.Time.UnixNano())
+	writeField(&buf, cp.Hash)
+	return buf.Bytes()
+}
+
+// --- link ---
+// Return e chained after head.
+func link(head auditChainHead, e *AuditEvent) *ChainedAuditEvent {
+	ce := &ChainedAuditEvent{AuditEvent: *e, Seq: head.Seq + 1, PrevHash: head.Hash}
+	// The datastore stores times to the microsecond.
+	ce.Time = ce.Time.Truncate(time.Microsecond)
+	ce.Hash = chainHash(ce)
+	return ce
+}
+
+// --- signCheckpoint ---
+//
+func (a *ChainedAudit) signCheckpoint(tenant string, ce *ChainedAuditEvent) *AuditCheckpoint {
+	cp := &AuditCheckpoint{
+		Seq:   ce.Seq,
+		Hash:  ce.Hash,
+		Time:  time.Now().Truncate(time.Microsecond),
+		KeyID: a.KeyID,
+	}
+	cp.Signature = ed25519.Sign(a.Key, checkpointMessage(tenant, cp))
+	return cp
+}
+
+// --- signHead ---
+// Return the signed head of the chain at ce.
+func (a *ChainedAudit) signHead(tenant string, ce *ChainedAuditEvent) *AuditCheckpoint {
+	h := &AuditCheckpoint{Seq: ce.Seq, Hash: ce.Hash, Time: ce.Time, KeyID: a.KeyID}
+	h.Signature = ed25519.Sign(a.Key, headMessage(tenant, h))
+	return h
+}
+
+// tenant returns the datastore namespace of ctx.
+func tenant(ctx context.Context) string {
+	return MakeParentKey(ctx).Namespace()
+}
+
+// chainKey returns the key of the head of the tenant's chain, which is also
+// the parent of its events and checkpoints.
+func chainKey(ctx context.Context) *datastore.Key {
+	return datastore.NewKey(ctx, "AuditChain", "chain", 0, nil)
+}
+
+// Backoff between retries of a contended ChainedAudit.Record.
+const (
+	recordMinBackoff = 10 * time.Millisecond
+	recordMaxBackoff = time.Second
+)
+
+// Record implements AuditSink.
+func (a *ChainedAudit) Record(ctx context.Context, e *AuditEvent) error {
+	backoff := recordMinBackoff
+	for {
+		err := a.record(ctx, e)
+		if err != datastore.ErrConcurrentTransaction {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("audit chain contended: %v", ctx.Err())
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > recordMaxBackoff {
+			backoff = recordMaxBackoff
+		}
+	}
+}
+
+// --- record ---
+// Chain e after the tenant's head, in one transaction.
+func (a *ChainedAudit) record(ctx context.Context, e *AuditEvent) error {
+	return datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		hk := chainKey(tc)
+		var head auditChainHead
+		if err := datastore.Get(tc, hk, &head); err != nil && err != datastore.ErrNoSuchEntity {
+			return fmt.Errorf("datastore.Get error: %v", err)
+		}
+
+		ce := link(head, e)
+		ek := datastore.NewKey(tc, "ChainedAuditEvent", "", ce.Seq, hk)
+		if _, err := datastore.Put(tc, ek, ce); err != nil {
+			return fmt.Errorf("datastore.Put error: %v", err)
+		}
+
+		if a.checkpointAt(ce.Seq) {
+			cp := a.signCheckpoint(tenant(tc), ce)
+			ck := datastore.NewKey(tc, "AuditCheckpoint", "", cp.Seq, hk)
+			if _, err := datastore.Put(tc, ck, cp); err != nil {
+				return fmt.Errorf("datastore.Put error: %v", err)
+			}
+		}
+
+		head = auditChainHead{Seq: ce.Seq, Hash: ce.Hash}
+		if a.Key != nil {
+			h := a.signHead(tenant(tc), ce)
+			head.Time, head.KeyID, head.Signature = h.Time, h.KeyID, h.Signature
+		}
+		if _, err := datastore.Put(tc, hk, &head); err != nil {
+			return fmt.Errorf("datastore.Put error: %v", err)
+		}
+		return nil
+	}, nil)
+}
+
+// Export returns the tenant's whole chain, up to its head.
+func (a *ChainedAudit) Export(ctx context.Context) (*AuditChainExport, error) {
+	x := &AuditChainExport{
+		Tenant:      tenant(ctx),
+		Events:      []*ChainedAuditEvent{},
+		Checkpoints: []*AuditCheckpoint{},
+	}
+
+	// The head is read first, and the events only up to it, so that those
+	// recorded meanwhile do not appear to run past it.
+	var head auditChainHead
+	err := datastore.Get(ctx, chainKey(ctx), &head)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, fmt.Errorf("datastore.Get error: %v", err)
+	}
+	if head.Signature != nil {
+		x.Head = &AuditCheckpoint{head.Seq, head.Hash, head.Time, head.KeyID, head.Signature}
+	}
+
+	q := datastore.NewQuery("ChainedAuditEvent").Ancestor(chainKey(ctx)).
+		Filter("Seq <=", head.Seq).Order("Seq")
+	if _, err := q.GetAll(ctx, &x.Events); err != nil {
+		return nil, fmt.Errorf("datastore GetAll error: %+v", err)
+	}
+
+	q = datastore.NewQuery("AuditCheckpoint").Ancestor(chainKey(ctx)).
+		Filter("Seq <=", head.Seq).Order("Seq")
+	if _, err := q.GetAll(ctx, &x.Checkpoints); err != nil {
+		return nil, fmt.Errorf("datastore GetAll error: %+v", err)
+	}
+	return x, nil
+}
+
+// Verify checks the tenant's chain, as VerifyAuditChain with the
+// ChainedAudit's CheckpointEvery.
+func (a *ChainedAudit) Verify(ctx context.Context, keys map[string]ed25519.PublicKey, notBefore time.Time) error {
+	x, err := a.Export(ctx)
+	if err != nil {
+		return err
+	}
+	return VerifyAuditChain(x, keys, a.every(), notBefore)
+}
+
+
+// VerifyAuditChain walks an exported chain and returns a *ChainBreak for the
+// first broken link: a missing or altered event, a checkpoint that is not
+// validly signed by one of the keys or that does not match the chain, or a
+// head that is not or does not.  There must be a checkpoint at least every
+// `every` events (0 meaning DefaultCheckpointEvery), and at most that many
+// after the last one.
+//
+// The signed head detects the deletion of the latest events, but only as
+// far as it is fresh: whoever can write the datastore can also put back an
+// older head, with the chain up to it.  So the head must be no older than
+// notBefore, e.g. the time of the latest event the verifier knows of from
+// elsewhere, such as its previous verification.  A zero notBefore accepts
+// any head, and so does not detect truncation.
+func VerifyAuditChain(x *AuditChainExport, keys map[string]ed25519.PublicKey, every int64, notBefore time.Time) error {
+	if every == 0 {
+		every = DefaultCheckpointEvery
+	}
+
+	hashes := map[int64][]byte{}
+	var prev []byte
+	for idx, ce := range x.Events {
+		seq := int64(idx + 1)
+		switch {
+		case ce.Seq != seq:
+			return &ChainBreak{seq, fmt.Sprintf("event missing (found %v)", ce.Seq)}
+		case !bytes.Equal(ce.PrevHash, prev):
+			return &ChainBreak{seq, "previous hash does not match"}
+		case !bytes.Equal(ce.Hash, chainHash(ce)):
+			return &ChainBreak{seq, "event altered"}
+		}
+		hashes[seq] = ce.Hash
+		prev = ce.Hash
+	}
+
+	for _, cp := range x.Checkpoints {
+		key, ok := keys[cp.KeyID]
+		switch {
+		case !ok:
+			return &ChainBreak{cp.Seq, fmt.Sprintf("checkpoint key %q unknown", cp.KeyID)}
+		case !ed25519.Verify(key, checkpointMessage(x.Tenant, cp), cp.Signature):
+			return &ChainBreak{cp.Seq, "checkpoint signature invalid"}
+		case hashes[cp.Seq] == nil:
+			return &ChainBreak{cp.Seq, "events up to checkpoint missing"}
+		case !bytes.Equal(hashes[cp.Seq], cp.Hash):
+			return &ChainBreak{cp.Seq, "checkpoint does not match chain"}
+		}
+	}
+
+	if len(x.Checkpoints) == 0 {
+		return &ChainBreak{0, "no checkpoints"}
+	}
+	seqs := []int64{}
+	for _, cp := range x.Checkpoints {
+		seqs = append(seqs, cp.Seq)
+	}
+	seqs = append(seqs, int64(len(x.Events)))
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	var last int64
+	for _, seq := range seqs {
+		if seq-last > every {
+			return &ChainBreak{last + every, fmt.Sprintf("no checkpoint within %v events of %v", every, last)}
+		}
+		last = seq
+	}
+
+	return verifyHead(x, keys, prev, notBefore)
+}
+
+// --- verifyHead ---
+// Check the signed head is of the last event, whose hash is last.
+func verifyHead(x *AuditChainExport, keys map[string]ed25519.PublicKey, last []byte, notBefore time.Time) error {
+	h := x.Head
+	n := int64(len(x.Events))
+	if h == nil {
+		return &ChainBreak{n, "no signed head"}
+	}
+
+	key, ok := keys[h.KeyID]
+	switch {
+	case !ok:
+		return &ChainBreak{h.Seq, fmt.Sprintf("head key %q unknown", h.KeyID)}
+	case !ed25519.Verify(key, headMessage(x.Tenant, h), h.Signature):
+		return &ChainBreak{h.Seq, "head signature invalid"}
+	case h.Seq > n:
+		return &ChainBreak{n + 1, fmt.Sprintf("events after %v missing, up to the head at %v", n, h.Seq)}
+	case h.Seq < n:
+		return &ChainBreak{h.Seq + 1, fmt.Sprintf("events past the head at %v", h.Seq)}
+	case !bytes.Equal(h.Hash, last):
+		return &ChainBreak{n, "head does not match chain"}
+	case h.Time.Before(notBefore):
+		return &ChainBreak{n, fmt.Sprintf("head of %v is before %v", h.Time.Format(time.RFC3339), notBefore.Format(time.RFC3339))}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// makeChain returns a chain of n events a minute apart, checkpointed and
+// with its head signed as a would.
+func makeChain(a *ChainedAudit, n int) *AuditChainExport {
+	x := &AuditChainExport{Tenant: "tenant"}
+	start := time.Now().Add(-time.Hour)
+	var head auditChainHead
+	for i := 0; i < n; i++ {
+		ce := link(head, &AuditEvent{
+			Time:         start.Add(time.Duration(i) * time.Minute),
+			Type:         AuditSign,
+			UserIdentity: fmt.Sprintf("user-%v", i),
+			Outcome:      AuditSuccess,
+		})
+		x.Events = append(x.Events, ce)
+		if a.checkpointAt(ce.Seq) {
+			x.Checkpoints = append(x.Checkpoints, a.signCheckpoint(x.Tenant, ce))
+		}
+		head = auditChainHead{Seq: ce.Seq, Hash: ce.Hash}
+		x.Head = a.signHead(x.Tenant, ce)
+	}
+	return x
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	a := &ChainedAudit{Key: priv, KeyID: "k1", CheckpointEvery: 2}
+	keys := map[string]ed25519.PublicKey{"k1": pub}
+
+	// Checkpointed at 1, 2 and 4.
+	x := makeChain(a, 5)
+	latest := x.Events[4].Time
+	if err := VerifyAuditChain(x, keys, 2, latest); err != nil {
+		t.Fatalf("Expected a valid chain, got %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		tamper func(x *AuditChainExport)
+		seq    int64
+	}{
+		"altered": {func(x *AuditChainExport) { x.Events[2].Outcome = AuditFailure }, 3},
+		"deleted": {func(x *AuditChainExport) { x.Events = append(x.Events[:1], x.Events[2:]...) }, 2},
+		"rehashed": {func(x *AuditChainExport) {
+			x.Events[1].Detail = "rewritten"
+			x.Events[1].Hash = chainHash(x.Events[1])
+		}, 3},
+		"truncated": {func(x *AuditChainExport) { x.Events = x.Events[:3] }, 4},
+		"tenant":    {func(x *AuditChainExport) { x.Tenant = "other" }, 1},
+		"unknown key": {func(x *AuditChainExport) {
+			x.Checkpoints[2].KeyID = "k2"
+		}, 4},
+		"no checkpoints": {func(x *AuditChainExport) { x.Checkpoints = nil }, 0},
+		"checkpoint dropped": {func(x *AuditChainExport) {
+			x.Checkpoints = append(x.Checkpoints[:1], x.Checkpoints[2:]...)
+		}, 3},
+		"tail truncated": {func(x *AuditChainExport) {
+			x.Checkpoints = x.Checkpoints[:2]
+		}, 4},
+		"truncated to a checkpoint": {func(x *AuditChainExport) { x.Events = x.Events[:4] }, 5},
+		"no head":                   {func(x *AuditChainExport) { x.Head = nil }, 5},
+		"head unsigned": {func(x *AuditChainExport) {
+			x.Head.Signature = x.Checkpoints[2].Signature
+		}, 5},
+		"checkpoint as head": {func(x *AuditChainExport) {
+			x.Events = x.Events[:4]
+			x.Head = x.Checkpoints[2]
+		}, 4},
+	} {
+		x := makeChain(a, 5)
+		tc.tamper(x)
+		err := VerifyAuditChain(x, keys, 2, time.Time{})
+		if b, ok := err.(*ChainBreak); !ok || b.Seq != tc.seq {
+			t.Errorf("%v: expected a break at %v, got %v", name, tc.seq, err)
+		}
+	}
+
+	// A chain truncated to a checkpoint, with the head put back as it was
+	// then, is only detected by the head being stale.
+	x = makeChain(a, 5)
+	x.Events = x.Events[:4]
+	x.Head = a.signHead(x.Tenant, x.Events[3])
+	if err := VerifyAuditChain(x, keys, 2, time.Time{}); err != nil {
+		t.Errorf("Expected any head to be accepted without notBefore, got %v", err)
+	}
+	err := VerifyAuditChain(x, keys, 2, latest)
+	if b, ok := err.(*ChainBreak); !ok || b.Seq != 4 {
+		t.Errorf("Expected a stale head at 4, got %v", err)
+	}
+
+	// A rewritten chain, re-hashed throughout, fails at the first checkpoint
+	// after the rewrite.
+	x = makeChain(a, 5)
+	x.Events[1].Detail = "rewritten"
+	var head auditChainHead
+	for idx, ce := range x.Events {
+		ce.PrevHash = head.Hash
+		ce.Hash = chainHash(ce)
+		x.Events[idx] = ce
+		head = auditChainHead{Seq: ce.Seq, Hash: ce.Hash}
+	}
+	if err := VerifyAuditChain(x, keys, 2, time.Time{}); err == nil || err.(*ChainBreak).Seq != 2 {
+		t.Errorf("Expected a checkpoint mismatch at 2, got %v", err)
+	}
+}
+
+func TestChainedAudit(t *testing.T) {
+	ctx, done := newConsistentContext(t)
+	defer done()
+
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	a := &ChainedAudit{Key: priv, KeyID: "k1", CheckpointEvery: 2}
+
+	for i := 0; i < 3; i++ {
+		e := &AuditEvent{Time: time.Now(), Type: AuditSign, UserIdentity: "bob"}
+		if err := a.Record(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	x, err := a.Export(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(x.Events) != 3 || len(x.Checkpoints) != 2 || x.Head == nil || x.Head.Seq != 3 {
+		t.Fatalf("Expected 3 events, 2 checkpoints and the head at 3, got %v, %v and %v",
+			len(x.Events), len(x.Checkpoints), x.Head)
+	}
+
+	if err := a.Verify(ctx, map[string]ed25519.PublicKey{"k1": pub}, x.Events[2].Time); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
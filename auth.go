@@ -7,63 +7,138 @@
 package aeu2f
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
-
-	"appengine"
-	"appengine/datastore"
 
 	"github.com/tstranex/u2f"
 )
 
 // loadRegistrations returns a slice of the registrations for a given user
-// identity.
-func loadRegistrations(ctx appengine.Context, userIdentity string) ([]*datastore.Key, []*Registration, error) {
-	regis := []*Registration{}
-	// Load Registrations
-	pkey := MakeParentKey(ctx)
-	q := datastore.NewQuery("Registration").
-		Ancestor(pkey).
-		Filter("UserIdentity =", userIdentity)
-
-	// Retrieve & save registrations into array of challenges
-	keys, err := q.GetAll(ctx, &regis)
+// identity, with their ID set.
+func loadRegistrations(ctx context.Context, userIdentity string) (_ []*Registration, err error) {
+	traced, end := startStorage(ctx, "loadRegistrations")
+	defer func() { end(err) }()
+
+	regis, err := Storage.Registrations(traced, userIdentity)
 	if err != nil {
-		return nil, nil, fmt.Errorf("datastore GetAll error: %+v", err)
+		return nil, err
 	}
 
-	return keys, regis, nil
+	traceRegistrations(ctx, len(regis))
+	return regis, nil
 }
 
-func signChallengeRequest(c u2f.Challenge, regi Registration) (*u2f.SignRequest, error) {
+// --- putRegistration ---
+// Store a registration, returning its ID.
+func putRegistration(ctx context.Context, regi *Registration) (_ int64, err error) {
+	ctx, end := startStorage(ctx, "putRegistration")
+	defer func() { end(err) }()
+
+	return Storage.PutRegistration(ctx, regi)
+}
+
+// counterAttempts bounds the retries of a counter update, both of its
+// transaction and of the compare-and-swap.
+const counterAttempts = 10
+
+// --- casCounter ---
+// Set the counter of the registration with the given ID to counter, provided
+// it is still old.  Return the registration as stored, and whether it was
+// updated.
+func casCounter(ctx context.Context, id, old, counter int64) (_ *Registration, ok bool, err error) {
+	ctx, end := startStorage(ctx, "casCounter")
+	defer func() { end(err) }()
+
+	regi, ok, err := Storage.CompareAndSwapCounter(ctx, id, old, counter)
+	switch err {
+	case ErrNoSuchRegistration:
+		return nil, false, &VerificationError{"Sign", errors.New("registration deleted")}
+	case ErrRevoked:
+		// The registration was revoked since it was loaded, e.g. from a
+		// stale cache.
+		return nil, false, &VerificationError{"Sign", ErrRevoked}
+	}
+	return regi, ok, err
+}
+
+// --- u2fKey ---
+// Return the key of the registration, for u2f: from KeyHandle and PublicKey,
+// or, for a registration stored before they were, by parsing the
+// registration with its attestation certificate.
+func u2fKey(ctx context.Context, regi *Registration) (_ *u2f.Registration, err error) {
+	if len(regi.KeyHandle) > 0 && len(regi.PublicKey) > 0 {
+		x, y := elliptic.Unmarshal(elliptic.P256(), regi.PublicKey)
+		if x == nil {
+			return nil, errors.New("invalid public key")
+		}
+		return &u2f.Registration{
+			KeyHandle: regi.KeyHandle,
+			PubKey:    ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y},
+		}, nil
+	}
+
+	_, end := startSpan(ctx, "u2f.parseRegistration")
+	defer func() { end(err) }()
+
 	var reg u2f.Registration
-	buf := regi.U2FRegistrationBytes
+	if err := reg.UnmarshalBinary(regi.U2FRegistrationBytes); err != nil {
+		return nil, fmt.Errorf("reg.UnmarshalBinary error: %v", err)
+	}
+	return &reg, nil
+}
 
-	if err := reg.UnmarshalBinary(buf); err != nil {
-		return nil, fmt.Errorf("reg.UnmarshalBinary %v", err)
+// --- keyHandle ---
+// Return the key handle of the registration, parsing it only if it was
+// stored before KeyHandle was.
+func keyHandle(ctx context.Context, regi *Registration) ([]byte, error) {
+	if len(regi.KeyHandle) > 0 {
+		return regi.KeyHandle, nil
 	}
+	reg, err := u2fKey(ctx, regi)
+	if err != nil {
+		return nil, err
+	}
+	return reg.KeyHandle, nil
+}
 
-	return c.SignRequest(reg), nil
+// --- signChallengeRequest ---
+// Return the sign request for the registration.
+func signChallengeRequest(ctx context.Context, c u2f.Challenge, regi Registration) (*u2f.SignRequest, error) {
+	kh, err := keyHandle(ctx, &regi)
+	if err != nil {
+		return nil, err
+	}
+	return c.SignRequest(u2f.Registration{KeyHandle: kh}), nil
 }
 
 // NewSignChallenge returns a challenge for the U2F device.
 //
-func NewSignChallenge(ctx appengine.Context, userIdentity string) ([]*u2f.SignRequest, error) {
+func NewSignChallenge(ctx context.Context, userIdentity string) (_ []*u2f.SignRequest, err error) {
+	ctx, start := begin(ctx, AuditSignChallenge, userIdentity)
+	defer func() { finish(ctx, AuditSignChallenge, userIdentity, 0, start, err) }()
 
 	// Create challenge
-	c, err := u2f.NewChallenge(AppID, TrustedFacets)
+	c, err := u2f.NewChallenge(appID(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("u2f.NewChallenge error: %v", err)
 	}
 
-	_, regis, err := loadRegistrations(ctx, userIdentity)
+	regis, err := loadRegistrations(ctx, userIdentity)
 	if err != nil {
 		return nil, fmt.Errorf("loadRegistrations %+v", err)
 	}
 
 	var reqs = []*u2f.SignRequest{}
 	for _, regi := range regis {
-		signr, err := signChallengeRequest(*c, *regi)
+		if !regi.Revoked.IsZero() {
+			continue
+		}
+		signr, err := signChallengeRequest(ctx, *c, *regi)
 		if err != nil {
 			return nil, fmt.Errorf("Signing error: %+v", err)
 		}
@@ -72,67 +147,137 @@ func NewSignChallenge(ctx appengine.Context, userIdentity string) ([]*u2f.SignRe
 	}
 
 	// Save challenge to database.
-	ckey := makeKey(ctx, userIdentity, "SignChallenge")
-	if _, err := datastore.Put(ctx, ckey, c); err != nil {
-		return nil, fmt.Errorf("datastore.Put error: %v", err)
+	if err := putChallenge(ctx, ChallengeSign, userIdentity, c); err != nil {
+		return nil, err
 	}
 
 	// Return challenge
-	log.Printf("🖋  New Sign Challenges: %+v [%+v]", reqs, ckey)
+	Log.Debug("sign challenges", "user", logUser(userIdentity), "keys", len(reqs))
 	return reqs, nil
 }
 
 // --- testSignChallenge ---
-func testSignChallenge(challenge u2f.Challenge, regi Registration, signResp u2f.SignResponse) error {
-	var reg u2f.Registration
-	if err := reg.UnmarshalBinary(regi.U2FRegistrationBytes); err != nil {
-		return fmt.Errorf("reg.UnmarshalBinary error: %v", err)
-	}
+func testSignChallenge(ctx context.Context, challenge u2f.Challenge, reg *u2f.Registration, counter int64, signResp u2f.SignResponse) (_ uint32, err error) {
+	_, end := startSpan(ctx, "u2f.authenticate")
+	defer func() { end(err) }()
 
 	// The AppEngine datastore does not accept uint types, see:
 	// https://github.com/golang/appengine/blob/master/datastore/save.go#L148
 	// So we cast int64 to uint32 when coming from the datastore, and back.
-	newCounter, err := reg.Authenticate(signResp, challenge, uint32(regi.Counter))
+	newCounter, err := reg.Authenticate(signResp, challenge, uint32(counter))
 	if err != nil {
-		return fmt.Errorf("VerifySignResponse error: %v", err)
+		return 0, fmt.Errorf("VerifySignResponse error: %v", err)
 	}
 
-	// Update the counter for the next auth.
-	regi.Counter = int64(newCounter)
-	return nil
+	return newCounter, nil
 }
 
 // Sign verifies or rejects a U2F response.
-func Sign(ctx appengine.Context, userIdentity string, signResp u2f.SignResponse) error {
-	ckey := makeKey(ctx, userIdentity, "SignChallenge")
-	var challenge u2f.Challenge
+func Sign(ctx context.Context, userIdentity string, signResp u2f.SignResponse) error {
+	_, err := Authenticate(ctx, userIdentity, signResp)
+	return err
+}
+
+// Authenticate verifies or rejects a U2F response, like Sign, and returns the
+// registration of the key that signed it, with its updated Counter.
+//
+// The counter must increase with every response, and is updated in a
+// transaction, so of concurrent responses with the same counter (e.g. a
+// replayed or cloned response) at most one succeeds.
+//
+// Repeated failures lock the user, and the client IP, out; see Throttle.
+func Authenticate(ctx context.Context, userIdentity string, signResp u2f.SignResponse) (*Registration, error) {
+	var regi *Registration
+	ctx, start := begin(ctx, AuditSign, userIdentity)
+	err := throttled(ctx, userIdentity, func() (err error) {
+		regi, err = authenticate(ctx, userIdentity, signResp)
+		return err
+	})
+
+	if regi != nil {
+		finish(ctx, AuditSign, userIdentity, regi.ID, start, err)
+	} else {
+		finish(ctx, AuditSign, userIdentity, 0, start, err)
+	}
+	return regi, err
+}
 
+// --- authenticate ---
+//
+func authenticate(ctx context.Context, userIdentity string, signResp u2f.SignResponse) (*Registration, error) {
 	// Load the Challenge for this user
-	if err := datastore.Get(ctx, ckey, &challenge); err != nil {
-		return fmt.Errorf("datastore.Get error: %v", err)
+	c, err := getChallenge(ctx, ChallengeSign, userIdentity)
+	if err != nil {
+		return nil, err
+	}
+	challenge := *c
+
+	if err := checkClientData(signResp.ClientData, clientDataSign); err != nil {
+		return nil, &VerificationError{"Sign", err}
 	}
 
 	// Load the Registrations
-	keys, regis, err := loadRegistrations(ctx, userIdentity)
+	regis, err := loadRegistrations(ctx, userIdentity)
 	if err != nil {
-		return fmt.Errorf("loadRegistrations error %+v", err)
+		return nil, fmt.Errorf("loadRegistrations error %+v", err)
 	}
 
-	// Check each Registration
-	for idx, regi := range regis {
-		if err := testSignChallenge(challenge, *regi, signResp); err != nil {
-			return fmt.Errorf("Sign error: %v", err)
-		} else {
-			// Update the counter for the regi.
-			if _, err := datastore.Put(ctx, ckey, keys[idx]); err != nil {
-				return fmt.Errorf("datastore.Put error: %v", err)
+	// Find the Registration of the key that responded.  u2f checks the
+	// encoding of the key handle is exactly as it sent it.
+	kh, _ := base64.RawURLEncoding.Strict().DecodeString(signResp.KeyHandle)
+	for _, regi := range regis {
+		regKH, err := keyHandle(ctx, regi)
+		if err != nil {
+			return nil, fmt.Errorf("Signing error: %+v", err)
+		}
+		if !bytes.Equal(regKH, kh) {
+			continue
+		}
+		if !regi.Revoked.IsZero() {
+			return nil, &VerificationError{"Sign", ErrRevoked}
+		}
+		reg, err := u2fKey(ctx, regi)
+		if err != nil {
+			return nil, fmt.Errorf("Signing error: %+v", err)
+		}
+
+		// Verify against the stored counter, then store the new counter
+		// unless a concurrent Sign has changed it meanwhile, in which case
+		// verify again against the counter it stored.
+		for attempt := 1; ; attempt++ {
+			newCounter, err := testSignChallenge(ctx, challenge, reg, regi.Counter, signResp)
+			if err == nil && int64(newCounter) <= regi.Counter {
+				// u2f accepts a repeated counter, i.e. a replayed response.
+				err = fmt.Errorf("counter %v not above %v", newCounter, regi.Counter)
+			}
+			if err != nil {
+				// A counter going backwards suggests the key has been cloned.
+				if c, ok := signCounter(signResp); ok && int64(c) < regi.Counter {
+					Log.Error(AuditCounterRegression, "op", AuditCounterRegression,
+						"user", logUser(userIdentity), "registration", regi.ID,
+						"counter", c, "stored", regi.Counter)
+					audit(ctx, AuditCounterRegression, userIdentity, regi.ID, err)
+				}
+				return nil, &VerificationError{"Sign", err}
+			}
+
+			cur, ok, err := casCounter(ctx, regi.ID, regi.Counter, int64(newCounter))
+			if err != nil {
+				return nil, err
 			}
+			if ok {
+				consumeChallenge(ctx, ChallengeSign, userIdentity)
 
-			// Success -- A U2F response to a sign challenge succeeded.
-			return nil
+				// Success -- A U2F response to a sign challenge succeeded.
+				return cur, nil
+			}
+			if attempt == counterAttempts {
+				return nil, fmt.Errorf("casCounter error: counter changed %v times", attempt)
+			}
+			regi = cur
 		}
-		return fmt.Errorf("Challenge failed for known registrations.")
 	}
 
-	return nil
+	// The key handle is not in the error, since it is logged.
+	return nil, &VerificationError{"Sign", errors.New("no registration for the key handle")}
 }
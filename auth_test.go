@@ -5,3 +5,319 @@
 // License: MIT
 //
 package aeu2f
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/brianmhunt/aeu2f-go/aeu2ftest"
+	"github.com/tstranex/u2f"
+)
+
+const testAppID = "https://aeu2f.example.com"
+
+// setupMemoryStore sets the package up to run without a datastore: a
+// MemoryStore, the given AppID, and no logging.
+func setupMemoryStore(tb testing.TB, appID string) context.Context {
+	oldStorage, oldLog, oldAppID, oldFacets := Storage, Log, AppID, TrustedFacets
+	tb.Cleanup(func() { Storage, Log, AppID, TrustedFacets = oldStorage, oldLog, oldAppID, oldFacets })
+
+	Storage = NewMemoryStore()
+	Log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	AppID, TrustedFacets = appID, []string{appID}
+	return context.Background()
+}
+
+// registerAuthenticator registers a new software authenticator for the
+// user, and returns it with a sign request.
+func registerAuthenticator(t *testing.T, ctx context.Context, userIdentity string) (*aeu2ftest.Authenticator, *u2f.SignRequest) {
+	oldAppID, oldFacets := AppID, TrustedFacets
+	AppID, TrustedFacets = testAppID, []string{testAppID}
+	t.Cleanup(func() { AppID, TrustedFacets = oldAppID, oldFacets })
+
+	a := aeu2ftest.New()
+	req, err := NewRegistrationChallenge(ctx, userIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := a.Register(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := StoreResponse(ctx, userIdentity, *resp); err != nil {
+		t.Fatalf("StoreResponse: %v", err)
+	}
+
+	// The key handle follows the 0x05 and the 65 byte public key.
+	data, err := base64.RawURLEncoding.DecodeString(resp.RegistrationData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kh := base64.RawURLEncoding.EncodeToString(data[67 : 67+int(data[66])])
+
+	reqs, err := NewSignChallenge(ctx, userIdentity)
+	if err != nil {
+		t.Fatalf("NewSignChallenge: %v", err)
+	}
+	for _, req := range reqs {
+		if req.KeyHandle == kh {
+			return a, req
+		}
+	}
+	t.Fatalf("Expected a sign request for the new key, got %v", reqs)
+	return nil, nil
+}
+
+// sign answers the request with a, or fails the test.
+func sign(t *testing.T, a *aeu2ftest.Authenticator, req *u2f.SignRequest) u2f.SignResponse {
+	resp, err := a.Sign(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *resp
+}
+
+func TestRegisterSign(t *testing.T) {
+	ctx := setupMemoryStore(t, testAppID)
+
+	a, _ := registerAuthenticator(t, ctx, "bob")
+	b, _ := registerAuthenticator(t, ctx, "bob")
+
+	for i, token := range []*aeu2ftest.Authenticator{a, b, a} {
+		reqs, err := NewSignChallenge(ctx, "bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reqs) != 2 {
+			t.Fatalf("Expected a request per key, got %v", len(reqs))
+		}
+
+		resp, err := token.SignAny(reqs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		regi, err := Authenticate(ctx, "bob", *resp)
+		if err != nil {
+			t.Fatalf("Sign %v: %v", i, err)
+		}
+		if regi.Counter != int64(token.Counter) || regi.ID == 0 {
+			t.Errorf("Sign %v: unexpected registration %+v", i, regi)
+		}
+	}
+}
+
+func TestSignFailures(t *testing.T) {
+	ctx := setupMemoryStore(t, testAppID)
+
+	// Each case counts as a failure; none should lock bob out.
+	oldFree := ThrottleFreeFailures
+	ThrottleFreeFailures = 1000
+	defer func() { ThrottleFreeFailures = oldFree }()
+
+	other, oreq := registerAuthenticator(t, ctx, "alice")
+	a, req := registerAuthenticator(t, ctx, "bob")
+
+	cases := []struct {
+		name string
+		resp func() u2f.SignResponse
+	}{
+		{"untrusted origin", func() u2f.SignResponse {
+			a.Origin = "https://evil.example.com"
+			defer func() { a.Origin = "" }()
+			return sign(t, a, req)
+		}},
+		{"no user presence", func() u2f.SignResponse {
+			a.NoUserPresence = true
+			defer func() { a.NoUserPresence = false }()
+			return sign(t, a, req)
+		}},
+		{"registration client data", func() u2f.SignResponse {
+			a.ClientDataType = aeu2ftest.TypeRegister
+			defer func() { a.ClientDataType = "" }()
+			return sign(t, a, req)
+		}},
+		{"unknown key", func() u2f.SignResponse {
+			resp := sign(t, a, req)
+			resp.KeyHandle = "AAAA"
+			return resp
+		}},
+		{"other user's key", func() u2f.SignResponse {
+			return sign(t, other, oreq)
+		}},
+	}
+
+	for _, c := range cases {
+		err := Sign(ctx, "bob", c.resp())
+		if _, ok := err.(*VerificationError); !ok {
+			t.Errorf("%v: expected a VerificationError, got %v", c.name, err)
+		}
+	}
+
+	// The challenge is still there, for a valid response.
+	if err := Sign(ctx, "bob", sign(t, a, req)); err != nil {
+		t.Errorf("Expected success after failures, got %v", err)
+	}
+}
+
+func TestSignClonedToken(t *testing.T) {
+	ctx := setupMemoryStore(t, testAppID)
+
+	m := &memoryAudit{}
+	Audit = m
+	defer func() { Audit = nil }()
+
+	a, req := registerAuthenticator(t, ctx, "bob")
+	a.Counter = 10
+	if err := Sign(ctx, "bob", sign(t, a, req)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A clone of the token, with an older counter.
+	a.Counter = 5
+	reqs, err := NewSignChallenge(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Sign(ctx, "bob", sign(t, a, reqs[0])); err == nil {
+		t.Fatal("Expected a counter regression to fail")
+	}
+
+	found := false
+	for _, e := range m.events {
+		found = found || e.Type == AuditCounterRegression
+	}
+	if !found {
+		t.Error("Expected a counter regression event")
+	}
+}
+
+func TestSignReplay(t *testing.T) {
+	ctx := setupMemoryStore(t, testAppID)
+
+	a, req := registerAuthenticator(t, ctx, "bob")
+	resp := sign(t, a, req)
+	regi, err := Authenticate(ctx, "bob", resp)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if regi.Counter != 1 {
+		t.Errorf("Expected counter 1, got %v", regi.Counter)
+	}
+
+	// The response cannot be replayed, even with a fresh challenge.
+	if _, err := NewSignChallenge(ctx, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Sign(ctx, "bob", resp); err == nil {
+		t.Error("Expected a replayed response to fail")
+	}
+}
+
+func TestSignLegacyRegistration(t *testing.T) {
+	ctx := setupMemoryStore(t, testAppID)
+
+	// Store the registration as it was before its key was, as bytes only.
+	a, _ := registerAuthenticator(t, ctx, "bob")
+	regis, err := Storage.Registrations(ctx, "bob")
+	if err != nil || len(regis) != 1 {
+		t.Fatalf("Expected a registration, got %v, %v", regis, err)
+	}
+	if len(regis[0].KeyHandle) == 0 || len(regis[0].PublicKey) != 65 {
+		t.Fatalf("Expected the key to be stored, got %+v", regis[0])
+	}
+	if err := Storage.DeleteRegistration(ctx, "bob", regis[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	legacy := *regis[0]
+	legacy.KeyHandle, legacy.PublicKey = nil, nil
+	if _, err := Storage.PutRegistration(ctx, &legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs, err := NewSignChallenge(ctx, "bob")
+	if err != nil {
+		t.Fatalf("NewSignChallenge: %v", err)
+	}
+	resp, err := a.SignAny(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Authenticate(ctx, "bob", *resp); err != nil {
+		t.Errorf("Authenticate: %v", err)
+	}
+}
+
+// barrierStore holds each CompareAndSwapCounter until n have been made, so
+// that concurrent Signs have all loaded the challenge and the registration
+// before any of them stores a counter.
+type barrierStore struct {
+	Store
+	arrived *sync.WaitGroup
+}
+
+func (b barrierStore) CompareAndSwapCounter(ctx context.Context, id, old, counter int64) (*Registration, bool, error) {
+	b.arrived.Done()
+	b.arrived.Wait()
+	return b.Store.CompareAndSwapCounter(ctx, id, old, counter)
+}
+
+func TestConcurrentSign(t *testing.T) {
+	ctx := setupMemoryStore(t, testAppID)
+
+	oldFree := ThrottleFreeFailures
+	ThrottleFreeFailures = 1000
+	defer func() { ThrottleFreeFailures = oldFree }()
+
+	a, req := registerAuthenticator(t, ctx, "bob")
+
+	// The same response, n times at once.
+	const n = 10
+	resp := sign(t, a, req)
+	var arrived sync.WaitGroup
+	arrived.Add(n)
+	Storage = barrierStore{Storage, &arrived}
+
+	var wg sync.WaitGroup
+	regis := make([]*Registration, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			regis[i], errs[i] = Authenticate(ctx, "bob", resp)
+		}(i)
+	}
+	wg.Wait()
+
+	// Exactly one stores its counter, and the others fail verification
+	// against it.
+	var winner *Registration
+	for i, err := range errs {
+		if err == nil {
+			if winner != nil {
+				t.Errorf("Expected one success, got another: %+v", regis[i])
+			}
+			winner = regis[i]
+			continue
+		}
+		if _, ok := err.(*VerificationError); !ok || !strings.Contains(err.Error(), "counter 1 not above 1") {
+			t.Errorf("Expected a counter conflict, got %T %v", err, err)
+		}
+	}
+	if winner == nil {
+		t.Fatal("Expected a response to succeed")
+	}
+
+	regs, err := ListRegistrations(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if regs[0].Counter != winner.Counter || winner.Counter != 1 {
+		t.Errorf("Expected the stored counter to be 1, got %v and %v", regs[0].Counter, winner.Counter)
+	}
+}
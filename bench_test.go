@@ -0,0 +1,91 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/brianmhunt/aeu2f-go/aeu2ftest"
+)
+
+// The benchmarks run against a MemoryStore, so that they measure this
+// package rather than the datastore, e.g.
+// 	go test -run XXX -bench . -benchmem
+
+// benchKeys are the numbers of keys per user benchmarked.
+var benchKeys = []int{1, 5, 50}
+
+// benchRegister registers n software authenticators for the user.
+func benchRegister(b *testing.B, ctx context.Context, userIdentity string, n int) []*aeu2ftest.Authenticator {
+	tokens := make([]*aeu2ftest.Authenticator, n)
+	for i := range tokens {
+		tokens[i] = aeu2ftest.NewFromSeed([]byte(fmt.Sprint("bench", i)))
+		req, err := NewRegistrationChallenge(ctx, userIdentity)
+		if err != nil {
+			b.Fatal(err)
+		}
+		resp, err := tokens[i].Register(req)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := StoreResponse(ctx, userIdentity, *resp); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return tokens
+}
+
+func BenchmarkNewSignChallenge(b *testing.B) {
+	for _, n := range benchKeys {
+		b.Run(fmt.Sprintf("keys=%v", n), func(b *testing.B) {
+			ctx := setupMemoryStore(b, testAppID)
+			benchRegister(b, ctx, "bob", n)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := NewSignChallenge(ctx, "bob"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAuthenticate(b *testing.B) {
+	for _, n := range benchKeys {
+		b.Run(fmt.Sprintf("keys=%v", n), func(b *testing.B) {
+			ctx := setupMemoryStore(b, testAppID)
+			tokens := benchRegister(b, ctx, "bob", n)
+
+			// Sign with the last key registered; the order in which the
+			// keys are tried depends on the Store.
+			token := tokens[n-1]
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				reqs, err := NewSignChallenge(ctx, "bob")
+				if err != nil {
+					b.Fatal(err)
+				}
+				resp, err := token.SignAny(reqs)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				if _, err := Authenticate(ctx, "bob", *resp); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// RegistrationCache caches the registrations of users, for a CachedStore.
+// Implementations must be safe for concurrent use, and may drop entries at
+// any time.
+type RegistrationCache interface {
+	// Get returns the registrations cached under key, and whether there
+	// were any.
+	Get(ctx context.Context, key string) ([]*Registration, bool, error)
+
+	// Set caches the registrations under key.
+	Set(ctx context.Context, key string, regis []*Registration) error
+
+	// Delete removes any registrations cached under key.
+	Delete(ctx context.Context, key string) error
+}
+
+// CachedStore is a Store that reads the registrations of each user through
+// a RegistrationCache, so that NewSignChallenge and Sign do not both query
+// the Store.  Every other call goes straight to the Store.
+//
+// A user's registrations are removed from the cache by PutRegistration,
+// UpdateRegistration, DeleteRegistration and CompareAndSwapCounter.  A
+// cached registration can still be stale, until the cache drops it: an
+// LRUCache does not see the writes of other instances, and a read racing a
+// write may cache what it read before the write.  Sign only accepts a
+// response once CompareAndSwapCounter, which goes to the Store, has stored
+// its counter, and that fails for a registration that is deleted or
+// revoked: so a stale counter cannot let a replayed or cloned response
+// through, and a stale registration cannot be used after it is deleted or
+// revoked.  Other uses of a stale registration, e.g. by
+// NewSignChallenge or ListRegistrations, may still see it as it was.  With
+// more than one instance, a shared MemcacheCache also sees new
+// registrations at once.
+//
+// 	aeu2f.Storage = aeu2f.NewCachedStore(aeu2f.Storage,
+// 		aeu2f.NewLRUCache(10000, time.Minute))
+type CachedStore struct {
+	Store
+	Cache RegistrationCache
+
+	// Tenant, if set, returns the tenant of ctx, e.g. its datastore
+	// namespace, to keep apart the cached registrations of tenants.
+	Tenant func(ctx context.Context) string
+
+	// InvalidateOnly, if set, reads registrations straight from the Store,
+	// neither getting nor setting them in the cache, and only removes those
+	// that change from it: e.g. for a tool that changes the registrations
+	// an application caches, which must not see or cache stale ones.
+	InvalidateOnly bool
+}
+
+// NewCachedStore returns a CachedStore of s, with the cache c.
+func NewCachedStore(s Store, c RegistrationCache) *CachedStore {
+	return &CachedStore{Store: s, Cache: c}
+}
+
+// --- cacheKey ---
+// Return the key of the user's cached registrations.
+func (s *CachedStore) cacheKey(ctx context.Context, userIdentity string) string {
+	tenant := ""
+	if s.Tenant != nil {
+		tenant = s.Tenant(ctx)
+	}
+	return tenant + "\x00" + userIdentity
+}
+
+// --- invalidate ---
+// Remove the user's cached registrations.  Failing to is logged rather than
+// returned, since the write it follows has been made.
+func (s *CachedStore) invalidate(ctx context.Context, userIdentity string) {
+	if err := s.Cache.Delete(ctx, s.cacheKey(ctx, userIdentity)); err != nil {
+		Log.Error("cache.invalidate", "op", "cache.invalidate", "user", logUser(userIdentity), "error", err.Error())
+	}
+}
+
+// Registrations implements Store, from the cache if it can.
+func (s *CachedStore) Registrations(ctx context.Context, userIdentity string) ([]*Registration, error) {
+	if s.InvalidateOnly {
+		return s.Store.Registrations(ctx, userIdentity)
+	}
+
+	key := s.cacheKey(ctx, userIdentity)
+	regis, ok, err := s.Cache.Get(ctx, key)
+	switch {
+	case err != nil:
+		cacheTotal.inc("error")
+		Log.Error("cache.get", "op", "cache.get", "user", logUser(userIdentity), "error", err.Error())
+	case ok:
+		cacheTotal.inc("hit")
+		return regis, nil
+	default:
+		cacheTotal.inc("miss")
+	}
+
+	regis, err = s.Store.Registrations(ctx, userIdentity)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Cache.Set(ctx, key, regis); err != nil {
+		Log.Error("cache.set", "op", "cache.set", "user", logUser(userIdentity), "error", err.Error())
+	}
+	return regis, nil
+}
+
+// PutRegistration implements Store.
+func (s *CachedStore) PutRegistration(ctx context.Context, regi *Registration) (int64, error) {
+	id, err := s.Store.PutRegistration(ctx, regi)
+	s.invalidate(ctx, regi.UserIdentity)
+	return id, err
+}
+
+// CompareAndSwapCounter implements Store.  The registration is removed from
+// the cache whether or not it was updated, since if it was not the cached
+// counter may be stale.
+func (s *CachedStore) CompareAndSwapCounter(ctx context.Context, id, old, counter int64) (*Registration, bool, error) {
+	regi, swapped, err := s.Store.CompareAndSwapCounter(ctx, id, old, counter)
+	if regi != nil {
+		s.invalidate(ctx, regi.UserIdentity)
+	}
+	return regi, swapped, err
+}
+
+// UpdateRegistration implements Store.
+func (s *CachedStore) UpdateRegistration(ctx context.Context, userIdentity string, id int64, update func(*Registration) error) (*Registration, error) {
+	regi, err := s.Store.UpdateRegistration(ctx, userIdentity, id, update)
+	s.invalidate(ctx, userIdentity)
+	return regi, err
+}
+
+// DeleteRegistration implements Store.
+func (s *CachedStore) DeleteRegistration(ctx context.Context, userIdentity string, id int64) error {
+	err := s.Store.DeleteRegistration(ctx, userIdentity, id)
+	s.invalidate(ctx, userIdentity)
+	return err
+}
+
+// --- copyRegistrations ---
+// Return a deep copy of regis, so that callers cannot modify what is cached.
+func copyRegistrations(regis []*Registration) []*Registration {
+	cp := make([]*Registration, len(regis))
+	for i, regi := range regis {
+		r := *regi
+		cloneRegistration(&r)
+		cp[i] = &r
+	}
+	return cp
+}
+
+// LRUCache is a RegistrationCache in the memory of the process.  It holds
+// the registrations of up to size users, each for up to ttl, evicting the
+// least recently used.
+type LRUCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	order   *list.List // of *lruEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	regis   []*Registration
+	expires time.Time
+}
+
+// NewLRUCache returns an empty LRUCache.
+func NewLRUCache(size int, ttl time.Duration) *LRUCache {
+	return &LRUCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// Get implements RegistrationCache.
+func (c *LRUCache) Get(ctx context.Context, key string) ([]*Registration, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := el.Value.(*lruEntry)
+	if time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(el)
+	return copyRegistrations(e.regis), true, nil
+}
+
+// Set implements RegistrationCache.
+func (c *LRUCache) Set(ctx context.Context, key string, regis []*Registration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := &lruEntry{key: key, regis: copyRegistrations(regis), expires: time.Now().Add(c.ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*lruEntry).key)
+	}
+	return nil
+}
+
+// Delete implements RegistrationCache.
+func (c *LRUCache) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/brianmhunt/aeu2f-go"
+	"github.com/brianmhunt/aeu2f-go/aeu2ftest"
+	"github.com/brianmhunt/aeu2f-go/aeu2ftest/storetest"
+)
+
+// newMemcached starts a memcached stand-in for the test.
+func newMemcached(t *testing.T) *aeu2ftest.Memcached {
+	m, err := aeu2ftest.NewMemcached()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// countingStore counts the calls of Registrations.
+type countingStore struct {
+	aeu2f.Store
+	reads int
+}
+
+func (s *countingStore) Registrations(ctx context.Context, userIdentity string) ([]*aeu2f.Registration, error) {
+	s.reads++
+	return s.Store.Registrations(ctx, userIdentity)
+}
+
+func TestCachedStoreLRU(t *testing.T) {
+	storetest.RunStoreTests(t, func(t *testing.T) (context.Context, aeu2f.Store) {
+		return context.Background(), aeu2f.NewCachedStore(aeu2f.NewMemoryStore(), aeu2f.NewLRUCache(100, time.Minute))
+	})
+}
+
+func TestCachedStoreMemcache(t *testing.T) {
+	storetest.RunStoreTests(t, func(t *testing.T) (context.Context, aeu2f.Store) {
+		m := newMemcached(t)
+		return context.Background(), aeu2f.NewCachedStore(aeu2f.NewMemoryStore(), aeu2f.NewMemcacheCache(m.Addr(), time.Minute))
+	})
+}
+
+func TestCachedStoreInvalidation(t *testing.T) {
+	caches := map[string]func(t *testing.T) aeu2f.RegistrationCache{
+		"LRU": func(t *testing.T) aeu2f.RegistrationCache {
+			return aeu2f.NewLRUCache(100, time.Minute)
+		},
+		"Memcache": func(t *testing.T) aeu2f.RegistrationCache {
+			return aeu2f.NewMemcacheCache(newMemcached(t).Addr(), time.Minute)
+		},
+	}
+
+	for name, newCache := range caches {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			cs := &countingStore{Store: aeu2f.NewMemoryStore()}
+			s := aeu2f.NewCachedStore(cs, newCache(t))
+
+			// read returns alice's registrations, and whether they were read
+			// from the cache.
+			read := func() ([]*aeu2f.Registration, bool) {
+				reads := cs.reads
+				regis, err := s.Registrations(ctx, "alice")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return regis, cs.reads == reads
+			}
+
+			if _, cached := read(); cached {
+				t.Error("Expected the first read to miss")
+			}
+			if _, cached := read(); !cached {
+				t.Error("Expected the second read to hit")
+			}
+
+			id, err := s.PutRegistration(ctx, &aeu2f.Registration{UserIdentity: "alice", Created: time.Now()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if regis, cached := read(); cached || len(regis) != 1 {
+				t.Errorf("Expected PutRegistration to invalidate, got %v from the cache: %v", len(regis), cached)
+			}
+
+			if _, _, err := s.CompareAndSwapCounter(ctx, id, 0, 1); err != nil {
+				t.Fatal(err)
+			}
+			if regis, cached := read(); cached || len(regis) != 1 || regis[0].Counter != 1 {
+				t.Errorf("Expected CompareAndSwapCounter to invalidate, got %+v from the cache: %v", regis, cached)
+			}
+
+			// A failed swap invalidates too, since the cache may be stale.
+			read()
+			if _, _, err := s.CompareAndSwapCounter(ctx, id, 0, 2); err != nil {
+				t.Fatal(err)
+			}
+			if _, cached := read(); cached {
+				t.Error("Expected a failed CompareAndSwapCounter to invalidate")
+			}
+
+			if err := s.DeleteRegistration(ctx, "alice", id); err != nil {
+				t.Fatal(err)
+			}
+			if regis, cached := read(); cached || len(regis) != 0 {
+				t.Errorf("Expected DeleteRegistration to invalidate, got %v from the cache: %v", len(regis), cached)
+			}
+		})
+	}
+}
+
+func TestCachedStoreTenants(t *testing.T) {
+	type tenantKey struct{}
+	ctx := context.Background()
+	ctxA := context.WithValue(ctx, tenantKey{}, "a")
+	ctxB := context.WithValue(ctx, tenantKey{}, "b")
+
+	// Tenants share the cache but not the store.
+	cache := aeu2f.NewLRUCache(100, time.Minute)
+	stores := map[string]aeu2f.Store{"a": aeu2f.NewMemoryStore(), "b": aeu2f.NewMemoryStore()}
+	tenant := func(ctx context.Context) string { return ctx.Value(tenantKey{}).(string) }
+	sA := &aeu2f.CachedStore{Store: stores["a"], Cache: cache, Tenant: tenant}
+	sB := &aeu2f.CachedStore{Store: stores["b"], Cache: cache, Tenant: tenant}
+
+	if _, err := sA.PutRegistration(ctxA, &aeu2f.Registration{UserIdentity: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if regis, _ := sA.Registrations(ctxA, "alice"); len(regis) != 1 {
+		t.Fatalf("Expected a registration, got %v", len(regis))
+	}
+	if regis, _ := sB.Registrations(ctxB, "alice"); len(regis) != 0 {
+		t.Errorf("Expected no registrations in another tenant, got %v", len(regis))
+	}
+}
+
+func TestCachedStoreInvalidateOnly(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStore{Store: aeu2f.NewMemoryStore()}
+	cache := aeu2f.NewLRUCache(100, time.Minute)
+	app := aeu2f.NewCachedStore(store, cache)
+	tool := &aeu2f.CachedStore{Store: store, Cache: cache, InvalidateOnly: true}
+
+	id, err := tool.PutRegistration(ctx, &aeu2f.Registration{UserIdentity: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The tool reads from the store, and does not fill the cache.
+	for i := 1; i <= 2; i++ {
+		if regis, _ := tool.Registrations(ctx, "bob"); len(regis) != 1 || store.reads != i {
+			t.Fatalf("Expected read %v of a registration, got %v after %v", i, len(regis), store.reads)
+		}
+	}
+	app.Registrations(ctx, "bob")
+	app.Registrations(ctx, "bob")
+	if store.reads != 3 {
+		t.Fatalf("Expected the application to read once, then from the cache, got %v reads", store.reads)
+	}
+
+	// The tool's change removes the application's cached registrations.
+	if _, err := tool.UpdateRegistration(ctx, "bob", id, func(regi *aeu2f.Registration) error {
+		regi.Name = "spare"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if regis, _ := app.Registrations(ctx, "bob"); len(regis) != 1 || regis[0].Name != "spare" {
+		t.Errorf("Expected the renamed registration, got %+v", regis)
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	ctx := context.Background()
+	regis := func(user string) []*aeu2f.Registration {
+		return []*aeu2f.Registration{{UserIdentity: user, KeyHandle: []byte(user)}}
+	}
+	cached := func(c *aeu2f.LRUCache, key string) bool {
+		_, ok, _ := c.Get(ctx, key)
+		return ok
+	}
+
+	c := aeu2f.NewLRUCache(2, time.Minute)
+	c.Set(ctx, "a", regis("a"))
+	c.Set(ctx, "b", regis("b"))
+	cached(c, "a")
+	c.Set(ctx, "c", regis("c"))
+	if !cached(c, "a") || cached(c, "b") || !cached(c, "c") {
+		t.Error("Expected the least recently used to be evicted")
+	}
+
+	got, _, _ := c.Get(ctx, "a")
+	got[0].KeyHandle[0] = 'X'
+	if got, _, _ := c.Get(ctx, "a"); got[0].KeyHandle[0] != 'a' {
+		t.Error("Expected the cached registrations not to change with the caller's")
+	}
+
+	c = aeu2f.NewLRUCache(2, time.Millisecond)
+	c.Set(ctx, "a", regis("a"))
+	time.Sleep(5 * time.Millisecond)
+	if cached(c, "a") {
+		t.Error("Expected the registrations to expire")
+	}
+}
+
+func TestCachedStoreStaleCounter(t *testing.T) {
+	oldStorage, oldAppID, oldFacets := aeu2f.Storage, aeu2f.AppID, aeu2f.TrustedFacets
+	defer func() { aeu2f.Storage, aeu2f.AppID, aeu2f.TrustedFacets = oldStorage, oldAppID, oldFacets }()
+	aeu2f.AppID = "https://aeu2f.example.com"
+	aeu2f.TrustedFacets = []string{aeu2f.AppID}
+	ctx := context.Background()
+
+	// Two instances, each with its own LRUCache, share a store.
+	shared := aeu2f.NewMemoryStore()
+	instanceA := aeu2f.NewCachedStore(shared, aeu2f.NewLRUCache(100, time.Minute))
+	instanceB := aeu2f.NewCachedStore(shared, aeu2f.NewLRUCache(100, time.Minute))
+	aeu2f.Storage = instanceA
+
+	a := aeu2ftest.NewFromSeed([]byte("stale"))
+	req, err := aeu2f.NewRegistrationChallenge(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := a.Register(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := aeu2f.StoreResponse(ctx, "bob", *resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// signVia answers a challenge of the instance with the authenticator.
+	signVia := func(s aeu2f.Store, token *aeu2ftest.Authenticator) error {
+		aeu2f.Storage = s
+		reqs, err := aeu2f.NewSignChallenge(ctx, "bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		signResp, err := token.SignAny(reqs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = aeu2f.Authenticate(ctx, "bob", *signResp)
+		return err
+	}
+
+	// Instance A caches counter 0; instance B then accepts counter 1.
+	if _, err := instanceA.Registrations(ctx, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := signVia(instanceB, a); err != nil {
+		t.Fatalf("Sign via B: %v", err)
+	}
+
+	// A clone of the token, also at counter 1, is refused by instance A,
+	// though its cache has counter 0.
+	clone := aeu2ftest.NewFromSeed([]byte("stale"))
+	if err := signVia(instanceA, clone); err == nil {
+		t.Error("Expected a cloned token to be refused with a stale cached counter")
+	}
+	if err := signVia(instanceA, a); err != nil {
+		t.Errorf("Sign via A: %v", err)
+	}
+
+	// Instance B revokes the key, which instance A has cached unrevoked.
+	regis, err := instanceA.Registrations(ctx, "bob")
+	if err != nil || len(regis) != 1 {
+		t.Fatalf("Expected bob's registration, got %v, %v", regis, err)
+	}
+	aeu2f.Storage = instanceB
+	if _, err := aeu2f.RevokeRegistration(ctx, "bob", regis[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := signVia(instanceA, a); !errors.Is(err, aeu2f.ErrRevoked) {
+		t.Errorf("Expected ErrRevoked with a stale cached registration, got %v", err)
+	}
+}
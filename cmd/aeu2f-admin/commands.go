@@ -0,0 +1,300 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brianmhunt/aeu2f-go"
+)
+
+// command runs a subcommand, with its arguments, writing its output to out.
+type command struct {
+	args int
+	run  func(ctx context.Context, out *output, args []string) error
+}
+
+// commands are the subcommands, by name.
+var commands = map[string]command{
+	"users":            {0, users},
+	"list":             {1, list},
+	"show":             {2, show},
+	"rename":           {3, rename},
+	"revoke":           {2, revoke},
+	"delete":           {2, remove},
+	"reset-counter":    {2, resetCounter},
+	"clear-lockout":    {1, clearLockout},
+	"clear-ip-lockout": {1, clearIPLockout},
+	"purge-challenges": {0, purgeChallenges},
+	"export":           {1, export},
+	"import":           {1, importer(aeu2f.ImportRegistrations)},
+	"import-u2flib":    {1, importer(aeu2f.ImportU2FLibDevices)},
+	"import-u2fval":    {1, importer(aeu2f.ImportU2FValDevices)},
+}
+
+// --- execute ---
+// Run the command of args, with aeu2f.Storage, writing its output to w.
+func execute(ctx context.Context, w io.Writer, opts *options, args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return usagef("unknown command %q", args[0])
+	}
+	if len(args)-1 != cmd.args {
+		return usagef("%v takes %v arguments, got %v", args[0], cmd.args, len(args)-1)
+	}
+
+	out := &output{w: w, opts: opts}
+	return cmd.run(ctx, out, args[1:])
+}
+
+// output writes a command's result as a table, or as JSON, as the options
+// ask.
+type output struct {
+	w    io.Writer
+	opts *options
+}
+
+// --- write ---
+// Write v as JSON, or else the rows of a table; the first row is the header.
+func (o *output) write(v interface{}, rows [][]string) error {
+	if o.opts.format == "json" {
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 8, 2, ' ', 0)
+	for _, row := range rows {
+		for i, cell := range row {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, cell)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// --- parseID ---
+func parseID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, usagef("invalid registration ID %q", s)
+	}
+	return id, nil
+}
+
+// --- formatTime ---
+// Format t for a table, with a dash for zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// --- registrationRows ---
+// Return the table of regis.
+func registrationRows(regis []*aeu2f.Registration) [][]string {
+	rows := [][]string{{"ID", "NAME", "COUNTER", "CREATED", "REVOKED"}}
+	for _, regi := range regis {
+		rows = append(rows, []string{
+			strconv.FormatInt(regi.ID, 10), regi.Name, strconv.FormatInt(regi.Counter, 10),
+			formatTime(regi.Created), formatTime(regi.Revoked),
+		})
+	}
+	return rows
+}
+
+// --- findRegistration ---
+// Return the user's registration with the given ID.
+func findRegistration(ctx context.Context, userIdentity string, id int64) (*aeu2f.Registration, error) {
+	regis, err := aeu2f.ListRegistrations(ctx, userIdentity)
+	if err != nil {
+		return nil, err
+	}
+	for _, regi := range regis {
+		if regi.ID == id {
+			return regi, nil
+		}
+	}
+	return nil, aeu2f.ErrNoSuchRegistration
+}
+
+// --- users ---
+func users(ctx context.Context, out *output, args []string) error {
+	users, err := aeu2f.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{{"USER"}}
+	for _, u := range users {
+		rows = append(rows, []string{u})
+	}
+	return out.write(users, rows)
+}
+
+// --- list ---
+func list(ctx context.Context, out *output, args []string) error {
+	regis, err := aeu2f.ListRegistrations(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return out.write(regis, registrationRows(regis))
+}
+
+// attestation describes the attestation certificate of a registration.
+type attestation struct {
+	Subject   string
+	Issuer    string
+	Serial    string
+	NotBefore time.Time
+	NotAfter  time.Time
+	SHA256    string
+}
+
+// --- show ---
+func show(ctx context.Context, out *output, args []string) error {
+	id, err := parseID(args[1])
+	if err != nil {
+		return err
+	}
+	regi, err := findRegistration(ctx, args[0], id)
+	if err != nil {
+		return err
+	}
+
+	v := struct {
+		*aeu2f.Registration
+		Attestation *attestation `json:",omitempty"`
+	}{Registration: regi}
+	rows := [][]string{
+		{"ID", strconv.FormatInt(regi.ID, 10)},
+		{"User", regi.UserIdentity},
+		{"Name", regi.Name},
+		{"Counter", strconv.FormatInt(regi.Counter, 10)},
+		{"Created", formatTime(regi.Created)},
+		{"Revoked", formatTime(regi.Revoked)},
+		{"Key handle", base64.RawURLEncoding.EncodeToString(regi.KeyHandle)},
+		{"Properties", string(regi.Properties)},
+	}
+
+	// A registration without a readable certificate is still shown.
+	cert, err := regi.AttestationCertificate()
+	if err != nil {
+		rows = append(rows, []string{"Attestation", err.Error()})
+		return out.write(v, rows)
+	}
+	sum := sha256.Sum256(cert.Raw)
+	v.Attestation = &attestation{
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		Serial:    cert.SerialNumber.String(),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		SHA256:    hex.EncodeToString(sum[:]),
+	}
+	rows = append(rows,
+		[]string{"Attestation subject", v.Attestation.Subject},
+		[]string{"Attestation issuer", v.Attestation.Issuer},
+		[]string{"Attestation serial", v.Attestation.Serial},
+		[]string{"Attestation validity", formatTime(cert.NotBefore) + " to " + formatTime(cert.NotAfter)},
+		[]string{"Attestation SHA-256", v.Attestation.SHA256},
+	)
+	return out.write(v, rows)
+}
+
+// --- rename ---
+func rename(ctx context.Context, out *output, args []string) error {
+	id, err := parseID(args[1])
+	if err != nil {
+		return err
+	}
+	regi, err := aeu2f.RenameRegistration(ctx, args[0], id, args[2])
+	if err != nil {
+		return err
+	}
+	return out.write(regi, registrationRows([]*aeu2f.Registration{regi}))
+}
+
+// --- revoke ---
+func revoke(ctx context.Context, out *output, args []string) error {
+	id, err := parseID(args[1])
+	if err != nil {
+		return err
+	}
+	regi, err := aeu2f.RevokeRegistration(ctx, args[0], id)
+	if err != nil {
+		return err
+	}
+	return out.write(regi, registrationRows([]*aeu2f.Registration{regi}))
+}
+
+// --- remove ---
+// The delete command.
+func remove(ctx context.Context, out *output, args []string) error {
+	id, err := parseID(args[1])
+	if err != nil {
+		return err
+	}
+	if err := aeu2f.DeleteRegistration(ctx, args[0], id); err != nil {
+		return err
+	}
+	return out.write(map[string]int64{"Deleted": id},
+		[][]string{{"DELETED"}, {strconv.FormatInt(id, 10)}})
+}
+
+// --- resetCounter ---
+func resetCounter(ctx context.Context, out *output, args []string) error {
+	id, err := parseID(args[1])
+	if err != nil {
+		return err
+	}
+	regi, err := aeu2f.ResetCounter(ctx, args[0], id)
+	if err != nil {
+		return err
+	}
+	return out.write(regi, registrationRows([]*aeu2f.Registration{regi}))
+}
+
+// --- clearLockout ---
+func clearLockout(ctx context.Context, out *output, args []string) error {
+	if err := aeu2f.ClearLockout(ctx, args[0]); err != nil {
+		return err
+	}
+	return out.write(map[string]string{"Cleared": args[0]},
+		[][]string{{"CLEARED"}, {args[0]}})
+}
+
+// --- clearIPLockout ---
+func clearIPLockout(ctx context.Context, out *output, args []string) error {
+	if err := aeu2f.ClearIPLockout(ctx, args[0]); err != nil {
+		return err
+	}
+	return out.write(map[string]string{"Cleared": args[0]},
+		[][]string{{"CLEARED"}, {args[0]}})
+}
+
+// --- purgeChallenges ---
+func purgeChallenges(ctx context.Context, out *output, args []string) error {
+	n, err := aeu2f.PurgeExpiredChallenges(ctx)
+	if err != nil {
+		return err
+	}
+	return out.write(map[string]int{"Purged": n},
+		[][]string{{"PURGED"}, {strconv.Itoa(n)}})
+}
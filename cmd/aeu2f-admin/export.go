@@ -0,0 +1,127 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/brianmhunt/aeu2f-go"
+)
+
+// --- readKey ---
+// Return the export key in the file, in base64.
+func readKey(file string) ([]byte, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("key file %v: %v", file, err)
+	}
+	return key, nil
+}
+
+// --- export ---
+// Write the export to a new file, so that an earlier export is not
+// overwritten, or to the output.
+func export(ctx context.Context, out *output, args []string) (err error) {
+	w := out.w
+	if args[0] != "-" {
+		f, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = f
+	}
+
+	var enc io.WriteCloser
+	if out.opts.keyFile != "" {
+		key, err := readKey(out.opts.keyFile)
+		if err != nil {
+			return err
+		}
+		if enc, err = aeu2f.EncryptExport(w, key); err != nil {
+			return err
+		}
+		w = enc
+	}
+
+	n, err := aeu2f.ExportRegistrations(ctx, w)
+	if err != nil {
+		return err
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return err
+		}
+	}
+	if args[0] == "-" {
+		return nil
+	}
+	return out.write(map[string]int{"Exported": n},
+		[][]string{{"EXPORTED"}, {strconv.Itoa(n)}})
+}
+
+// --- importer ---
+// Return an import command, that imports the file with importFile.  The
+// report is written even if the import stopped part way.
+func importer(importFile func(context.Context, io.Reader) (*aeu2f.ImportReport, error)) func(context.Context, *output, []string) error {
+	return func(ctx context.Context, out *output, args []string) error {
+		var r io.Reader = os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+
+		if out.opts.keyFile != "" {
+			key, err := readKey(out.opts.keyFile)
+			if err != nil {
+				return err
+			}
+			if r, err = aeu2f.DecryptExport(r, key); err != nil {
+				return err
+			}
+		}
+
+		report, err := importFile(ctx, r)
+		if report == nil {
+			return err
+		}
+		rows := [][]string{
+			{"ADDED", "UPDATED", "UNCHANGED", "CONFLICTS", "REJECTED"},
+			{strconv.Itoa(report.Added), strconv.Itoa(report.Updated), strconv.Itoa(report.Unchanged),
+				strconv.Itoa(len(report.Conflicts)), strconv.Itoa(len(report.Rejected))},
+		}
+		if len(report.Conflicts)+len(report.Rejected) > 0 {
+			rows = append(rows, []string{}, []string{"RECORD", "USER", "KEY HANDLE", "PROBLEM"})
+			problems := append(append([]aeu2f.ImportProblem{}, report.Conflicts...), report.Rejected...)
+			for _, p := range problems {
+				rows = append(rows, []string{strconv.Itoa(p.Record), p.UserIdentity, p.KeyHandle, p.Reason})
+			}
+		}
+		if werr := out.write(report, rows); err == nil {
+			err = werr
+		}
+		return err
+	}
+}
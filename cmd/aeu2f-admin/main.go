@@ -0,0 +1,206 @@
+//
+// Command aeu2f-admin manages the registrations of aeu2f users, e.g. to
+// revoke a lost key or clear the lockout of a user who has proved who they
+// are some other way.
+//
+// Usage:
+// 	aeu2f-admin [flags] COMMAND [ARGS]
+//
+// The commands are:
+// 	users                    list the users with a key, recovery code or TOTP
+// 	list USER                list the user's registrations
+// 	show USER ID             show a registration and its attestation
+// 	rename USER ID NAME      set the name of a registration
+// 	revoke USER ID           stop a key signing, but keep its registration
+// 	delete USER ID           delete a registration
+// 	reset-counter USER ID    set the counter of a registration back to zero
+// 	clear-lockout USER       clear the failures and lockout of a user
+// 	clear-ip-lockout IP      clear the failures and lockout of a client IP
+// 	purge-challenges         delete the expired challenges
+// 	export FILE              export every registration, see
+// 	                         aeu2f.ExportRegistrations; - is stdout
+// 	import FILE              import an export, and report what was not
+// 	                         imported; - is stdin
+// 	import-u2flib FILE       import the devices of python-u2flib-server, see
+// 	                         aeu2f.ImportU2FLibDevices
+// 	import-u2fval FILE       import the devices of u2fval, see
+// 	                         aeu2f.ImportU2FValDevices
+//
+// The flags are:
+// 	-storage datastore|memory
+// 		the Store to operate on; memory is empty, for trying commands out
+// 	-host HOST
+// 		with the datastore, which needs it, the App Engine app to reach
+// 		through its remote_api handler, e.g. my-app.appspot.com, with
+// 		the application default credentials
+// 	-namespace NS
+// 		with the datastore, the namespace, i.e. tenant, of the users
+// 	-cache-addr HOST:PORT
+// 		the memcached whose cached registrations to invalidate on a
+// 		change; registrations are still read from the store, never the
+// 		cache.  An LRU cache only sees changes once its entries expire
+// 	-format table|json
+// 		the output format
+// 	-key-file FILE
+// 		the file of the base64 key with which to encrypt an export, or
+// 		decrypt an import, e.g. made with
+// 		head -c 32 /dev/urandom | base64 > export.key
+//
+// Each change is logged to stderr, as the API logs its operations.
+//
+// License: MIT
+//
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/remote_api"
+
+	"github.com/brianmhunt/aeu2f-go"
+)
+
+// remoteScopes are the OAuth scopes remote_api needs.
+var remoteScopes = []string{
+	"https://www.googleapis.com/auth/appengine.apis",
+	"https://www.googleapis.com/auth/userinfo.email",
+	"https://www.googleapis.com/auth/cloud-platform",
+}
+
+// options are the flags.
+type options struct {
+	storage   string
+	host      string
+	namespace string
+	cacheAddr string
+	format    string
+	keyFile   string
+}
+
+// usageError is an error in the command line.
+type usageError struct{ msg string }
+
+func (e *usageError) Error() string { return e.msg }
+
+// --- usagef ---
+func usagef(format string, args ...interface{}) error {
+	return &usageError{fmt.Sprintf(format, args...)}
+}
+
+func main() {
+	aeu2f.Log = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	err := run(context.Background(), os.Args[1:], os.Stdout)
+	var uerr *usageError
+	switch {
+	case errors.As(err, &uerr):
+		fmt.Fprintf(os.Stderr, "aeu2f-admin: %v\nRun aeu2f-admin -h for usage.\n", err)
+		os.Exit(2)
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "aeu2f-admin: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// --- run ---
+// Parse the command line, set up the Store, and run the command.
+func run(ctx context.Context, args []string, w io.Writer) error {
+	opts, cmd, err := parseFlags(args)
+	if err != nil {
+		return err
+	}
+	ctx, err = setup(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return execute(ctx, w, opts, cmd)
+}
+
+// --- parseFlags ---
+// Return the options of args, and the command that follows them.
+func parseFlags(args []string) (*options, []string, error) {
+	opts := &options{}
+	fs := flag.NewFlagSet("aeu2f-admin", flag.ContinueOnError)
+	fs.StringVar(&opts.storage, "storage", "datastore", "the `store`: datastore or memory")
+	fs.StringVar(&opts.host, "host", "", "the App Engine `host` of the datastore, through remote_api")
+	fs.StringVar(&opts.namespace, "namespace", "", "the datastore `namespace` of the users")
+	fs.StringVar(&opts.cacheAddr, "cache-addr", "", "the `host:port` of a memcached of registrations to invalidate")
+	fs.StringVar(&opts.format, "format", "table", "the output `format`: table or json")
+	fs.StringVar(&opts.keyFile, "key-file", "", "the `file` of the base64 key of an encrypted export")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case opts.storage != "datastore" && opts.storage != "memory":
+		return nil, nil, usagef("unknown storage %q", opts.storage)
+	case opts.format != "table" && opts.format != "json":
+		return nil, nil, usagef("unknown format %q", opts.format)
+	case opts.storage == "memory" && (opts.host != "" || opts.namespace != ""):
+		return nil, nil, usagef("-host and -namespace are for the datastore")
+	case opts.storage == "datastore" && opts.host == "":
+		return nil, nil, usagef("-host is needed for the datastore")
+	case fs.NArg() == 0:
+		return nil, nil, usagef("no command")
+	}
+	return opts, fs.Args(), nil
+}
+
+// --- setup ---
+// Set aeu2f.Storage as opts ask, and return the context to use it with.
+func setup(ctx context.Context, opts *options) (context.Context, error) {
+	var store aeu2f.Store = aeu2f.NewMemoryStore()
+	if opts.storage == "datastore" {
+		store = aeu2f.DatastoreStore{}
+		var err error
+		if ctx, err = remoteContext(ctx, opts.host); err != nil {
+			return nil, err
+		}
+		if opts.namespace != "" {
+			if ctx, err = appengine.Namespace(ctx, opts.namespace); err != nil {
+				return nil, fmt.Errorf("appengine.Namespace error: %v", err)
+			}
+		}
+	}
+
+	if opts.cacheAddr != "" {
+		// Reading through the cache could show, and cache, stale
+		// registrations; only the changes go to it.
+		cs := aeu2f.NewCachedStore(store, aeu2f.NewMemcacheCache(opts.cacheAddr, time.Minute))
+		cs.InvalidateOnly = true
+		if opts.storage == "datastore" {
+			// As an application keys its cache of a namespaced datastore.
+			cs.Tenant = func(ctx context.Context) string {
+				return aeu2f.MakeParentKey(ctx).Namespace()
+			}
+		}
+		store = cs
+	}
+	aeu2f.Storage = store
+	return ctx, nil
+}
+
+// --- remoteContext ---
+// Return a context whose App Engine calls go to host's remote_api.
+func remoteContext(ctx context.Context, host string) (context.Context, error) {
+	client, err := google.DefaultClient(ctx, remoteScopes...)
+	if err != nil {
+		return nil, fmt.Errorf("google.DefaultClient error: %v", err)
+	}
+	rctx, err := remote_api.NewRemoteContext(host, client)
+	if err != nil {
+		return nil, fmt.Errorf("remote_api.NewRemoteContext error: %v", err)
+	}
+	return rctx, nil
+}
@@ -0,0 +1,184 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/brianmhunt/aeu2f-go"
+	"github.com/brianmhunt/aeu2f-go/aeu2ftest"
+)
+
+const testAppID = "https://aeu2f.example.com"
+
+// setupRegistration stores a registration of bob, in a new MemoryStore, and
+// returns its ID.
+func setupRegistration(t *testing.T) (context.Context, string) {
+	oldStorage, oldLog, oldAppID, oldFacets := aeu2f.Storage, aeu2f.Log, aeu2f.AppID, aeu2f.TrustedFacets
+	t.Cleanup(func() {
+		aeu2f.Storage, aeu2f.Log, aeu2f.AppID, aeu2f.TrustedFacets = oldStorage, oldLog, oldAppID, oldFacets
+	})
+	aeu2f.Storage = aeu2f.NewMemoryStore()
+	aeu2f.Log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	aeu2f.AppID, aeu2f.TrustedFacets = testAppID, []string{testAppID}
+
+	ctx := context.Background()
+	req, err := aeu2f.NewRegistrationChallenge(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := aeu2ftest.New().Register(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := aeu2f.StoreResponse(ctx, "bob", *resp); err != nil {
+		t.Fatal(err)
+	}
+	regis, err := aeu2f.ListRegistrations(ctx, "bob")
+	if err != nil || len(regis) != 1 {
+		t.Fatalf("Expected a registration, got %v, %v", regis, err)
+	}
+	return ctx, strconv.FormatInt(regis[0].ID, 10)
+}
+
+// admin runs the command, and returns its output.
+func admin(t *testing.T, ctx context.Context, format string, args ...string) string {
+	var b bytes.Buffer
+	if err := execute(ctx, &b, &options{format: format}, args); err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return b.String()
+}
+
+func TestCommands(t *testing.T) {
+	ctx, id := setupRegistration(t)
+
+	if out := admin(t, ctx, "table", "users"); out != "USER\nbob\n" {
+		t.Errorf("users: unexpected output %q", out)
+	}
+
+	var regi aeu2f.Registration
+	out := admin(t, ctx, "json", "rename", "bob", id, "yubikey")
+	if err := json.Unmarshal([]byte(out), &regi); err != nil || regi.Name != "yubikey" {
+		t.Errorf("rename: unexpected output %v, %v", out, err)
+	}
+
+	out = admin(t, ctx, "table", "list", "bob")
+	if !strings.Contains(out, "yubikey") || !strings.HasPrefix(out, "ID ") {
+		t.Errorf("list: unexpected output %q", out)
+	}
+
+	out = admin(t, ctx, "table", "show", "bob", id)
+	if !strings.Contains(out, "CN=aeu2ftest attestation") || !strings.Contains(out, "Attestation SHA-256") {
+		t.Errorf("show: expected the attestation, got %q", out)
+	}
+	var shown struct {
+		ID          int64
+		Attestation struct{ Subject string }
+	}
+	out = admin(t, ctx, "json", "show", "bob", id)
+	if err := json.Unmarshal([]byte(out), &shown); err != nil || strconv.FormatInt(shown.ID, 10) != id ||
+		shown.Attestation.Subject != "CN=aeu2ftest attestation" {
+		t.Errorf("show: unexpected output %v, %v", out, err)
+	}
+
+	out = admin(t, ctx, "json", "revoke", "bob", id)
+	if err := json.Unmarshal([]byte(out), &regi); err != nil || regi.Revoked.IsZero() {
+		t.Errorf("revoke: unexpected output %v, %v", out, err)
+	}
+	admin(t, ctx, "table", "reset-counter", "bob", id)
+	admin(t, ctx, "table", "clear-lockout", "bob")
+	admin(t, ctx, "table", "clear-ip-lockout", "192.0.2.1")
+	if out := admin(t, ctx, "json", "purge-challenges"); !strings.Contains(out, `"Purged": 0`) {
+		t.Errorf("purge-challenges: unexpected output %q", out)
+	}
+
+	admin(t, ctx, "table", "delete", "bob", id)
+	if out := admin(t, ctx, "json", "list", "bob"); strings.TrimSpace(out) != "[]" {
+		t.Errorf("Expected the registration to be deleted, got %q", out)
+	}
+	if err := execute(ctx, io.Discard, &options{format: "table"}, []string{"show", "bob", id}); err != aeu2f.ErrNoSuchRegistration {
+		t.Errorf("Expected a deleted registration not to be found, got %v", err)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	ctx, _ := setupRegistration(t)
+	dir := t.TempDir()
+	keyFile, file := filepath.Join(dir, "export.key"), filepath.Join(dir, "export")
+	os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(make([]byte, aeu2f.ExportKeySize))+"\n"), 0600)
+	opts := &options{format: "json", keyFile: keyFile}
+
+	var b bytes.Buffer
+	if err := execute(ctx, &b, opts, []string{"export", file}); err != nil || !strings.Contains(b.String(), `"Exported": 1`) {
+		t.Fatalf("export: %v, %v", b.String(), err)
+	}
+	if err := execute(ctx, io.Discard, opts, []string{"export", file}); err == nil {
+		t.Error("Expected an export not to overwrite another")
+	}
+
+	aeu2f.Storage = aeu2f.NewMemoryStore()
+	b.Reset()
+	var report aeu2f.ImportReport
+	if err := execute(ctx, &b, opts, []string{"import", file}); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if err := json.Unmarshal(b.Bytes(), &report); err != nil || report.Added != 1 {
+		t.Errorf("import: unexpected output %v, %v", b.String(), err)
+	}
+	if out := admin(t, ctx, "table", "users"); out != "USER\nbob\n" {
+		t.Errorf("Expected bob to be imported, got %q", out)
+	}
+
+	// Without the key, the encrypted export is not an export.
+	if err := execute(ctx, io.Discard, &options{format: "table"}, []string{"import", file}); err == nil {
+		t.Error("Expected the encrypted export to need its key")
+	}
+}
+
+func TestUsage(t *testing.T) {
+	ctx, _ := setupRegistration(t)
+
+	for _, args := range [][]string{
+		{"-format", "xml", "users"},
+		{"-storage", "disk", "users"},
+		{"users"},
+		{"-storage", "memory", "-namespace", "acme", "users"},
+		{},
+		{"-storage", "memory", "frobnicate"},
+		{"-storage", "memory", "list"},
+		{"-storage", "memory", "revoke", "bob", "one"},
+	} {
+		err := run(ctx, args, io.Discard)
+		var uerr *usageError
+		if !errors.As(err, &uerr) {
+			t.Errorf("%v: expected a usage error, got %v", args, err)
+		}
+	}
+}
+
+func TestImportU2FVal(t *testing.T) {
+	ctx, _ := setupRegistration(t)
+	file := filepath.Join(t.TempDir(), "devices.json")
+	os.WriteFile(file, []byte(`[{"user": "alice", "bind_data": "{\"appId\": \"`+testAppID+`\"}"}]`), 0600)
+
+	out := admin(t, ctx, "table", "import-u2fval", file)
+	if !strings.Contains(out, "alice") || !strings.Contains(out, "keyHandle: missing") {
+		t.Errorf("Expected the device to be rejected, got %q", out)
+	}
+}
@@ -0,0 +1,98 @@
+//
+// Command aeu2f-auditverify checks an exported aeu2f audit chain (see
+// aeu2f.ChainedAudit.Export) and reports the first broken link.
+//
+// Usage:
+// 	aeu2f-auditverify -key ID=BASE64 [-key ID=BASE64 ...] [-every N] [-max-age D] [chain.json]
+//
+// where each -key is the ID and (standard base64) Ed25519 public key of a
+// checkpoint signing key, and -every is the ChainedAudit's CheckpointEvery.
+// -max-age is how old the chain's latest event may be, e.g. 24h; without it,
+// deleting the latest events is not detected, since an older signed head can
+// be put back with them.  The chain is read from stdin if no file is given.
+// The exit status is 1 if the chain is broken.
+//
+// License: MIT
+//
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/brianmhunt/aeu2f-go"
+)
+
+// keyFlags collects the repeated -key flags.
+type keyFlags map[string]ed25519.PublicKey
+
+func (k keyFlags) String() string {
+	ids := []string{}
+	for id := range k {
+		ids = append(ids, id)
+	}
+	return strings.Join(ids, ",")
+}
+
+func (k keyFlags) Set(v string) error {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected ID=BASE64, got %q", v)
+	}
+
+	pub, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	if len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("key %q is %v bytes, expected %v", parts[0], len(pub), ed25519.PublicKeySize)
+	}
+
+	k[parts[0]] = ed25519.PublicKey(pub)
+	return nil
+}
+
+func main() {
+	keys := keyFlags{}
+	flag.Var(keys, "key", "checkpoint `ID=BASE64` public key; may be repeated")
+	every := flag.Int64("every", aeu2f.DefaultCheckpointEvery, "the most `events` between checkpoints")
+	maxAge := flag.Duration("max-age", 0, "the oldest the latest event may be, or 0 for any")
+	flag.Parse()
+
+	var notBefore time.Time
+	if *maxAge > 0 {
+		notBefore = time.Now().Add(-*maxAge)
+	}
+
+	var in io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var x aeu2f.AuditChainExport
+	if err := json.NewDecoder(in).Decode(&x); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid chain: %v\n", err)
+		os.Exit(2)
+	}
+
+	if err := aeu2f.VerifyAuditChain(&x, keys, *every, notBefore); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("OK: tenant %q, %v events, %v checkpoints\n",
+		x.Tenant, len(x.Events), len(x.Checkpoints))
+}
@@ -0,0 +1,251 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brianmhunt/aeu2f-go/aeu2fval"
+)
+
+// Config configures aeu2fd.  Each field is set by its JSON key in the
+// configuration file, or by its environment variable.
+type Config struct {
+	// Addr is the host:port to serve HTTPS on.
+	Addr string `json:"addr" env:"AEU2FD_ADDR"`
+
+	// AppID is the U2F application ID, i.e. the origin users see, e.g.
+	// https://u2f.example.com.  It defaults to https://localhost with the
+	// port of Addr.
+	AppID string `json:"app_id" env:"AEU2FD_APP_ID"`
+
+	// TrustedFacets defaults to AppID alone.
+	TrustedFacets []string `json:"trusted_facets" env:"AEU2FD_TRUSTED_FACETS"`
+
+	// TLSCert and TLSKey are PEM files of the certificate and its key.
+	TLSCert string `json:"tls_cert" env:"AEU2FD_TLS_CERT"`
+	TLSKey  string `json:"tls_key" env:"AEU2FD_TLS_KEY"`
+
+	// SelfSigned generates a self-signed certificate for the host of AppID,
+	// for development.  It is written to TLSCert and TLSKey, if set and
+	// missing, so that browsers can keep an exception for it.
+	SelfSigned bool `json:"self_signed" env:"AEU2FD_SELF_SIGNED"`
+
+	// Storage is the backend.  Only "memory" is supported, so registrations
+	// are lost on restart.  "datastore" is refused: the datastore API needs
+	// the App Engine runtime, which aeu2fd does not run in; deploy
+	// aeu2f-demo to App Engine for that.
+	Storage string `json:"storage" env:"AEU2FD_STORAGE"`
+
+	// Cache, if set, caches registrations in front of Storage: "lru" holds
+	// up to CacheSize users in memory, and "memcache" uses the memcached
+	// server at CacheAddr.  Either holds them for up to CacheTTL.
+	Cache     string   `json:"cache" env:"AEU2FD_CACHE"`
+	CacheAddr string   `json:"cache_addr" env:"AEU2FD_CACHE_ADDR"`
+	CacheSize int      `json:"cache_size" env:"AEU2FD_CACHE_SIZE"`
+	CacheTTL  Duration `json:"cache_ttl" env:"AEU2FD_CACHE_TTL"`
+
+	// Static is the directory of the demo page, served at /; its .html,
+	// .js and .css files are served.  Empty serves no page.
+	Static string `json:"static" env:"AEU2FD_STATIC"`
+
+	// MetricsAddr, if set, is the host:port to serve Prometheus metrics on,
+	// over plain HTTP at /metrics.  It should not be public.
+	MetricsAddr string `json:"metrics_addr" env:"AEU2FD_METRICS_ADDR"`
+
+	// U2FValClients, if any, are served the u2fval-compatible API at
+	// /u2fval/; see package aeu2fval.  Each client's AppID defaults to
+	// AppID.  They are only read from the file.
+	U2FValClients []aeu2fval.Client `json:"u2fval_clients"`
+
+	// U2FValAuthHeader, if set, is the header in which a proxy in front of
+	// aeu2fd gives the name of the u2fval client it has authenticated, e.g.
+	// X-Remote-User.  Otherwise the clients authenticate with HTTP basic
+	// authentication, by their name and secret.
+	U2FValAuthHeader string `json:"u2fval_auth_header" env:"AEU2FD_U2FVAL_AUTH_HEADER"`
+}
+
+// Duration is a time.Duration written as e.g. "1m30s".
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// DefaultConfig returns the configuration before the file and environment.
+func DefaultConfig() *Config {
+	return &Config{
+		Addr:      ":8443",
+		Storage:   "memory",
+		CacheSize: 10000,
+		CacheTTL:  Duration{time.Minute},
+	}
+}
+
+// LoadConfig returns the default configuration, updated from the JSON file
+// if given, and then from the environment.
+func LoadConfig(file string) (*Config, error) {
+	cfg := DefaultConfig()
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("%v: %v", file, err)
+		}
+	}
+
+	if err := cfg.setFromEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := cfg.complete(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// --- setFromEnv ---
+// Set each field whose environment variable is set.  Lists are separated by
+// commas.
+func (cfg *Config) setFromEnv(lookup func(string) (string, bool)) error {
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Tag.Get("env")
+		s, ok := lookup(name)
+		if name == "" || !ok {
+			continue
+		}
+
+		f := v.Field(i)
+		var err error
+		if u, ok := f.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			err = u.UnmarshalText([]byte(s))
+		} else {
+			switch f.Kind() {
+			case reflect.String:
+				f.SetString(s)
+			case reflect.Bool:
+				var b bool
+				b, err = strconv.ParseBool(s)
+				f.SetBool(b)
+			case reflect.Int:
+				var n int64
+				n, err = strconv.ParseInt(s, 10, 0)
+				f.SetInt(n)
+			case reflect.Slice:
+				f.Set(reflect.ValueOf(strings.Split(s, ",")))
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// --- complete ---
+// Fill in the defaults that depend on other fields, and check the result.
+func (cfg *Config) complete() error {
+	if cfg.AppID == "" {
+		_, port, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			return fmt.Errorf("addr: %v", err)
+		}
+		cfg.AppID = "https://localhost"
+		if port != "443" {
+			cfg.AppID += ":" + port
+		}
+	}
+	if !isOrigin(cfg.AppID) {
+		return fmt.Errorf("app_id: expected an https origin, got %q", cfg.AppID)
+	}
+	cfg.AppID = strings.TrimSuffix(cfg.AppID, "/")
+	if len(cfg.TrustedFacets) == 0 {
+		cfg.TrustedFacets = []string{cfg.AppID}
+	}
+
+	names := map[string]bool{}
+	for i := range cfg.U2FValClients {
+		c := &cfg.U2FValClients[i]
+		switch {
+		case c.Name == "" || strings.Contains(c.Name, "/"):
+			return fmt.Errorf("u2fval_clients: invalid name %q", c.Name)
+		case names[c.Name]:
+			return fmt.Errorf("u2fval_clients: %q is repeated", c.Name)
+		case c.Secret == "" && cfg.U2FValAuthHeader == "":
+			return fmt.Errorf("u2fval_clients: %q needs a secret, unless u2fval_auth_header", c.Name)
+		}
+		names[c.Name] = true
+		if c.AppID == "" {
+			c.AppID = cfg.AppID
+		}
+		if !isOrigin(c.AppID) {
+			return fmt.Errorf("u2fval_clients: %q: expected an https origin, got %q", c.Name, c.AppID)
+		}
+		c.AppID = strings.TrimSuffix(c.AppID, "/")
+	}
+
+	if !cfg.SelfSigned && (cfg.TLSCert == "" || cfg.TLSKey == "") {
+		return fmt.Errorf("tls_cert and tls_key are needed, unless self_signed")
+	}
+
+	switch cfg.Storage {
+	case "memory":
+	case "datastore":
+		return fmt.Errorf("storage: datastore needs the App Engine runtime, which aeu2fd does not run in; use memory, or deploy aeu2f-demo to App Engine")
+	default:
+		return fmt.Errorf("storage: unknown backend %q", cfg.Storage)
+	}
+	switch cfg.Cache {
+	case "":
+	case "lru":
+		if cfg.CacheSize <= 0 {
+			return fmt.Errorf("cache_size: must be positive")
+		}
+	case "memcache":
+		if cfg.CacheAddr == "" {
+			return fmt.Errorf("cache_addr: needed for the memcache cache")
+		}
+	default:
+		return fmt.Errorf("cache: unknown cache %q", cfg.Cache)
+	}
+	return nil
+}
+
+// --- isOrigin ---
+// Whether s is an https origin, as an AppID must be.
+func isOrigin(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme == "https" && u.Host != "" && strings.TrimPrefix(u.Path, "/") == ""
+}
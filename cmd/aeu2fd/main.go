@@ -0,0 +1,116 @@
+//
+// Command aeu2fd is a standalone U2F server, not tied to App Engine.  It
+// serves the aeu2fhttp register, auth, list, delete and stepup API, and the
+// demo page, over TLS.  With u2fval_clients configured, it also serves the
+// u2fval-compatible API of aeu2fval to them at /u2fval/.
+//
+// Usage:
+// 	aeu2fd [-config aeu2fd.json]
+//
+// The configuration is read from the JSON file, if given, and then from
+// AEU2FD_* environment variables, which take precedence; see Config.  For
+// development,
+// 	AEU2FD_SELF_SIGNED=true aeu2fd
+// serves https://localhost:8443 with a generated self-signed certificate,
+// and keeps registrations in memory.  aeu2fd does not run on App Engine, so
+// it cannot use the datastore.
+//
+// A step-up, POSTed to /stepup/USER, is kept in a cookie bound to the
+// browser's aeu2fd-session cookie, which aeu2fd sets.
+//
+// As in aeu2f-demo, the user is taken from the path, e.g. /auth/USER, so
+// anyone can register or delete keys of any user: put aeu2fd behind
+// something that authenticates users before exposing it.
+//
+// License: MIT
+//
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/brianmhunt/aeu2f-go"
+)
+
+// shutdownTimeout bounds how long in-flight requests have to finish.
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	configFile := flag.String("config", "", "JSON configuration `file`")
+	flag.Parse()
+
+	aeu2f.Log = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+
+	cfg, err := LoadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "aeu2fd: %v\n", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "aeu2fd: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// --- run ---
+// Serve until ctx is done, then shut down gracefully.
+func run(ctx context.Context, cfg *Config) error {
+	h := NewServer(cfg)
+	cert, err := loadCertificate(cfg)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           h,
+		TLSConfig:         &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	servers := []*http.Server{srv}
+	errc := make(chan error, 2)
+	go func() { errc <- srv.ListenAndServeTLS("", "") }()
+
+	if cfg.MetricsAddr != "" {
+		msrv := &http.Server{
+			Addr:              cfg.MetricsAddr,
+			Handler:           metricsHandler(cfg),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		servers = append(servers, msrv)
+		go func() { errc <- msrv.ListenAndServe() }()
+	}
+
+	aeu2f.Log.Info("aeu2fd.start", "op", "aeu2fd.start", "addr", cfg.Addr, "app_id", cfg.AppID, "storage", cfg.Storage)
+
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+	}
+
+	sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, s := range servers {
+		s.Shutdown(sctx)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
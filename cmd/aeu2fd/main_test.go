@@ -0,0 +1,268 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brianmhunt/aeu2f-go"
+	"github.com/brianmhunt/aeu2f-go/aeu2ftest"
+	"github.com/brianmhunt/aeu2f-go/aeu2fval"
+	"github.com/tstranex/u2f"
+)
+
+func TestLoadConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "aeu2fd.json")
+	err := os.WriteFile(file, []byte(`{
+		"addr": ":9443",
+		"self_signed": true,
+		"cache": "lru",
+		"cache_ttl": "30s",
+		"static": "demo"
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The environment takes precedence over the file.
+	t.Setenv("AEU2FD_STATIC", "aeu2f-demo")
+	t.Setenv("AEU2FD_CACHE_SIZE", "5")
+	t.Setenv("AEU2FD_TRUSTED_FACETS", "https://a.example.com,https://b.example.com")
+
+	cfg, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.AppID != "https://localhost:9443" || cfg.Static != "aeu2f-demo" || cfg.Storage != "memory" ||
+		cfg.CacheSize != 5 || cfg.CacheTTL.Duration != 30*time.Second || len(cfg.TrustedFacets) != 2 {
+		t.Errorf("Unexpected configuration %+v", cfg)
+	}
+
+	for name, env := range map[string]string{
+		"no certificate":  "AEU2FD_SELF_SIGNED=false",
+		"unknown storage": "AEU2FD_STORAGE=disk",
+		"datastore":       "AEU2FD_STORAGE=datastore",
+		"plain app ID":    "AEU2FD_APP_ID=http://localhost",
+		"bad boolean":     "AEU2FD_SELF_SIGNED=maybe",
+		"bad duration":    "AEU2FD_CACHE_TTL=soon",
+	} {
+		t.Run(name, func(t *testing.T) {
+			k, v, _ := strings.Cut(env, "=")
+			t.Setenv(k, v)
+			if _, err := LoadConfig(file); err == nil {
+				t.Errorf("Expected %v to be refused", env)
+			}
+		})
+	}
+}
+
+func TestU2FValConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SelfSigned = true
+	cfg.U2FValClients = []aeu2fval.Client{
+		{Name: "app", Secret: "secret"},
+		{Name: "other", AppID: "https://other.example.com/", Secret: "secret"},
+	}
+	if err := cfg.complete(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.U2FValClients[0].AppID != cfg.AppID || cfg.U2FValClients[1].AppID != "https://other.example.com" {
+		t.Errorf("Unexpected clients %+v", cfg.U2FValClients)
+	}
+
+	for name, c := range map[string]aeu2fval.Client{
+		"no name":     {Secret: "secret"},
+		"slash":       {Name: "a/b", Secret: "secret"},
+		"repeated":    {Name: "app", Secret: "secret"},
+		"no secret":   {Name: "new"},
+		"plain AppID": {Name: "new", AppID: "http://example.com", Secret: "secret"},
+	} {
+		cfg := DefaultConfig()
+		cfg.SelfSigned = true
+		cfg.U2FValClients = []aeu2fval.Client{{Name: "app", Secret: "secret"}, c}
+		if err := cfg.complete(); err == nil {
+			t.Errorf("%v: expected the client to be refused", name)
+		}
+	}
+
+	// Behind a proxy, clients need no secret.
+	cfg = DefaultConfig()
+	cfg.SelfSigned, cfg.U2FValAuthHeader = true, "X-Remote-User"
+	cfg.U2FValClients = []aeu2fval.Client{{Name: "app"}}
+	if err := cfg.complete(); err != nil {
+		t.Errorf("Expected a client without secret behind a proxy, got %v", err)
+	}
+}
+
+func TestSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{
+		AppID:      "https://u2f.example.com",
+		SelfSigned: true,
+		TLSCert:    filepath.Join(dir, "cert.pem"),
+		TLSKey:     filepath.Join(dir, "key.pem"),
+	}
+
+	cert, err := loadCertificate(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"u2f.example.com", "localhost", "127.0.0.1"} {
+		if err := leaf.VerifyHostname(host); err != nil {
+			t.Errorf("Expected the certificate to be for %v: %v", host, err)
+		}
+	}
+	if fi, err := os.Stat(cfg.TLSKey); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Expected the key to be written privately, got %v, %v", fi, err)
+	}
+
+	// The written certificate is reused.
+	again, err := loadCertificate(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Certificate[0], cert.Certificate[0]) {
+		t.Error("Expected the written certificate to be reused")
+	}
+}
+
+func TestServer(t *testing.T) {
+	oldStorage, oldAppID, oldFacets := aeu2f.Storage, aeu2f.AppID, aeu2f.TrustedFacets
+	defer func() { aeu2f.Storage, aeu2f.AppID, aeu2f.TrustedFacets = oldStorage, oldAppID, oldFacets }()
+
+	static := t.TempDir()
+	os.WriteFile(filepath.Join(static, "index.html"), []byte("demo"), 0644)
+	os.WriteFile(filepath.Join(static, "app.yaml"), []byte("secret"), 0644)
+
+	cfg := DefaultConfig()
+	cfg.SelfSigned, cfg.Static = true, static
+	cfg.U2FValClients = []aeu2fval.Client{{Name: "app", Secret: "secret"}}
+	if err := cfg.complete(); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := loadCertificate(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(NewServer(cfg))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	defer srv.Close()
+	client := srv.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify = true
+
+	// call makes a request, and decodes the JSON response into v.
+	call := func(method, path string, body, v interface{}) int {
+		var b bytes.Buffer
+		json.NewEncoder(&b).Encode(body)
+		r, _ := http.NewRequest(method, srv.URL+path, &b)
+		resp, err := client.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			json.NewDecoder(resp.Body).Decode(v)
+		}
+		return resp.StatusCode
+	}
+
+	a := aeu2ftest.New()
+	var regReq u2f.RegisterRequest
+	if code := call("GET", "/register/bob", nil, &regReq); code != http.StatusOK {
+		t.Fatalf("GET /register/bob: %v", code)
+	}
+	regResp, err := a.Register(&regReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := call("POST", "/register/bob", regResp, nil); code != http.StatusOK {
+		t.Fatalf("POST /register/bob: %v", code)
+	}
+
+	var signReqs []*u2f.SignRequest
+	if code := call("GET", "/auth/bob", nil, &signReqs); code != http.StatusOK {
+		t.Fatalf("GET /auth/bob: %v", code)
+	}
+	signResp, err := a.SignAny(signReqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := call("POST", "/auth/bob", signResp, nil); code != http.StatusOK {
+		t.Fatalf("POST /auth/bob: %v", code)
+	}
+
+	// A step-up is set in a cookie of the session aeu2fd starts.
+	client.Jar, _ = cookiejar.New(nil)
+	if code := call("GET", "/auth/bob", nil, &signReqs); code != http.StatusOK {
+		t.Fatalf("GET /auth/bob: %v", code)
+	}
+	if signResp, err = a.SignAny(signReqs); err != nil {
+		t.Fatal(err)
+	}
+	if code := call("POST", "/stepup/bob", signResp, nil); code != http.StatusOK {
+		t.Fatalf("POST /stepup/bob: %v", code)
+	}
+	u, _ := url.Parse(srv.URL)
+	names := map[string]bool{}
+	for _, c := range client.Jar.Cookies(u) {
+		names[c.Name] = true
+	}
+	if !names["aeu2fd-session"] || !names["aeu2f-stepup"] {
+		t.Errorf("Expected session and step-up cookies, got %v", names)
+	}
+
+	var regis []*aeu2f.Registration
+	if code := call("GET", "/list/bob", nil, &regis); code != http.StatusOK || len(regis) != 1 {
+		t.Errorf("GET /list/bob: %v, %v registrations", code, len(regis))
+	}
+
+	if code := call("GET", "/", nil, nil); code != http.StatusOK {
+		t.Errorf("GET /: %v", code)
+	}
+	var doc struct{ OpenAPI string }
+	if code := call("GET", "/openapi.json", nil, &doc); code != http.StatusOK || doc.OpenAPI == "" {
+		t.Errorf("GET /openapi.json: %v, %+v", code, doc)
+	}
+
+	// The u2fval API lists bob of the client app, not the bob above.
+	r, _ := http.NewRequest("GET", srv.URL+"/u2fval/bob/", nil)
+	r.SetBasicAuth("app", "secret")
+	resp, err := client.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var devices []aeu2fval.Device
+	json.NewDecoder(resp.Body).Decode(&devices)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || devices == nil || len(devices) != 0 {
+		t.Errorf("GET /u2fval/bob/: %v, %+v", resp.StatusCode, devices)
+	}
+	if code := call("GET", "/u2fval/", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected GET /u2fval/ without a client to be refused, got %v", code)
+	}
+	if code := call("GET", "/app.yaml", nil, nil); code != http.StatusNotFound {
+		t.Errorf("Expected GET /app.yaml to be refused, got %v", code)
+	}
+}
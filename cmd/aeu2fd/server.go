@@ -0,0 +1,154 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"path"
+
+	"github.com/brianmhunt/aeu2f-go"
+	"github.com/brianmhunt/aeu2f-go/aeu2fhttp"
+	"github.com/brianmhunt/aeu2f-go/aeu2fval"
+)
+
+// staticTypes are the extensions of the files served from Config.Static, so
+// that e.g. the demo's app.yaml and Go source are not.
+var staticTypes = map[string]bool{".html": true, ".js": true, ".css": true}
+
+// NewServer configures the aeu2f package from cfg, and returns the handler
+// of the API and demo page.
+func NewServer(cfg *Config) http.Handler {
+	aeu2f.AppID = cfg.AppID
+	aeu2f.TrustedFacets = cfg.TrustedFacets
+	aeu2f.Storage = newStore(cfg)
+
+	api := aeu2fhttp.New(aeu2fhttp.PathIdentity)
+	api.Context = requestContext(cfg)
+	api.Sessions = &aeu2fhttp.CookieSessions{Key: newCookieKey(), Session: requestSession}
+
+	mux := http.NewServeMux()
+	for _, prefix := range []string{"/register/", "/auth/", "/list/", "/delete/", "/openapi.json"} {
+		mux.Handle(prefix, api)
+	}
+	mux.Handle("/stepup/", withSession(api))
+	if len(cfg.U2FValClients) > 0 {
+		mux.Handle("/u2fval/", http.StripPrefix("/u2fval", u2fvalHandler(cfg)))
+	}
+	if cfg.Static != "" {
+		mux.Handle("/", staticHandler(cfg.Static))
+	}
+	return mux
+}
+
+// --- u2fvalHandler ---
+// Return the handler of the u2fval API, for the clients of cfg.
+func u2fvalHandler(cfg *Config) http.Handler {
+	auth := aeu2fval.BasicAuth(cfg.U2FValClients...)
+	if cfg.U2FValAuthHeader != "" {
+		auth = aeu2fval.ProxyAuth(cfg.U2FValAuthHeader, cfg.U2FValClients...)
+	}
+	h := aeu2fval.New(auth)
+	h.Context = requestContext(cfg)
+	return h
+}
+
+// --- newStore ---
+// Return the Store of cfg, with its cache if any.
+func newStore(cfg *Config) aeu2f.Store {
+	store := aeu2f.NewMemoryStore()
+
+	var cache aeu2f.RegistrationCache
+	switch cfg.Cache {
+	case "":
+		return store
+	case "lru":
+		cache = aeu2f.NewLRUCache(cfg.CacheSize, cfg.CacheTTL.Duration)
+	case "memcache":
+		cache = aeu2f.NewMemcacheCache(cfg.CacheAddr, cfg.CacheTTL.Duration)
+	}
+
+	return aeu2f.NewCachedStore(store, cache)
+}
+
+// --- requestContext ---
+// Return the function giving the context of a request: the memory store
+// needs no App Engine context.
+func requestContext(cfg *Config) func(r *http.Request) context.Context {
+	return func(r *http.Request) context.Context { return r.Context() }
+}
+
+// sessionCookie identifies a browser's session, to which its step-ups are
+// bound.
+const sessionCookie = "aeu2fd-session"
+
+// --- newCookieKey ---
+// Return a random key for the step-up cookies.  It is not kept, so step-ups,
+// like registrations, do not outlive the process.
+func newCookieKey() []byte {
+	key := make([]byte, aeu2fhttp.MinCookieKeyBytes)
+	rand.Read(key)
+	return key
+}
+
+// --- withSession ---
+// Give a request without a session cookie a new random one, both in the
+// response and for next.
+func withSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie(sessionCookie); err != nil {
+			id := make([]byte, 16)
+			rand.Read(id)
+			c := &http.Cookie{
+				Name:     sessionCookie,
+				Value:    base64.RawURLEncoding.EncodeToString(id),
+				Path:     "/",
+				Secure:   true,
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			}
+			http.SetCookie(w, c)
+			r = r.Clone(r.Context())
+			r.AddCookie(c)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// --- requestSession ---
+// Return the ID of the request's session, from its session cookie.
+func requestSession(r *http.Request) (string, error) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", aeu2fhttp.ErrNoSession
+	}
+	return c.Value, nil
+}
+
+// --- metricsHandler ---
+//
+func metricsHandler(cfg *Config) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", &aeu2f.MetricsHandler{Context: requestContext(cfg)})
+	return mux
+}
+
+// --- staticHandler ---
+// Serve the demo page, i.e. index.html at /, and the other files of
+// staticTypes in dir.
+func staticHandler(dir string) http.Handler {
+	files := http.FileServer(http.Dir(dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" && !staticTypes[path.Ext(r.URL.Path)] {
+			http.NotFound(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,386 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+
+	"github.com/tstranex/u2f"
+)
+
+// DatastoreStore is a Store in the App Engine datastore.  Every entity is a
+// child of MakeParentKey, so that queries are strongly consistent; this
+// limits writes to about one per second across all users.  Tenants are
+// separated by datastore namespace.
+type DatastoreStore struct{}
+
+// --- idKey ---
+// Return the key of the entity of the given kind and ID.
+func idKey(ctx context.Context, kind string, id int64) *datastore.Key {
+	return datastore.NewKey(ctx, kind, "", id, MakeParentKey(ctx))
+}
+
+// --- userQuery ---
+// Return the query for the user's entities of the given kind.
+func userQuery(ctx context.Context, kind, userIdentity string) *datastore.Query {
+	return datastore.NewQuery(kind).
+		Ancestor(MakeParentKey(ctx)).
+		Filter("UserIdentity =", userIdentity)
+}
+
+// --- deleteOwned ---
+// Delete the user's entity at k, or return ErrNoSuchRegistration.
+func deleteOwned(ctx context.Context, k *datastore.Key, userIdentity string, dst interface{ owner() string }) error {
+	return datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		if err := datastore.Get(tc, k, dst); err == datastore.ErrNoSuchEntity {
+			return ErrNoSuchRegistration
+		} else if err != nil {
+			return fmt.Errorf("datastore.Get error: %v", err)
+		}
+
+		if dst.owner() != userIdentity {
+			return ErrNoSuchRegistration
+		}
+
+		if err := datastore.Delete(tc, k); err != nil {
+			return fmt.Errorf("datastore.Delete error: %v", err)
+		}
+		return nil
+	}, nil)
+}
+
+func (r *Registration) owner() string  { return r.UserIdentity }
+func (rc *RecoveryCode) owner() string { return rc.UserIdentity }
+func (t *TOTP) owner() string          { return t.UserIdentity }
+
+// PutChallenge implements Store.
+func (DatastoreStore) PutChallenge(ctx context.Context, kind, userIdentity string, c *u2f.Challenge) error {
+	if _, err := datastore.Put(ctx, makeKey(ctx, userIdentity, kind), c); err != nil {
+		return fmt.Errorf("datastore.Put error: %v", err)
+	}
+	return nil
+}
+
+// GetChallenge implements Store.
+func (DatastoreStore) GetChallenge(ctx context.Context, kind, userIdentity string) (*u2f.Challenge, error) {
+	var c u2f.Challenge
+	if err := datastore.Get(ctx, makeKey(ctx, userIdentity, kind), &c); err == datastore.ErrNoSuchEntity {
+		return nil, ErrNoChallenge
+	} else if err != nil {
+		return nil, fmt.Errorf("datastore.Get error: %v", err)
+	}
+	return &c, nil
+}
+
+// DeleteChallenge implements Store.
+func (DatastoreStore) DeleteChallenge(ctx context.Context, kind, userIdentity string) error {
+	if err := datastore.Delete(ctx, makeKey(ctx, userIdentity, kind)); err != nil && err != datastore.ErrNoSuchEntity {
+		return fmt.Errorf("datastore.Delete error: %v", err)
+	}
+	return nil
+}
+
+// CountChallenges implements Store.  The query is not by ancestor, so that
+// it needs no composite index, and it is eventually consistent.
+func (DatastoreStore) CountChallenges(ctx context.Context, kind string, since time.Time) (int, error) {
+	n, err := datastore.NewQuery(kind).Filter("Timestamp >", since).Count(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("datastore Count error: %v", err)
+	}
+	return n, nil
+}
+
+// DeleteChallenges implements Store.  Like CountChallenges, the query is not
+// by ancestor, so it may miss challenges issued just before before.
+func (DatastoreStore) DeleteChallenges(ctx context.Context, kind string, before time.Time) (int, error) {
+	keys, err := datastore.NewQuery(kind).Filter("Timestamp <", before).KeysOnly().GetAll(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("datastore GetAll error: %+v", err)
+	}
+
+	// The datastore deletes at most 500 entities per call.
+	for i := 0; i < len(keys); i += 500 {
+		j := i + 500
+		if j > len(keys) {
+			j = len(keys)
+		}
+		if err := datastore.DeleteMulti(ctx, keys[i:j]); err != nil {
+			return i, fmt.Errorf("datastore.DeleteMulti error: %v", err)
+		}
+	}
+	return len(keys), nil
+}
+
+// PutRegistration implements Store.
+func (DatastoreStore) PutRegistration(ctx context.Context, regi *Registration) (int64, error) {
+	// We set the stringKey to "", because the user identity is not part of
+	// the key.  We look up registrations by a datastore query, since there
+	// might be multiple.
+	k, err := datastore.Put(ctx, makeKey(ctx, "", "Registration"), regi)
+	if err != nil {
+		return 0, fmt.Errorf("datastore.Put error: %v", err)
+	}
+	return k.IntID(), nil
+}
+
+// Registrations implements Store.
+func (DatastoreStore) Registrations(ctx context.Context, userIdentity string) ([]*Registration, error) {
+	regis := []*Registration{}
+	keys, err := userQuery(ctx, "Registration", userIdentity).GetAll(ctx, &regis)
+	if err != nil {
+		return nil, fmt.Errorf("datastore GetAll error: %+v", err)
+	}
+	for idx, regi := range regis {
+		regi.ID = keys[idx].IntID()
+	}
+	return regis, nil
+}
+
+// CompareAndSwapCounter implements Store.
+func (DatastoreStore) CompareAndSwapCounter(ctx context.Context, id, old, counter int64) (*Registration, bool, error) {
+	k := idKey(ctx, "Registration", id)
+	var regi Registration
+	var ok bool
+	err := datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		regi, ok = Registration{}, false
+		if err := datastore.Get(tc, k, &regi); err == datastore.ErrNoSuchEntity {
+			return ErrNoSuchRegistration
+		} else if err != nil {
+			return fmt.Errorf("datastore.Get error: %v", err)
+		}
+
+		if !regi.Revoked.IsZero() {
+			return ErrRevoked
+		}
+		if regi.Counter != old {
+			return nil
+		}
+
+		regi.Counter = counter
+		if _, err := datastore.Put(tc, k, &regi); err != nil {
+			return fmt.Errorf("datastore.Put error: %v", err)
+		}
+		ok = true
+		return nil
+	}, &datastore.TransactionOptions{Attempts: counterAttempts})
+	if err == ErrRevoked {
+		regi.ID = id
+		return &regi, false, err
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	regi.ID = id
+	return &regi, ok, nil
+}
+
+// UpdateRegistration implements Store.
+func (DatastoreStore) UpdateRegistration(ctx context.Context, userIdentity string, id int64, update func(*Registration) error) (*Registration, error) {
+	k := idKey(ctx, "Registration", id)
+	var regi Registration
+	err := datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		regi = Registration{}
+		if err := datastore.Get(tc, k, &regi); err == datastore.ErrNoSuchEntity {
+			return ErrNoSuchRegistration
+		} else if err != nil {
+			return fmt.Errorf("datastore.Get error: %v", err)
+		}
+
+		if regi.UserIdentity != userIdentity {
+			return ErrNoSuchRegistration
+		}
+		if err := update(&regi); err != nil {
+			return err
+		}
+
+		if _, err := datastore.Put(tc, k, &regi); err != nil {
+			return fmt.Errorf("datastore.Put error: %v", err)
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	regi.ID = id
+	return &regi, nil
+}
+
+// DeleteRegistration implements Store.
+func (DatastoreStore) DeleteRegistration(ctx context.Context, userIdentity string, id int64) error {
+	return deleteOwned(ctx, idKey(ctx, "Registration", id), userIdentity, &Registration{})
+}
+
+// ReplaceRecoveryCodes implements Store.
+func (DatastoreStore) ReplaceRecoveryCodes(ctx context.Context, userIdentity string, rcs []*RecoveryCode) error {
+	keys := make([]*datastore.Key, len(rcs))
+	for i := range keys {
+		keys[i] = makeKey(ctx, "", "RecoveryCode")
+	}
+
+	return datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		old, err := userQuery(tc, "RecoveryCode", userIdentity).KeysOnly().GetAll(tc, nil)
+		if err != nil {
+			return fmt.Errorf("datastore GetAll error: %+v", err)
+		}
+		if err := datastore.DeleteMulti(tc, old); err != nil {
+			return fmt.Errorf("datastore.DeleteMulti error: %v", err)
+		}
+		if _, err := datastore.PutMulti(tc, keys, rcs); err != nil {
+			return fmt.Errorf("datastore.PutMulti error: %v", err)
+		}
+		return nil
+	}, nil)
+}
+
+// RecoveryCodes implements Store.
+func (DatastoreStore) RecoveryCodes(ctx context.Context, userIdentity string) ([]*RecoveryCode, error) {
+	rcs := []*RecoveryCode{}
+	keys, err := userQuery(ctx, "RecoveryCode", userIdentity).GetAll(ctx, &rcs)
+	if err != nil {
+		return nil, fmt.Errorf("datastore GetAll error: %+v", err)
+	}
+	for idx, rc := range rcs {
+		rc.ID = keys[idx].IntID()
+	}
+	return rcs, nil
+}
+
+// DeleteRecoveryCode implements Store.
+func (DatastoreStore) DeleteRecoveryCode(ctx context.Context, userIdentity string, id int64) error {
+	return deleteOwned(ctx, idKey(ctx, "RecoveryCode", id), userIdentity, &RecoveryCode{})
+}
+
+// PutTOTP implements Store.
+func (DatastoreStore) PutTOTP(ctx context.Context, totp *TOTP) (int64, error) {
+	k, err := datastore.Put(ctx, makeKey(ctx, "", "TOTP"), totp)
+	if err != nil {
+		return 0, fmt.Errorf("datastore.Put error: %v", err)
+	}
+	return k.IntID(), nil
+}
+
+// TOTPs implements Store.
+func (DatastoreStore) TOTPs(ctx context.Context, userIdentity string) ([]*TOTP, error) {
+	totps := []*TOTP{}
+	keys, err := userQuery(ctx, "TOTP", userIdentity).GetAll(ctx, &totps)
+	if err != nil {
+		return nil, fmt.Errorf("datastore GetAll error: %+v", err)
+	}
+	for idx, totp := range totps {
+		totp.ID = keys[idx].IntID()
+	}
+	return totps, nil
+}
+
+// UpdateTOTP implements Store.
+func (DatastoreStore) UpdateTOTP(ctx context.Context, userIdentity string, id int64, update func(*TOTP) error) (*TOTP, error) {
+	k := idKey(ctx, "TOTP", id)
+	var totp TOTP
+	err := datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		totp = TOTP{}
+		if err := datastore.Get(tc, k, &totp); err == datastore.ErrNoSuchEntity {
+			return ErrNoSuchRegistration
+		} else if err != nil {
+			return fmt.Errorf("datastore.Get error: %v", err)
+		}
+
+		if totp.UserIdentity != userIdentity {
+			return ErrNoSuchRegistration
+		}
+		if err := update(&totp); err != nil {
+			return err
+		}
+
+		if _, err := datastore.Put(tc, k, &totp); err != nil {
+			return fmt.Errorf("datastore.Put error: %v", err)
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	totp.ID = id
+	return &totp, nil
+}
+
+// DeleteTOTP implements Store.
+func (DatastoreStore) DeleteTOTP(ctx context.Context, userIdentity string, id int64) error {
+	return deleteOwned(ctx, idKey(ctx, "TOTP", id), userIdentity, &TOTP{})
+}
+
+// throttleKey returns the key of the throttle with the given key.  Throttles
+// are root entities, not in the entity group of MakeParentKey, so that
+// counting the attempts of one user does not contend with the writes of
+// every other.
+func throttleKey(ctx context.Context, key string) *datastore.Key {
+	return datastore.NewKey(ctx, "Throttle", key, 0, nil)
+}
+
+// GetThrottle implements Store.
+func (DatastoreStore) GetThrottle(ctx context.Context, key string) (*Throttle, error) {
+	var t Throttle
+	if err := datastore.Get(ctx, throttleKey(ctx, key), &t); err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, fmt.Errorf("datastore.Get error: %v", err)
+	}
+	return &t, nil
+}
+
+// UpdateThrottle implements Store.
+func (DatastoreStore) UpdateThrottle(ctx context.Context, key string, update func(*Throttle)) error {
+	k := throttleKey(ctx, key)
+	return datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		var t Throttle
+		if err := datastore.Get(tc, k, &t); err != nil && err != datastore.ErrNoSuchEntity {
+			return fmt.Errorf("datastore.Get error: %v", err)
+		}
+
+		update(&t)
+		if _, err := datastore.Put(tc, k, &t); err != nil {
+			return fmt.Errorf("datastore.Put error: %v", err)
+		}
+		return nil
+	}, nil)
+}
+
+// DeleteThrottle implements Store.
+func (DatastoreStore) DeleteThrottle(ctx context.Context, key string) error {
+	if err := datastore.Delete(ctx, throttleKey(ctx, key)); err != nil && err != datastore.ErrNoSuchEntity {
+		return fmt.Errorf("datastore.Delete error: %v", err)
+	}
+	return nil
+}
+
+// Users implements Store.  The queries are projections, which are not by
+// ancestor so that they need no composite index, and so are eventually
+// consistent.
+func (DatastoreStore) Users(ctx context.Context) ([]string, error) {
+	seen := map[string]bool{}
+	for _, kind := range []string{"Registration", "RecoveryCode", "TOTP"} {
+		var owners []struct{ UserIdentity string }
+		_, err := datastore.NewQuery(kind).Project("UserIdentity").Distinct().GetAll(ctx, &owners)
+		if err != nil {
+			return nil, fmt.Errorf("datastore GetAll error: %+v", err)
+		}
+		for _, o := range owners {
+			seen[o.UserIdentity] = true
+		}
+	}
+
+	users := make([]string, 0, len(seen))
+	for u := range seen {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	return users, nil
+}
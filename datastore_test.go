@@ -0,0 +1,36 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f_test
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/aetest"
+
+	"github.com/brianmhunt/aeu2f-go"
+	"github.com/brianmhunt/aeu2f-go/aeu2ftest/storetest"
+)
+
+func TestDatastoreStore(t *testing.T) {
+	storetest.RunStoreTests(t, func(t *testing.T) (context.Context, aeu2f.Store) {
+		// CountChallenges is not by ancestor, so is only consistent in a
+		// strongly consistent datastore.
+		inst, err := aetest.NewInstance(&aetest.Options{StronglyConsistentDatastore: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { inst.Close() })
+
+		r, err := inst.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return appengine.NewContext(r), aeu2f.DatastoreStore{}
+	})
+}
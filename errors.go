@@ -0,0 +1,51 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoChallenge is returned when a response arrives for a user that has no
+// outstanding challenge.
+var ErrNoChallenge = errors.New("aeu2f: no outstanding challenge")
+
+// ErrNoSuchRegistration is returned when a registration does not exist, or
+// does not belong to the given user.
+var ErrNoSuchRegistration = errors.New("aeu2f: no such registration")
+
+// ErrRevoked is the Err of the VerificationError returned when a revoked
+// registration signs.
+var ErrRevoked = errors.New("aeu2f: registration revoked")
+
+// VerificationError is returned when a U2F response fails to verify against
+// its challenge; i.e. the fault lies with the client, not the server.
+type VerificationError struct {
+	Op  string
+	Err error
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("%s error: %v", e.Op, e.Err)
+}
+
+// Unwrap returns Err, e.g. for errors.Is(err, ErrRevoked).
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+// LockedOutError is returned when a user or client has failed to
+// authenticate too many times, and must wait until Until before trying again.
+type LockedOutError struct {
+	Until time.Time
+}
+
+func (e *LockedOutError) Error() string {
+	return fmt.Sprintf("aeu2f: locked out until %v", e.Until.Format(time.RFC3339))
+}
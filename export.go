@@ -0,0 +1,319 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"bytes"
+	"context"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// ExportFormat identifies an export, in its ExportHeader.
+const ExportFormat = "aeu2f-registrations"
+
+// ExportVersion is the version of the export format written by
+// ExportRegistrations.
+const ExportVersion = 1
+
+// ExportHeader is the first value of an export.
+type ExportHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	AppID   string    `json:"app_id"`
+	Created time.Time `json:"created"`
+}
+
+// ExportedRegistration is a registration in an export.  It holds what any
+// U2F server needs to verify the key's signatures, so that it can be moved
+// between backends and servers of the same AppID, without the user
+// registering the key again.  Binary fields are in standard base64.
+type ExportedRegistration struct {
+	UserIdentity string `json:"user"`
+	KeyHandle    []byte `json:"key_handle"`
+
+	// PublicKey is an uncompressed P-256 point.
+	PublicKey []byte `json:"public_key"`
+
+	// Certificate is the DER of the attestation certificate, if known.
+	Certificate []byte `json:"certificate,omitempty"`
+
+	Counter int64      `json:"counter"`
+	Name    string     `json:"name,omitempty"`
+	Created time.Time  `json:"created"`
+	Revoked *time.Time `json:"revoked,omitempty"`
+
+	// Properties is the JSON object of the registration's Properties.
+	Properties json.RawMessage `json:"properties,omitempty"`
+}
+
+// ImportReport is the outcome of ImportRegistrations.
+type ImportReport struct {
+	// Added is the number of registrations stored.
+	Added int
+
+	// Updated is the number of registrations already stored, whose
+	// counter has been raised to the imported one.
+	Updated int
+
+	// Unchanged is the number of registrations already stored, with a
+	// counter at least the imported one.
+	Unchanged int
+
+	// Conflicts are the registrations whose key handle the user already
+	// has, but with another public key.  They are not imported.
+	Conflicts []ImportProblem
+
+	// Rejected are the records that are not valid registrations.
+	Rejected []ImportProblem
+}
+
+// ImportProblem is a record that was not imported.
+type ImportProblem struct {
+	// Record is the position of the record in the import, from 1.
+	Record       int
+	UserIdentity string
+	KeyHandle    string // base64url
+	Reason       string
+}
+
+// --- exportRegistration ---
+// Return the export of regi.
+func exportRegistration(ctx context.Context, regi *Registration) (*ExportedRegistration, error) {
+	reg, err := u2fKey(ctx, regi)
+	if err != nil {
+		return nil, err
+	}
+
+	x := &ExportedRegistration{
+		UserIdentity: regi.UserIdentity,
+		KeyHandle:    reg.KeyHandle,
+		PublicKey:    elliptic.Marshal(elliptic.P256(), reg.PubKey.X, reg.PubKey.Y),
+		Counter:      regi.Counter,
+		Name:         regi.Name,
+		Created:      regi.Created,
+		Properties:   regi.Properties,
+	}
+	if cert, err := regi.AttestationCertificate(); err == nil {
+		x.Certificate = cert.Raw
+	}
+	if !regi.Revoked.IsZero() {
+		revoked := regi.Revoked
+		x.Revoked = &revoked
+	}
+	return x, nil
+}
+
+// ExportRegistrations writes the registrations of every user to w, and
+// returns how many it wrote.  The export is JSON: an ExportHeader, then an
+// ExportedRegistration per registration, one value per line, so that it can
+// be written and read as a stream.  Use EncryptExport to encrypt it.
+func ExportRegistrations(ctx context.Context, w io.Writer) (n int, err error) {
+	ctx, end := startStorage(ctx, "exportRegistrations")
+	defer func() { end(err) }()
+
+	appID, _ := appID(ctx)
+	enc := json.NewEncoder(w)
+	err = enc.Encode(&ExportHeader{
+		Format:  ExportFormat,
+		Version: ExportVersion,
+		AppID:   appID,
+		Created: time.Now(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	users, err := Storage.Users(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, u := range users {
+		regis, err := Storage.Registrations(ctx, u)
+		if err != nil {
+			return n, err
+		}
+		for _, regi := range regis {
+			x, err := exportRegistration(ctx, regi)
+			if err != nil {
+				return n, fmt.Errorf("registration %v of %q: %v", regi.ID, u, err)
+			}
+			if err := enc.Encode(x); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ImportRegistrations reads an export of ExportRegistrations from r, and
+// stores its registrations.  An export for another AppID is refused, since
+// its keys would not sign for this one.
+//
+// Importing is idempotent: a registration the user already has, i.e. with
+// the same key handle and public key, is not stored again, though its
+// counter is raised to the imported one, never lowered.  Records that are
+// not imported are reported; only a malformed export, or a failure of the
+// Store, stops the import, with the report so far.
+func ImportRegistrations(ctx context.Context, r io.Reader) (*ImportReport, error) {
+	dec := json.NewDecoder(r)
+	var h ExportHeader
+	if err := dec.Decode(&h); err != nil {
+		return nil, fmt.Errorf("export header: %v", err)
+	}
+	appID, _ := appID(ctx)
+	switch {
+	case h.Format != ExportFormat:
+		return nil, fmt.Errorf("not an export of registrations: format %q", h.Format)
+	case h.Version != ExportVersion:
+		return nil, fmt.Errorf("unsupported export version %v", h.Version)
+	case h.AppID != "" && appID != "" && h.AppID != appID:
+		return nil, fmt.Errorf("export is for app ID %q, not %q", h.AppID, appID)
+	}
+
+	report := &ImportReport{}
+	for record := 1; ; record++ {
+		var x ExportedRegistration
+		if err := dec.Decode(&x); err == io.EOF {
+			return report, nil
+		} else if err != nil {
+			return report, fmt.Errorf("record %v: %v", record, err)
+		}
+		if err := importRegistration(ctx, report, record, &x); err != nil {
+			return report, err
+		}
+	}
+}
+
+// --- importedRegistration ---
+// Return the Registration of an imported one, or an error saying why it is
+// not valid.
+func importedRegistration(x *ExportedRegistration) (*Registration, error) {
+	switch {
+	case x.UserIdentity == "":
+		return nil, errors.New("no user")
+	case len(x.KeyHandle) == 0 || len(x.KeyHandle) > 255:
+		return nil, fmt.Errorf("key handle of %v bytes", len(x.KeyHandle))
+	case x.Counter < 0 || x.Counter > math.MaxUint32:
+		return nil, fmt.Errorf("counter %v out of range", x.Counter)
+	}
+	if px, _ := elliptic.Unmarshal(elliptic.P256(), x.PublicKey); px == nil {
+		return nil, errors.New("public key is not an uncompressed P-256 point")
+	}
+	var props map[string]interface{}
+	if len(x.Properties) > 0 && (json.Unmarshal(x.Properties, &props) != nil || props == nil) {
+		return nil, errors.New("properties are not a JSON object")
+	}
+
+	regi := &Registration{
+		UserIdentity: x.UserIdentity,
+		KeyHandle:    x.KeyHandle,
+		PublicKey:    x.PublicKey,
+		Counter:      x.Counter,
+		Name:         x.Name,
+		Created:      x.Created,
+	}
+	if len(props) > 0 {
+		regi.Properties = x.Properties
+	}
+	if regi.Created.IsZero() {
+		regi.Created = time.Now()
+	}
+	if x.Revoked != nil {
+		regi.Revoked = *x.Revoked
+	}
+
+	// The registration data is rebuilt around the certificate, so that it
+	// can be shown as for a registered key.  The signature of the original
+	// registration is not kept by every server, so is left out.
+	if len(x.Certificate) > 0 {
+		if _, err := x509.ParseCertificate(x.Certificate); err != nil {
+			return nil, fmt.Errorf("certificate: %v", err)
+		}
+		var b bytes.Buffer
+		b.WriteByte(0x05)
+		b.Write(x.PublicKey)
+		b.WriteByte(byte(len(x.KeyHandle)))
+		b.Write(x.KeyHandle)
+		b.Write(x.Certificate)
+		regi.U2FRegistrationBytes = b.Bytes()
+	}
+	return regi, nil
+}
+
+// --- importRegistration ---
+// Import the record x, adding its outcome to the report.  Only a failure of
+// the Store is returned.
+func importRegistration(ctx context.Context, report *ImportReport, record int, x *ExportedRegistration) (err error) {
+	problem := func(reason string) ImportProblem {
+		return ImportProblem{
+			Record:       record,
+			UserIdentity: x.UserIdentity,
+			KeyHandle:    base64.RawURLEncoding.EncodeToString(x.KeyHandle),
+			Reason:       reason,
+		}
+	}
+
+	regi, err := importedRegistration(x)
+	if err != nil {
+		report.Rejected = append(report.Rejected, problem(err.Error()))
+		return nil
+	}
+
+	regis, err := Storage.Registrations(ctx, regi.UserIdentity)
+	if err != nil {
+		return err
+	}
+	for _, cur := range regis {
+		reg, err := u2fKey(ctx, cur)
+		if err != nil {
+			return fmt.Errorf("registration %v of %q: %v", cur.ID, cur.UserIdentity, err)
+		}
+		if !bytes.Equal(reg.KeyHandle, regi.KeyHandle) {
+			continue
+		}
+		if !bytes.Equal(elliptic.Marshal(elliptic.P256(), reg.PubKey.X, reg.PubKey.Y), regi.PublicKey) {
+			report.Conflicts = append(report.Conflicts, problem(
+				fmt.Sprintf("registration %v has the key handle, with another public key", cur.ID)))
+			return nil
+		}
+		if cur.Counter >= regi.Counter {
+			report.Unchanged++
+			return nil
+		}
+
+		_, err = Storage.UpdateRegistration(ctx, cur.UserIdentity, cur.ID, func(r *Registration) error {
+			if r.Counter < regi.Counter {
+				r.Counter = regi.Counter
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		report.Updated++
+		return nil
+	}
+
+	var id int64
+	ctx, start := begin(ctx, AuditImport, regi.UserIdentity)
+	defer func() { finish(ctx, AuditImport, regi.UserIdentity, id, start, err) }()
+
+	if id, err = putRegistration(ctx, regi); err != nil {
+		return err
+	}
+	report.Added++
+	return nil
+}
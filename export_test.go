@@ -0,0 +1,225 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+// mustList returns the user's registrations, or fails the test.
+func mustList(t *testing.T, ctx context.Context, userIdentity string) []*Registration {
+	regis, err := ListRegistrations(ctx, userIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return regis
+}
+
+func TestExportImport(t *testing.T) {
+	ctx, done := newConsistentContext(t)
+	defer done()
+
+	a, aReq := registerAuthenticator(t, ctx, "bob")
+	if _, err := Authenticate(ctx, "bob", sign(t, a, aReq)); err != nil {
+		t.Fatal(err)
+	}
+	registerAuthenticator(t, ctx, "bob")
+	registerAuthenticator(t, ctx, "alice")
+	aRegi := registrationOf(t, mustList(t, ctx, "bob"), aReq.KeyHandle)
+	if _, err := RenameRegistration(ctx, "bob", aRegi.ID, "yubikey"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UpdateProperties(ctx, "bob", aRegi.ID, map[string]interface{}{"os": "linux"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RevokeRegistration(ctx, "alice", mustList(t, ctx, "alice")[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if n, err := ExportRegistrations(ctx, &b); err != nil || n != 3 {
+		t.Fatalf("Expected 3 registrations exported, got %v, %v", n, err)
+	}
+	export := b.String()
+	if !strings.HasPrefix(export, `{"format":"aeu2f-registrations","version":1,`) || strings.Count(export, "\n") != 4 {
+		t.Errorf("Unexpected export %v", export)
+	}
+
+	// Import into another backend.
+	old := Storage
+	defer func() { Storage = old }()
+	Storage = NewMemoryStore()
+
+	report, err := ImportRegistrations(ctx, strings.NewReader(export))
+	if err != nil || report.Added != 3 || len(report.Rejected)+len(report.Conflicts) != 0 {
+		t.Fatalf("Expected 3 registrations imported, got %+v, %v", report, err)
+	}
+	imported := registrationOf(t, mustList(t, ctx, "bob"), aReq.KeyHandle)
+	if imported.Name != "yubikey" || string(imported.Properties) != `{"os":"linux"}` || imported.Counter != int64(a.Counter) || !imported.Created.Equal(aRegi.Created) {
+		t.Errorf("Unexpected imported registration %+v", imported)
+	}
+	if cert, err := imported.AttestationCertificate(); err != nil || cert.Subject.CommonName != "aeu2ftest attestation" {
+		t.Errorf("Expected the attestation certificate to be imported, got %v, %v", cert, err)
+	}
+	if regis := mustList(t, ctx, "alice"); len(regis) != 1 || regis[0].Revoked.IsZero() {
+		t.Errorf("Expected alice's registration to stay revoked, got %+v", regis)
+	}
+
+	// The imported key signs, without registering again.
+	reqs, err := NewSignChallenge(ctx, "bob")
+	if err != nil || len(reqs) != 2 {
+		t.Fatalf("Expected 2 sign requests, got %v, %v", reqs, err)
+	}
+	resp, err := a.SignAny(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Sign(ctx, "bob", *resp); err != nil {
+		t.Errorf("Expected the imported key to sign, got %v", err)
+	}
+
+	// Importing again changes nothing: the counter is now above the
+	// exported one, and is not lowered.
+	report, err = ImportRegistrations(ctx, strings.NewReader(export))
+	if err != nil || report.Added != 0 || report.Updated != 0 || report.Unchanged != 3 {
+		t.Errorf("Expected the import to be idempotent, got %+v, %v", report, err)
+	}
+	if regi := registrationOf(t, mustList(t, ctx, "bob"), aReq.KeyHandle); regi.Counter != int64(a.Counter) {
+		t.Errorf("Expected the counter not to be lowered, got %v", regi.Counter)
+	}
+}
+
+func TestImportProblems(t *testing.T) {
+	ctx := setupMemoryStore(t, testAppID)
+
+	registerAuthenticator(t, ctx, "bob")
+	registerAuthenticator(t, ctx, "carol")
+	x, err := exportRegistration(ctx, mustList(t, ctx, "bob")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := exportRegistration(ctx, mustList(t, ctx, "carol")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// line returns the record x, changed by f.
+	line := func(f func(x *ExportedRegistration)) string {
+		cp := *x
+		f(&cp)
+		b, _ := json.Marshal(&cp)
+		return string(b) + "\n"
+	}
+	header := `{"format":"aeu2f-registrations","version":1,"app_id":"` + testAppID + `"}` + "\n"
+	export := header +
+		line(func(x *ExportedRegistration) { x.Counter = 7 }) +
+		line(func(x *ExportedRegistration) { x.PublicKey = other.PublicKey }) +
+		line(func(x *ExportedRegistration) { x.UserIdentity = "" }) +
+		line(func(x *ExportedRegistration) { x.PublicKey = x.PublicKey[1:] }) +
+		line(func(x *ExportedRegistration) { x.Certificate = []byte("cert") }) +
+		line(func(x *ExportedRegistration) { x.Counter = -1 }) +
+		line(func(x *ExportedRegistration) { x.Properties = json.RawMessage(`["os"]`) }) +
+		line(func(x *ExportedRegistration) { x.UserIdentity = "alice" })
+
+	report, err := ImportRegistrations(ctx, strings.NewReader(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Updated != 1 || report.Added != 1 || len(report.Conflicts) != 1 || len(report.Rejected) != 5 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if len(report.Conflicts) == 1 && report.Conflicts[0].Record != 2 {
+		t.Errorf("Expected record 2 to conflict, got %+v", report.Conflicts[0])
+	}
+	for i, p := range report.Rejected {
+		if p.Record != i+3 || p.Reason == "" {
+			t.Errorf("Expected record %v to be rejected, got %+v", i+3, p)
+		}
+	}
+	if regis := mustList(t, ctx, "bob"); len(regis) != 1 || regis[0].Counter != 7 {
+		t.Errorf("Expected the counter to be raised, got %+v", regis)
+	}
+
+	for name, export := range map[string]string{
+		"other app":  `{"format":"aeu2f-registrations","version":1,"app_id":"https://other.example.com"}`,
+		"version":    `{"format":"aeu2f-registrations","version":2}`,
+		"format":     `{"format":"something else","version":1}`,
+		"bad record": header + "[1, 2]",
+		"truncated":  header + `{"user": "bob", "key`,
+	} {
+		if _, err := ImportRegistrations(ctx, strings.NewReader(export)); err == nil {
+			t.Errorf("%v: expected the import to fail", name)
+		}
+	}
+}
+
+func TestEncryptExport(t *testing.T) {
+	key := make([]byte, ExportKeySize)
+	rand.Read(key)
+
+	encrypt := func(p []byte) []byte {
+		var b bytes.Buffer
+		w, err := EncryptExport(&b, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// In two writes, to cross chunks.
+		w.Write(p[:len(p)/2])
+		w.Write(p[len(p)/2:])
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+	decrypt := func(c, key []byte) ([]byte, error) {
+		r, err := DecryptExport(bytes.NewReader(c), key)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	for _, size := range []int{0, 1, exportChunkSize, exportChunkSize + 1, 3*exportChunkSize - 5} {
+		p := make([]byte, size)
+		rand.Read(p)
+		c := encrypt(p)
+		if got, err := decrypt(c, key); err != nil || !bytes.Equal(got, p) {
+			t.Errorf("%v bytes: expected them back, got %v bytes, %v", size, len(got), err)
+		}
+	}
+
+	p := make([]byte, 2*exportChunkSize+100)
+	c := encrypt(p)
+	wrong := make([]byte, ExportKeySize)
+	flipped := append([]byte{}, c...)
+	flipped[len(c)/2] ^= 1
+	lastChunk := exportHeaderSize + 2*(4+exportChunkLenMax)
+	for name, tc := range map[string]struct{ c, key []byte }{
+		"wrong key":       {c, wrong},
+		"flipped":         {flipped, key},
+		"truncated":       {c[:len(c)-1], key},
+		"last chunk gone": {c[:lastChunk], key},
+		"appended":        {append(append([]byte{}, c...), 0), key},
+	} {
+		if _, err := decrypt(tc.c, tc.key); err != ErrExportDecrypt {
+			t.Errorf("%v: expected ErrExportDecrypt, got %v", name, err)
+		}
+	}
+
+	if _, err := EncryptExport(io.Discard, key[1:]); err == nil {
+		t.Error("Expected a short key to be refused")
+	}
+	if _, err := decrypt([]byte(`{"format":"aeu2f-registrations"}`), key); err == nil || err == ErrExportDecrypt {
+		t.Errorf("Expected a plain export to be told apart, got %v", err)
+	}
+}
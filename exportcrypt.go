@@ -0,0 +1,232 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// An encrypted export is the magic and a random nonce prefix, then the
+// export in chunks sealed with AES-256-GCM.  Each chunk's nonce is the
+// prefix, the chunk's position, and whether it is the last, so that chunks
+// cannot be reordered, dropped or truncated unnoticed.
+const (
+	exportMagic       = "AEU2FX1\n"
+	exportPrefixSize  = 7
+	exportChunkSize   = 64 * 1024
+	exportHeaderSize  = len(exportMagic) + exportPrefixSize
+	exportChunkLenMax = exportChunkSize + 16 // with the GCM tag
+)
+
+// ExportKeySize is the size of the key of EncryptExport and DecryptExport.
+const ExportKeySize = 32
+
+// ErrExportDecrypt is returned when an encrypted export cannot be
+// decrypted: the key is wrong, or the export has been altered or truncated.
+var ErrExportDecrypt = errors.New("aeu2f: cannot decrypt export")
+
+// --- exportAEAD ---
+func exportAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != ExportKeySize {
+		return nil, fmt.Errorf("export key is %v bytes, expected %v", len(key), ExportKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// --- exportNonce ---
+// Return the nonce of chunk i.
+func exportNonce(prefix []byte, i uint32, last bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, i)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// exportWriter encrypts an export, a chunk at a time.
+type exportWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	chunk  uint32
+	buf    []byte
+	err    error
+}
+
+// EncryptExport returns a writer that encrypts what is written to it, e.g.
+// by ExportRegistrations, to w, with a key of ExportKeySize random bytes.
+// The export is only complete once the writer is closed; closing it does not
+// close w.
+func EncryptExport(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := exportAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, exportHeaderSize)
+	copy(header, exportMagic)
+	if _, err := rand.Read(header[len(exportMagic):]); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &exportWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: header[len(exportMagic):],
+	}, nil
+}
+
+// --- seal ---
+// Write the chunk p.
+func (e *exportWriter) seal(p []byte, last bool) error {
+	if e.chunk == math.MaxUint32 {
+		return errors.New("export too large to encrypt")
+	}
+	sealed := e.aead.Seal(nil, exportNonce(e.prefix, e.chunk, last), p, e.header)
+	e.chunk++
+
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(sealed)))
+	if _, err := e.w.Write(n[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+func (e *exportWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	e.buf = append(e.buf, p...)
+
+	// The last chunk is written by Close, so a full chunk is only written
+	// once more follows it.
+	for len(e.buf) > exportChunkSize {
+		if e.err = e.seal(e.buf[:exportChunkSize], false); e.err != nil {
+			return 0, e.err
+		}
+		e.buf = append(e.buf[:0], e.buf[exportChunkSize:]...)
+	}
+	return len(p), nil
+}
+
+// Close writes the last chunk.
+func (e *exportWriter) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	if err := e.seal(e.buf, true); err != nil {
+		e.err = err
+		return err
+	}
+	e.err = errors.New("export writer closed")
+	return nil
+}
+
+// exportReader decrypts an export, a chunk at a time.
+type exportReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	chunk  uint32
+	buf    []byte
+	done   bool
+}
+
+// DecryptExport returns a reader of the export encrypted by EncryptExport
+// in r, with the same key.  It returns ErrExportDecrypt if the key is wrong,
+// or the export has been altered or truncated.
+func DecryptExport(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := exportAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, exportHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.HasPrefix(header, []byte(exportMagic)) {
+		return nil, errors.New("not an encrypted export")
+	}
+	return &exportReader{
+		r:      r,
+		aead:   aead,
+		header: header,
+		prefix: header[len(exportMagic):],
+	}, nil
+}
+
+// --- open ---
+// Read and decrypt the next chunk.
+func (d *exportReader) open() error {
+	var n [4]byte
+	if _, err := io.ReadFull(d.r, n[:]); err != nil {
+		// The last chunk has not been read.
+		return ErrExportDecrypt
+	}
+	size := binary.BigEndian.Uint32(n[:])
+	if size > exportChunkLenMax {
+		return ErrExportDecrypt
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return ErrExportDecrypt
+	}
+
+	// Only the last chunk may be short, though it may also be full.
+	last := size < exportChunkLenMax
+	p, err := d.aead.Open(nil, exportNonce(d.prefix, d.chunk, last), sealed, d.header)
+	if err != nil && !last {
+		last = true
+		p, err = d.aead.Open(nil, exportNonce(d.prefix, d.chunk, last), sealed, d.header)
+	}
+	if err != nil {
+		return ErrExportDecrypt
+	}
+	d.chunk++
+	d.buf, d.done = p, last
+
+	if last {
+		// Nothing may follow the last chunk.
+		if _, err := io.ReadFull(d.r, n[:1]); err != io.EOF {
+			return ErrExportDecrypt
+		}
+	}
+	return nil
+}
+
+func (d *exportReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,260 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"bytes"
+	"context"
+	"crypto/elliptic"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/brianmhunt/aeu2f-go/aeu2ftest"
+	"github.com/tstranex/u2f"
+)
+
+// The fuzz targets run the operations against a MemoryStore, so they need
+// no datastore, e.g.
+// 	go test -run XXX -fuzz FuzzSign
+//
+// Each input should start with a new Storage.
+
+// expectVerificationError fails unless err is a VerificationError: with a
+// MemoryStore nothing else can fail, so any other error is a bug.
+func expectVerificationError(t *testing.T, err error) {
+	if _, ok := err.(*VerificationError); !ok {
+		t.Fatalf("Expected a VerificationError, got %T %v", err, err)
+	}
+}
+
+// fuzzRegistration returns a registration by a software authenticator, its
+// stored bytes, and a response to a sign request for it.
+func fuzzRegistration(f *testing.F, a *aeu2ftest.Authenticator) (*u2f.Challenge, []byte, *u2f.SignResponse) {
+	c, err := u2f.NewChallenge(testAppID, []string{testAppID})
+	if err != nil {
+		f.Fatal(err)
+	}
+	resp, err := a.Register(c.RegisterRequest())
+	if err != nil {
+		f.Fatal(err)
+	}
+	reg, err := u2f.Register(*resp, *c, &u2f.Config{SkipAttestationVerify: true})
+	if err != nil {
+		f.Fatal(err)
+	}
+	buf, _ := reg.MarshalBinary()
+
+	signResp, err := a.Sign(c.SignRequest(*reg))
+	if err != nil {
+		f.Fatal(err)
+	}
+	return c, buf, signResp
+}
+
+func FuzzStoreResponse(f *testing.F) {
+	ctx := setupMemoryStore(f, fakeHost)
+
+	// The recorded registration, and one by a software authenticator, both
+	// for the challenge of the recorded registration.
+	f.Add(fakeRegistrationResponse.RegistrationData, fakeRegistrationResponse.ClientData)
+	resp, err := aeu2ftest.NewFromSeed([]byte("fuzz")).Register(fakeRegistrationChallenge.RegisterRequest())
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(resp.RegistrationData, resp.ClientData)
+	f.Add(resp.RegistrationData[:100], resp.ClientData)
+	f.Add("", "")
+
+	f.Fuzz(func(t *testing.T, registrationData, clientData string) {
+		Storage = NewMemoryStore()
+		c := fakeRegistrationChallenge
+		c.Timestamp = time.Now()
+		if err := Storage.PutChallenge(ctx, ChallengeRegister, "fuzz", &c); err != nil {
+			t.Fatal(err)
+		}
+
+		err := StoreResponse(ctx, "fuzz", u2f.RegisterResponse{
+			RegistrationData: registrationData,
+			ClientData:       clientData,
+		})
+		if err != nil {
+			expectVerificationError(t, err)
+			return
+		}
+
+		// An accepted registration is stored as sent, and can be used.
+		if err := checkClientData(clientData, clientDataRegister); err != nil {
+			t.Fatalf("Accepted client data %q: %v", clientData, err)
+		}
+		regis, _ := Storage.Registrations(ctx, "fuzz")
+		data, _ := decodeBase64(registrationData)
+		if len(regis) != 1 || !bytes.Equal(regis[0].U2FRegistrationBytes, data) {
+			t.Fatalf("Expected the registration to be stored, got %v", regis)
+		}
+		var reg u2f.Registration
+		if err := reg.UnmarshalBinary(data); err != nil {
+			t.Fatalf("Stored registration bytes that do not parse: %v", err)
+		}
+		if !bytes.Equal(regis[0].KeyHandle, reg.KeyHandle) ||
+			!bytes.Equal(regis[0].PublicKey, elliptic.Marshal(elliptic.P256(), reg.PubKey.X, reg.PubKey.Y)) {
+			t.Fatalf("Expected the parsed key to be stored, got %+v", regis[0])
+		}
+		if _, err := signChallengeRequest(ctx, c, *regis[0]); err != nil {
+			t.Fatalf("Stored an unusable registration: %v", err)
+		}
+		if _, err := Storage.GetChallenge(ctx, ChallengeRegister, "fuzz"); err != ErrNoChallenge {
+			t.Fatalf("Expected the challenge to be consumed, got %v", err)
+		}
+	})
+}
+
+func FuzzSign(f *testing.F) {
+	ctx := setupMemoryStore(f, testAppID)
+
+	a := aeu2ftest.NewFromSeed([]byte("fuzz"))
+	c, regBytes, signResp := fuzzRegistration(f, a)
+	f.Add(signResp.KeyHandle, signResp.SignatureData, signResp.ClientData)
+
+	var reg u2f.Registration
+	if err := reg.UnmarshalBinary(regBytes); err != nil {
+		f.Fatal(err)
+	}
+	for _, edit := range []func(){
+		func() { a.NoUserPresence = true },
+		func() { a.ClientDataType = aeu2ftest.TypeRegister },
+		func() { a.Origin = "https://evil.example.com" },
+	} {
+		edit()
+		resp, err := a.Sign(c.SignRequest(reg))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(resp.KeyHandle, resp.SignatureData, resp.ClientData)
+		a.NoUserPresence, a.ClientDataType, a.Origin = false, "", ""
+	}
+	f.Add(signResp.KeyHandle, signResp.SignatureData[:7], signResp.ClientData)
+	f.Add("", "", "")
+
+	f.Fuzz(func(t *testing.T, keyHandle, signatureData, clientData string) {
+		Storage = NewMemoryStore()
+		_, err := Storage.PutRegistration(ctx, &Registration{
+			UserIdentity:         "fuzz",
+			U2FRegistrationBytes: regBytes,
+			KeyHandle:            reg.KeyHandle,
+			PublicKey:            elliptic.Marshal(elliptic.P256(), reg.PubKey.X, reg.PubKey.Y),
+			Created:              time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		challenge := *c
+		challenge.Timestamp = time.Now()
+		if err := Storage.PutChallenge(ctx, ChallengeSign, "fuzz", &challenge); err != nil {
+			t.Fatal(err)
+		}
+
+		regi, err := Authenticate(ctx, "fuzz", u2f.SignResponse{
+			KeyHandle:     keyHandle,
+			SignatureData: signatureData,
+			ClientData:    clientData,
+		})
+		if err != nil {
+			expectVerificationError(t, err)
+			return
+		}
+
+		// An accepted response is for the key, from a present user, with
+		// client data for a sign request and a counter above 0.
+		if keyHandle != c.SignRequest(reg).KeyHandle {
+			t.Fatalf("Accepted key handle %q", keyHandle)
+		}
+		if err := checkClientData(clientData, clientDataSign); err != nil {
+			t.Fatalf("Accepted client data %q: %v", clientData, err)
+		}
+		sd, _ := decodeBase64(signatureData)
+		counter, _ := signCounter(u2f.SignResponse{SignatureData: signatureData})
+		if len(sd) < 5 || sd[0] != 1 || counter == 0 || regi.Counter != int64(counter) {
+			t.Fatalf("Accepted signature data %x, stored counter %v", sd, regi.Counter)
+		}
+	})
+}
+
+func FuzzClientData(f *testing.F) {
+	f.Add(fakeRegistrationResponse.ClientData)
+	_, _, signResp := fuzzRegistration(f, aeu2ftest.NewFromSeed([]byte("fuzz")))
+	f.Add(signResp.ClientData)
+	f.Add(base64.RawURLEncoding.EncodeToString([]byte(`{"typ":1}`)))
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, clientData string) {
+		reg := checkClientData(clientData, clientDataRegister) == nil
+		sign := checkClientData(clientData, clientDataSign) == nil
+		if reg && sign {
+			t.Fatalf("Accepted client data %q for both types", clientData)
+		}
+	})
+}
+
+func FuzzSignatureData(f *testing.F) {
+	_, _, signResp := fuzzRegistration(f, aeu2ftest.NewFromSeed([]byte("fuzz")))
+	f.Add(signResp.SignatureData)
+	f.Add("AQAAAAE")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, signatureData string) {
+		counter, ok := signCounter(u2f.SignResponse{SignatureData: signatureData})
+		if !ok {
+			return
+		}
+
+		sd, err := decodeBase64(signatureData)
+		if err != nil || len(sd) < 5 {
+			t.Fatalf("Parsed a counter from %q", signatureData)
+		}
+		if want := uint32(sd[1])<<24 | uint32(sd[2])<<16 | uint32(sd[3])<<8 | uint32(sd[4]); counter != want {
+			t.Fatalf("Expected counter %v, got %v", want, counter)
+		}
+	})
+}
+
+func FuzzRegistrationBytes(f *testing.F) {
+	ctx := context.Background()
+
+	data, err := decodeBase64(fakeRegistrationResponse.RegistrationData)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(data)
+	c, regBytes, _ := fuzzRegistration(f, aeu2ftest.NewFromSeed([]byte("fuzz")))
+	f.Add(regBytes)
+	f.Add(regBytes[:67])
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var reg u2f.Registration
+		if err := reg.UnmarshalBinary(data); err != nil {
+			if _, err := signChallengeRequest(ctx, *c, Registration{U2FRegistrationBytes: data}); err == nil {
+				t.Fatal("Expected a sign request to fail for bytes that do not parse")
+			}
+			return
+		}
+
+		// Registrations are stored as the bytes they were parsed from, and
+		// hold a valid public key.
+		if buf, _ := reg.MarshalBinary(); !bytes.Equal(buf, data) {
+			t.Fatal("Expected MarshalBinary to return the parsed bytes")
+		}
+		if !elliptic.P256().IsOnCurve(reg.PubKey.X, reg.PubKey.Y) {
+			t.Fatal("Parsed a public key not on the curve")
+		}
+		req, err := signChallengeRequest(ctx, *c, Registration{U2FRegistrationBytes: data})
+		if err != nil || req.KeyHandle != c.SignRequest(reg).KeyHandle {
+			t.Fatalf("Expected a sign request for the key, got %v, %v", req, err)
+		}
+	})
+}
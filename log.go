@@ -0,0 +1,96 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Logger is the subset of *slog.Logger used by this package, so a
+// *slog.Logger (or a wrapper around another logging library) can be used.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// Log receives the package's log messages, with the structured fields
+// "op", "user", "registration", "duration" and "error".  Each App Engine
+// service configures its own, e.g. in init:
+// 	aeu2f.Log = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+//
+// Challenges, key handles, client data and other response material are
+// never logged.
+var Log Logger = slog.Default()
+
+// RedactUserIdentity, when set, logs user identities as a truncated hash, in
+// case they are e.g. email addresses.
+var RedactUserIdentity = false
+
+// --- hashUser ---
+// Return a truncated hash of the user identity.
+func hashUser(userIdentity string) string {
+	sum := sha256.Sum256([]byte(userIdentity))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// --- logUser ---
+// Return the user identity, as it should appear in logs.
+func logUser(userIdentity string) string {
+	if !RedactUserIdentity {
+		return userIdentity
+	}
+	return hashUser(userIdentity)
+}
+
+// --- logOp ---
+// Log the outcome of an operation that started at start.  Client failures
+// are warnings; server failures are errors.
+func logOp(op, userIdentity string, registrationID int64, start time.Time, err error) {
+	args := []any{
+		"op", op,
+		"user", logUser(userIdentity),
+		"duration", time.Since(start),
+	}
+	if registrationID != 0 {
+		args = append(args, "registration", registrationID)
+	}
+
+	if err == nil {
+		Log.Info(op, args...)
+		return
+	}
+
+	args = append(args, "error", err.Error())
+	if errorType(err) == "internal" {
+		Log.Error(op, args...)
+	} else {
+		Log.Warn(op, args...)
+	}
+}
+
+// --- finish ---
+// Log, count, trace and audit the outcome of an operation begun with begin.
+func finish(ctx context.Context, op, userIdentity string, registrationID int64, start time.Time, err error) {
+	span := trace.SpanFromContext(ctx)
+	if registrationID != 0 {
+		span.SetAttributes(attribute.Int64("aeu2f.registration", registrationID))
+	}
+	defer endSpan(span, err)
+
+	observeOp(op, start, err)
+	logOp(op, userIdentity, registrationID, start, err)
+	audit(ctx, op, userIdentity, registrationID, err)
+}
@@ -0,0 +1,106 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/aetest"
+)
+
+// captureLog sends the package's log to a buffer, until the returned
+// function is called.
+func captureLog() (*bytes.Buffer, func()) {
+	var buf bytes.Buffer
+	old := Log
+	Log = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	return &buf, func() { Log = old }
+}
+
+// logRecords returns the JSON records in buf.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	records := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var r map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("Invalid log line %q: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestLogOp(t *testing.T) {
+	buf, restore := captureLog()
+	defer restore()
+
+	start := time.Now()
+	logOp(AuditSign, "bob", 42, start, nil)
+	logOp(AuditSign, "bob", 0, start, &VerificationError{"Sign", errors.New("bad")})
+	logOp(AuditSign, "bob", 0, start, errors.New("datastore down"))
+
+	records := logRecords(t, buf)
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %v", records)
+	}
+	for i, level := range []string{"INFO", "WARN", "ERROR"} {
+		if records[i]["level"] != level {
+			t.Errorf("Record %v: expected level %v, got %v", i, level, records[i]["level"])
+		}
+		if records[i]["op"] != AuditSign || records[i]["user"] != "bob" || records[i]["duration"] == nil {
+			t.Errorf("Record %v: missing fields in %v", i, records[i])
+		}
+	}
+	if records[0]["registration"] != float64(42) {
+		t.Errorf("Expected the registration ID, got %v", records[0])
+	}
+	if records[1]["error"] != "Sign error: bad" {
+		t.Errorf("Expected the error, got %v", records[1])
+	}
+}
+
+func TestRedactUserIdentity(t *testing.T) {
+	RedactUserIdentity = true
+	defer func() { RedactUserIdentity = false }()
+
+	u := logUser("bob@example.com")
+	if strings.Contains(u, "bob") || !strings.HasPrefix(u, "sha256:") || u != logUser("bob@example.com") {
+		t.Errorf("Unexpected redacted user %q", u)
+	}
+}
+
+func TestChallengeNotLogged(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	buf, restore := captureLog()
+	defer restore()
+
+	req, err := NewRegistrationChallenge(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(buf.String(), req.Challenge) {
+		t.Errorf("Expected the challenge not to be logged: %v", buf)
+	}
+	if records := logRecords(t, buf); len(records) != 1 || records[0]["op"] != AuditRegistrationChallenge {
+		t.Errorf("Unexpected log %v", records)
+	}
+}
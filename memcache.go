@@ -0,0 +1,212 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMemcacheTimeout bounds each call of a MemcacheCache without its own
+// Timeout.
+var DefaultMemcacheTimeout = 100 * time.Millisecond
+
+// memcacheMaxIdle is the number of idle connections a MemcacheCache keeps.
+const memcacheMaxIdle = 8
+
+// MemcacheCache is a RegistrationCache in a memcached server, or anything
+// else speaking the memcache text protocol, shared by every instance.
+// aeu2ftest.Memcached is a stand-in for tests.
+type MemcacheCache struct {
+	// Addr is the host:port of the server.
+	Addr string
+
+	// TTL is how long registrations are cached, rounded up to a second and
+	// at most 30 days; if zero, until the server evicts them.
+	TTL time.Duration
+
+	// Timeout bounds each call, including dialing; DefaultMemcacheTimeout
+	// if zero.
+	Timeout time.Duration
+
+	mu   sync.Mutex
+	idle []*memcacheConn
+}
+
+type memcacheConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// NewMemcacheCache returns a MemcacheCache of the server at addr.
+func NewMemcacheCache(addr string, ttl time.Duration) *MemcacheCache {
+	return &MemcacheCache{Addr: addr, TTL: ttl}
+}
+
+// --- memcacheKey ---
+// Return the memcache key for key, which may hold any bytes: memcache keys
+// are at most 250 bytes, without spaces or control characters.
+func memcacheKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "aeu2f:registrations:" + hex.EncodeToString(sum[:])
+}
+
+// --- do ---
+// Run fn with a connection to the server, which is kept for reuse unless fn
+// fails.
+func (c *MemcacheCache) do(ctx context.Context, fn func(conn *memcacheConn) error) error {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultMemcacheTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var conn *memcacheConn
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		conn, c.idle = c.idle[n-1], c.idle[:n-1]
+	}
+	c.mu.Unlock()
+
+	if conn == nil {
+		var d net.Dialer
+		nc, err := d.DialContext(ctx, "tcp", c.Addr)
+		if err != nil {
+			return fmt.Errorf("memcache dial error: %v", err)
+		}
+		conn = &memcacheConn{nc, bufio.NewReader(nc)}
+	}
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	if err := fn(conn); err != nil {
+		conn.Close()
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle) < memcacheMaxIdle {
+		c.idle = append(c.idle, conn)
+	} else {
+		conn.Close()
+	}
+	return nil
+}
+
+// --- readLine ---
+// Read a line of the response, without its \r\n.
+func (conn *memcacheConn) readLine() (string, error) {
+	line, err := conn.r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("memcache read error: %v", err)
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// --- command ---
+// Send a command, and return the first line of the response.
+func (conn *memcacheConn) command(cmd string, data []byte) (string, error) {
+	buf := append([]byte(cmd), "\r\n"...)
+	if data != nil {
+		buf = append(append(buf, data...), "\r\n"...)
+	}
+	if _, err := conn.Write(buf); err != nil {
+		return "", fmt.Errorf("memcache write error: %v", err)
+	}
+	return conn.readLine()
+}
+
+// Get implements RegistrationCache.
+func (c *MemcacheCache) Get(ctx context.Context, key string) (regis []*Registration, ok bool, err error) {
+	k := memcacheKey(key)
+	err = c.do(ctx, func(conn *memcacheConn) error {
+		line, err := conn.command("get "+k, nil)
+		if err != nil {
+			return err
+		}
+		if line == "END" {
+			return nil
+		}
+
+		// VALUE <key> <flags> <bytes>
+		f := strings.Fields(line)
+		if len(f) != 4 || f[0] != "VALUE" || f[1] != k {
+			return fmt.Errorf("memcache get: unexpected %q", line)
+		}
+		n, err := strconv.Atoi(f[3])
+		if err != nil || n < 0 {
+			return fmt.Errorf("memcache get: unexpected %q", line)
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(conn.r, data); err != nil {
+			return fmt.Errorf("memcache read error: %v", err)
+		}
+		if line, err := conn.readLine(); err != nil || line != "END" {
+			return fmt.Errorf("memcache get: expected END, got %q, %v", line, err)
+		}
+
+		if err := json.Unmarshal(data[:n], &regis); err != nil {
+			return fmt.Errorf("json.Unmarshal error: %v", err)
+		}
+		if regis == nil {
+			regis = []*Registration{}
+		}
+		ok = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return regis, ok, nil
+}
+
+// Set implements RegistrationCache.
+func (c *MemcacheCache) Set(ctx context.Context, key string, regis []*Registration) error {
+	data, err := json.Marshal(regis)
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %v", err)
+	}
+	exptime := int64((c.TTL + time.Second - 1) / time.Second)
+
+	return c.do(ctx, func(conn *memcacheConn) error {
+		cmd := fmt.Sprintf("set %s 0 %d %d", memcacheKey(key), exptime, len(data))
+		line, err := conn.command(cmd, data)
+		if err != nil {
+			return err
+		}
+		if line != "STORED" {
+			return fmt.Errorf("memcache set: %q", line)
+		}
+		return nil
+	})
+}
+
+// Delete implements RegistrationCache.
+func (c *MemcacheCache) Delete(ctx context.Context, key string) error {
+	return c.do(ctx, func(conn *memcacheConn) error {
+		line, err := conn.command("delete "+memcacheKey(key), nil)
+		if err != nil {
+			return err
+		}
+		if line != "DELETED" && line != "NOT_FOUND" {
+			return fmt.Errorf("memcache delete: %q", line)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,380 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tstranex/u2f"
+)
+
+// MemoryStore is a Store in the memory of the process, for tests and for a
+// single server that can afford to lose its registrations on restart.  It
+// ignores the context, so it does not separate tenants.
+type MemoryStore struct {
+	mu            sync.Mutex
+	nextID        int64
+	challenges    map[memoryChallengeKey]u2f.Challenge
+	registrations map[int64]Registration
+	recoveryCodes map[int64]RecoveryCode
+	totps         map[int64]TOTP
+	throttles     map[string]Throttle
+}
+
+type memoryChallengeKey struct {
+	kind, userIdentity string
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		challenges:    map[memoryChallengeKey]u2f.Challenge{},
+		registrations: map[int64]Registration{},
+		recoveryCodes: map[int64]RecoveryCode{},
+		totps:         map[int64]TOTP{},
+		throttles:     map[string]Throttle{},
+	}
+}
+
+// --- newID ---
+// Return an ID unused by any entity.  The caller holds mu.
+func (m *MemoryStore) newID() int64 {
+	m.nextID++
+	return m.nextID
+}
+
+// --- clone ---
+// Return a copy of b, so that callers cannot modify what is stored.
+func clone(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// PutChallenge implements Store.
+func (m *MemoryStore) PutChallenge(ctx context.Context, kind, userIdentity string, c *u2f.Challenge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cc := *c
+	cc.Challenge = clone(c.Challenge)
+	cc.TrustedFacets = append([]string{}, c.TrustedFacets...)
+	m.challenges[memoryChallengeKey{kind, userIdentity}] = cc
+	return nil
+}
+
+// GetChallenge implements Store.
+func (m *MemoryStore) GetChallenge(ctx context.Context, kind, userIdentity string) (*u2f.Challenge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.challenges[memoryChallengeKey{kind, userIdentity}]
+	if !ok {
+		return nil, ErrNoChallenge
+	}
+	c.Challenge = clone(c.Challenge)
+	c.TrustedFacets = append([]string{}, c.TrustedFacets...)
+	return &c, nil
+}
+
+// DeleteChallenge implements Store.
+func (m *MemoryStore) DeleteChallenge(ctx context.Context, kind, userIdentity string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.challenges, memoryChallengeKey{kind, userIdentity})
+	return nil
+}
+
+// CountChallenges implements Store.
+func (m *MemoryStore) CountChallenges(ctx context.Context, kind string, since time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for k, c := range m.challenges {
+		if k.kind == kind && c.Timestamp.After(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// DeleteChallenges implements Store.
+func (m *MemoryStore) DeleteChallenges(ctx context.Context, kind string, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for k, c := range m.challenges {
+		if k.kind == kind && c.Timestamp.Before(before) {
+			delete(m.challenges, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// --- cloneRegistration ---
+// Copy the slices of r, so that it shares none with the caller's.
+func cloneRegistration(r *Registration) {
+	r.U2FRegistrationBytes = clone(r.U2FRegistrationBytes)
+	r.KeyHandle, r.PublicKey = clone(r.KeyHandle), clone(r.PublicKey)
+	r.Properties = clone(r.Properties)
+}
+
+// PutRegistration implements Store.
+func (m *MemoryStore) PutRegistration(ctx context.Context, regi *Registration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := *regi
+	r.ID = m.newID()
+	cloneRegistration(&r)
+	m.registrations[r.ID] = r
+	return r.ID, nil
+}
+
+// Registrations implements Store.
+func (m *MemoryStore) Registrations(ctx context.Context, userIdentity string) ([]*Registration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	regis := []*Registration{}
+	for _, r := range m.registrations {
+		if r.UserIdentity == userIdentity {
+			r := r
+			cloneRegistration(&r)
+			regis = append(regis, &r)
+		}
+	}
+	return regis, nil
+}
+
+// CompareAndSwapCounter implements Store.
+func (m *MemoryStore) CompareAndSwapCounter(ctx context.Context, id, old, counter int64) (*Registration, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.registrations[id]
+	if !ok {
+		return nil, false, ErrNoSuchRegistration
+	}
+
+	cloneRegistration(&r)
+	if !r.Revoked.IsZero() {
+		return &r, false, ErrRevoked
+	}
+
+	swapped := r.Counter == old
+	if swapped {
+		r.Counter = counter
+		m.registrations[id] = r
+	}
+	return &r, swapped, nil
+}
+
+// UpdateRegistration implements Store.  update is called with the store
+// locked, so it must not call the store.
+func (m *MemoryStore) UpdateRegistration(ctx context.Context, userIdentity string, id int64, update func(*Registration) error) (*Registration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.registrations[id]
+	if !ok || r.UserIdentity != userIdentity {
+		return nil, ErrNoSuchRegistration
+	}
+
+	cloneRegistration(&r)
+	if err := update(&r); err != nil {
+		return nil, err
+	}
+	r.ID = id
+	m.registrations[id] = r
+
+	cloneRegistration(&r)
+	return &r, nil
+}
+
+// DeleteRegistration implements Store.
+func (m *MemoryStore) DeleteRegistration(ctx context.Context, userIdentity string, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if r, ok := m.registrations[id]; !ok || r.UserIdentity != userIdentity {
+		return ErrNoSuchRegistration
+	}
+	delete(m.registrations, id)
+	return nil
+}
+
+// ReplaceRecoveryCodes implements Store.
+func (m *MemoryStore) ReplaceRecoveryCodes(ctx context.Context, userIdentity string, rcs []*RecoveryCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, rc := range m.recoveryCodes {
+		if rc.UserIdentity == userIdentity {
+			delete(m.recoveryCodes, id)
+		}
+	}
+	for _, rc := range rcs {
+		c := *rc
+		c.ID = m.newID()
+		c.Salt, c.Hash = clone(rc.Salt), clone(rc.Hash)
+		m.recoveryCodes[c.ID] = c
+	}
+	return nil
+}
+
+// RecoveryCodes implements Store.
+func (m *MemoryStore) RecoveryCodes(ctx context.Context, userIdentity string) ([]*RecoveryCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rcs := []*RecoveryCode{}
+	for _, rc := range m.recoveryCodes {
+		if rc.UserIdentity == userIdentity {
+			rc := rc
+			rc.Salt, rc.Hash = clone(rc.Salt), clone(rc.Hash)
+			rcs = append(rcs, &rc)
+		}
+	}
+	return rcs, nil
+}
+
+// DeleteRecoveryCode implements Store.
+func (m *MemoryStore) DeleteRecoveryCode(ctx context.Context, userIdentity string, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rc, ok := m.recoveryCodes[id]; !ok || rc.UserIdentity != userIdentity {
+		return ErrNoSuchRegistration
+	}
+	delete(m.recoveryCodes, id)
+	return nil
+}
+
+// PutTOTP implements Store.
+func (m *MemoryStore) PutTOTP(ctx context.Context, totp *TOTP) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := *totp
+	t.ID = m.newID()
+	t.Secret = clone(totp.Secret)
+	m.totps[t.ID] = t
+	return t.ID, nil
+}
+
+// TOTPs implements Store.
+func (m *MemoryStore) TOTPs(ctx context.Context, userIdentity string) ([]*TOTP, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	totps := []*TOTP{}
+	for _, t := range m.totps {
+		if t.UserIdentity == userIdentity {
+			t := t
+			t.Secret = clone(t.Secret)
+			totps = append(totps, &t)
+		}
+	}
+	return totps, nil
+}
+
+// UpdateTOTP implements Store.  update is called with the store locked, so
+// it must not call the store.
+func (m *MemoryStore) UpdateTOTP(ctx context.Context, userIdentity string, id int64, update func(*TOTP) error) (*TOTP, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.totps[id]
+	if !ok || t.UserIdentity != userIdentity {
+		return nil, ErrNoSuchRegistration
+	}
+
+	t.Secret = clone(t.Secret)
+	if err := update(&t); err != nil {
+		return nil, err
+	}
+	t.ID = id
+	m.totps[id] = t
+
+	t.Secret = clone(t.Secret)
+	return &t, nil
+}
+
+// DeleteTOTP implements Store.
+func (m *MemoryStore) DeleteTOTP(ctx context.Context, userIdentity string, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.totps[id]; !ok || t.UserIdentity != userIdentity {
+		return ErrNoSuchRegistration
+	}
+	delete(m.totps, id)
+	return nil
+}
+
+// GetThrottle implements Store.
+func (m *MemoryStore) GetThrottle(ctx context.Context, key string) (*Throttle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.throttles[key]
+	return &t, nil
+}
+
+// UpdateThrottle implements Store.  update is called with the store locked,
+// so it must not call the store.
+func (m *MemoryStore) UpdateThrottle(ctx context.Context, key string, update func(*Throttle)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.throttles[key]
+	update(&t)
+	m.throttles[key] = t
+	return nil
+}
+
+// DeleteThrottle implements Store.
+func (m *MemoryStore) DeleteThrottle(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.throttles, key)
+	return nil
+}
+
+// Users implements Store.
+func (m *MemoryStore) Users(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := map[string]bool{}
+	for _, r := range m.registrations {
+		seen[r.UserIdentity] = true
+	}
+	for _, rc := range m.recoveryCodes {
+		seen[rc.UserIdentity] = true
+	}
+	for _, t := range m.totps {
+		seen[t.UserIdentity] = true
+	}
+
+	users := make([]string, 0, len(seen))
+	for u := range seen {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	return users, nil
+}
@@ -0,0 +1,21 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/brianmhunt/aeu2f-go"
+	"github.com/brianmhunt/aeu2f-go/aeu2ftest/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.RunStoreTests(t, func(t *testing.T) (context.Context, aeu2f.Store) {
+		return context.Background(), aeu2f.NewMemoryStore()
+	})
+}
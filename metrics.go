@@ -0,0 +1,263 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/appengine"
+)
+
+// The metrics are kept in memory, per instance; Prometheus sums them across
+// instances.  They are written in the Prometheus text format by
+// MetricsHandler.  (The Prometheus client library is not used, so that it
+// and its dependencies are not imposed on every application of the package,
+// for the few metric types needed here.)
+var (
+	opsTotal = newCounterVec("aeu2f_operations_total",
+		"Operations by outcome and error type.", "op", "outcome", "error")
+	opDuration = newHistogramVec("aeu2f_operation_duration_seconds",
+		"Duration of operations.", "op")
+	storageDuration = newHistogramVec("aeu2f_storage_duration_seconds",
+		"Duration of storage calls.", "call")
+	cacheTotal = newCounterVec("aeu2f_registration_cache_total",
+		"Registration cache lookups by result.", "result")
+)
+
+// defaultBuckets are the upper bounds of histogram buckets, in seconds.
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// --- errorType ---
+// Return the kind of error, for labels.
+func errorType(err error) string {
+	switch err.(type) {
+	case nil:
+		return "none"
+	case *VerificationError:
+		return "verification"
+	case *LockedOutError:
+		return "locked_out"
+	}
+
+	switch err {
+	case ErrNoChallenge:
+		return "no_challenge"
+	case ErrNoSuchRegistration:
+		return "not_found"
+	}
+	return "internal"
+}
+
+// --- observeOp ---
+// Count an operation that started at start.
+func observeOp(op string, start time.Time, err error) {
+	outcome := AuditSuccess
+	if err != nil {
+		outcome = AuditFailure
+	}
+	opsTotal.inc(op, outcome, errorType(err))
+	opDuration.observe(time.Since(start).Seconds(), op)
+}
+
+// labelKey joins label values into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// writeLabels writes e.g. {op="sign",outcome="success"}, with extra
+// appended.
+func writeLabels(w io.Writer, names []string, key string, extra ...string) {
+	values := strings.Split(key, "\xff")
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, name+"="+quoteLabel(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+quoteLabel(extra[i+1]))
+	}
+	if len(pairs) > 0 {
+		fmt.Fprintf(w, "{%s}", strings.Join(pairs, ","))
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+// counterVec is a counter with labels.
+type counterVec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.mu.Lock()
+	c.values[labelKey(labelValues)]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := []string{}
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		io.WriteString(w, c.name)
+		writeLabels(w, c.labels, k)
+		fmt.Fprintf(w, " %v\n", c.values[k])
+	}
+}
+
+// histogram is a single series of a histogramVec.
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// histogramVec is a histogram with labels.
+type histogramVec struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*histogram
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	return &histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: defaultBuckets,
+		series:  map[string]*histogram{},
+	}
+}
+
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	k := labelKey(labelValues)
+	s := h.series[k]
+	if s == nil {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+
+	for i, le := range h.buckets {
+		if v <= le {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := []string{}
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			io.WriteString(w, h.name+"_bucket")
+			writeLabels(w, h.labels, k, "le", fmt.Sprint(le))
+			fmt.Fprintf(w, " %v\n", cumulative)
+		}
+		io.WriteString(w, h.name+"_bucket")
+		writeLabels(w, h.labels, k, "le", "+Inf")
+		fmt.Fprintf(w, " %v\n", s.count)
+
+		io.WriteString(w, h.name+"_sum")
+		writeLabels(w, h.labels, k)
+		fmt.Fprintf(w, " %v\n", s.sum)
+		io.WriteString(w, h.name+"_count")
+		writeLabels(w, h.labels, k)
+		fmt.Fprintf(w, " %v\n", s.count)
+	}
+}
+
+// --- outstandingChallenges ---
+// Return the number of unexpired challenges of the given kind, which have
+// not been answered successfully.
+func outstandingChallenges(ctx context.Context, kind string) (_ int, err error) {
+	ctx, end := startStorage(ctx, "outstandingChallenges")
+	defer func() { end(err) }()
+
+	since := time.Now().Add(-time.Duration(ChallengeTimeout) * time.Millisecond)
+	return Storage.CountChallenges(ctx, kind, since)
+}
+
+
+// MetricsHandler serves the metrics in the Prometheus text format, e.g.
+// 	http.Handle("/metrics", &aeu2f.MetricsHandler{})
+//
+// The outstanding challenges gauge is counted from the Storage on each
+// scrape, so it is the same for every instance.
+type MetricsHandler struct {
+	// Context returns the context.Context for the request.  It defaults to
+	// appengine.NewContext.
+	Context func(r *http.Request) context.Context
+}
+
+// ServeHTTP implements http.Handler.
+func (m *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	newContext := m.Context
+	if newContext == nil {
+		newContext = appengine.NewContext
+	}
+	ctx := newContext(r)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	opsTotal.write(w)
+	opDuration.write(w)
+	storageDuration.write(w)
+	cacheTotal.write(w)
+
+	io.WriteString(w, "# HELP aeu2f_outstanding_challenges Unexpired, unanswered challenges.\n")
+	io.WriteString(w, "# TYPE aeu2f_outstanding_challenges gauge\n")
+	for _, kind := range []string{ChallengeRegister, ChallengeSign} {
+		n, err := outstandingChallenges(ctx, kind)
+		if err != nil {
+			Log.Error("metrics", "op", "metrics", "error", err.Error())
+			continue
+		}
+		fmt.Fprintf(w, "aeu2f_outstanding_challenges{kind=%s} %v\n", quoteLabel(kind), n)
+	}
+}
@@ -0,0 +1,102 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tstranex/u2f"
+)
+
+func TestErrorType(t *testing.T) {
+	cases := map[string]error{
+		"none":         nil,
+		"verification": &VerificationError{"Sign", errors.New("bad")},
+		"locked_out":   &LockedOutError{},
+		"no_challenge": ErrNoChallenge,
+		"not_found":    ErrNoSuchRegistration,
+		"internal":     errors.New("datastore down"),
+	}
+	for want, err := range cases {
+		if got := errorType(err); got != want {
+			t.Errorf("errorType(%v) = %v, expected %v", err, got, want)
+		}
+	}
+}
+
+func TestHistogramWrite(t *testing.T) {
+	h := newHistogramVec("test_seconds", "Test.", "op")
+	h.buckets = []float64{.1, 1}
+	h.observe(.05, "a")
+	h.observe(.5, "a")
+	h.observe(5, "a")
+
+	var buf bytes.Buffer
+	h.write(&buf)
+	want := `# HELP test_seconds Test.
+# TYPE test_seconds histogram
+test_seconds_bucket{op="a",le="0.1"} 1
+test_seconds_bucket{op="a",le="1"} 2
+test_seconds_bucket{op="a",le="+Inf"} 3
+test_seconds_sum{op="a"} 5.55
+test_seconds_count{op="a"} 3
+`
+	if buf.String() != want {
+		t.Errorf("Expected\n%v\ngot\n%v", want, buf.String())
+	}
+}
+
+func TestCounterWriteEscapes(t *testing.T) {
+	c := newCounterVec("test_total", "Test.", "op")
+	c.inc(`a"b\c`)
+	c.inc(`a"b\c`)
+
+	var buf bytes.Buffer
+	c.write(&buf)
+	if !strings.Contains(buf.String(), `test_total{op="a\"b\\c"} 2`) {
+		t.Errorf("Unexpected output %v", buf.String())
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	ctx, done := newConsistentContext(t)
+	defer done()
+
+	if _, err := NewRegistrationChallenge(ctx, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Sign(ctx, "bob", u2f.SignResponse{}); err != ErrNoChallenge {
+		t.Fatalf("Expected ErrNoChallenge, got %v", err)
+	}
+
+	h := &MetricsHandler{Context: func(*http.Request) context.Context { return ctx }}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := w.Body.String()
+	for _, line := range []string{
+		`aeu2f_operations_total{op="registration.challenge",outcome="success",error="none"}`,
+		`aeu2f_operations_total{op="sign",outcome="failure",error="no_challenge"}`,
+		`aeu2f_operation_duration_seconds_count{op="sign"}`,
+		`aeu2f_storage_duration_seconds_count{call="putChallenge"}`,
+		`aeu2f_outstanding_challenges{kind="Challenge"} 1`,
+		`aeu2f_outstanding_challenges{kind="SignChallenge"} 0`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("Expected %v in\n%v", line, body)
+		}
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected Content-Type %v", ct)
+	}
+}
@@ -0,0 +1,203 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// U2FLibDevice is a device as registered with python-u2flib-server: its
+// DeviceRegistration JSON, i.e. appId, keyHandle and publicKey, with the
+// user, and the certificate and counter, which the library leaves to the
+// application to store.
+type U2FLibDevice struct {
+	UserIdentity string `json:"user"`
+	AppID        string `json:"appId"`
+	KeyHandle    string `json:"keyHandle"`
+	PublicKey    string `json:"publicKey"`
+	Certificate  string `json:"certificate"`
+	Counter      int64  `json:"counter"`
+}
+
+// U2FValDevice is a device as stored by Yubico's u2fval: a row of its
+// devices table, with the name of its user.  BindData is the JSON of the
+// device's DeviceRegistration, as for U2FLibDevice.
+type U2FValDevice struct {
+	UserIdentity string                 `json:"user"`
+	BindData     string                 `json:"bind_data"`
+	Certificate  string                 `json:"certificate"`
+	Counter      int64                  `json:"counter"`
+	CreatedAt    string                 `json:"created_at"`
+	Properties   map[string]interface{} `json:"properties"`
+}
+
+// u2fvalTimes are the layouts of u2fval's created_at, as the database or
+// JSON gives it.
+var u2fvalTimes = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999"}
+
+// ImportU2FLibDevices imports the devices of python-u2flib-server, as
+// U2FLibDevice JSON values, either in an array or one after another.  Each
+// device is checked and imported as by ImportRegistrations, and a device
+// that cannot be, e.g. for another AppID or with a public key that does not
+// parse, is reported as rejected.
+func ImportU2FLibDevices(ctx context.Context, r io.Reader) (*ImportReport, error) {
+	return importForeign(ctx, r, func(raw json.RawMessage) (*ExportedRegistration, error) {
+		var d U2FLibDevice
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, err
+		}
+		return foreignRegistration(ctx, d.UserIdentity, d.AppID, d.KeyHandle, d.PublicKey, d.Certificate, d.Counter)
+	})
+}
+
+// ImportU2FValDevices imports the devices of u2fval, as U2FValDevice JSON
+// values, as ImportU2FLibDevices.  The properties of a device become those
+// of its registration, and a "name" property also its Name.
+func ImportU2FValDevices(ctx context.Context, r io.Reader) (*ImportReport, error) {
+	return importForeign(ctx, r, func(raw json.RawMessage) (*ExportedRegistration, error) {
+		var d U2FValDevice
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, err
+		}
+		var bind U2FLibDevice
+		if err := json.Unmarshal([]byte(d.BindData), &bind); err != nil {
+			return nil, fmt.Errorf("bind_data: %v", err)
+		}
+
+		x, err := foreignRegistration(ctx, d.UserIdentity, bind.AppID, bind.KeyHandle, bind.PublicKey, d.Certificate, d.Counter)
+		if err != nil {
+			return nil, err
+		}
+		if name, ok := d.Properties["name"].(string); ok {
+			x.Name = name
+		}
+		if len(d.Properties) > 0 {
+			if x.Properties, err = json.Marshal(d.Properties); err != nil {
+				return nil, fmt.Errorf("properties: %v", err)
+			}
+		}
+		if d.CreatedAt != "" {
+			for _, layout := range u2fvalTimes {
+				if t, err := time.Parse(layout, d.CreatedAt); err == nil {
+					x.Created = t
+					break
+				}
+			}
+			if x.Created.IsZero() {
+				return nil, fmt.Errorf("created_at %q", d.CreatedAt)
+			}
+		}
+		return x, nil
+	})
+}
+
+// --- importForeign ---
+// Import the JSON values of r, an array of them or one after another, each
+// converted by convert.  A value convert refuses is reported as rejected.
+func importForeign(ctx context.Context, r io.Reader, convert func(json.RawMessage) (*ExportedRegistration, error)) (*ImportReport, error) {
+	br := bufio.NewReader(r)
+	dec := json.NewDecoder(br)
+	report := &ImportReport{}
+
+	// An array is read an element at a time, like a stream of values.
+	array := false
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			return report, nil
+		} else if err != nil {
+			return report, err
+		}
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			continue
+		}
+		br.UnreadByte()
+		if array = c == '['; array {
+			dec.Token()
+		}
+		break
+	}
+
+	for record := 1; ; record++ {
+		if array && !dec.More() {
+			return report, nil
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF && !array {
+			return report, nil
+		} else if err != nil {
+			return report, fmt.Errorf("record %v: %v", record, err)
+		}
+
+		x, err := convert(raw)
+		if err != nil {
+			var who struct {
+				UserIdentity string `json:"user"`
+			}
+			json.Unmarshal(raw, &who)
+			report.Rejected = append(report.Rejected, ImportProblem{
+				Record:       record,
+				UserIdentity: who.UserIdentity,
+				Reason:       err.Error(),
+			})
+			continue
+		}
+		if err := importRegistration(ctx, report, record, x); err != nil {
+			return report, err
+		}
+	}
+}
+
+// --- foreignRegistration ---
+// Return the registration of a foreign device, from its fields.  Key
+// handles and public keys are in websafe base64, as U2F has them; the
+// certificate may also be in standard base64, or PEM.
+func foreignRegistration(ctx context.Context, userIdentity, foreignAppID, keyHandle, publicKey, certificate string, counter int64) (*ExportedRegistration, error) {
+	if want, _ := appID(ctx); want != "" && foreignAppID != want {
+		return nil, fmt.Errorf("app ID %q, not %q", foreignAppID, want)
+	}
+	x := &ExportedRegistration{UserIdentity: userIdentity, Counter: counter}
+
+	var err error
+	if x.KeyHandle, err = decodeForeign(keyHandle); err != nil {
+		return nil, fmt.Errorf("keyHandle: %v", err)
+	}
+	if x.PublicKey, err = decodeForeign(publicKey); err != nil {
+		return nil, fmt.Errorf("publicKey: %v", err)
+	}
+
+	if block, _ := pem.Decode([]byte(certificate)); block != nil {
+		x.Certificate = block.Bytes
+	} else if certificate != "" {
+		if x.Certificate, err = decodeForeign(certificate); err != nil {
+			return nil, fmt.Errorf("certificate: %v", err)
+		}
+	}
+	return x, nil
+}
+
+// --- decodeForeign ---
+// Decode base64, websafe or standard, with or without padding.
+func decodeForeign(s string) ([]byte, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "=")
+	if s == "" {
+		return nil, errors.New("missing")
+	}
+	if strings.ContainsAny(s, "+/") {
+		return base64.RawStdEncoding.DecodeString(s)
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
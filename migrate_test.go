@@ -0,0 +1,150 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportU2FLibDevices(t *testing.T) {
+	ctx := setupMemoryStore(t, testAppID)
+
+	a, req := registerAuthenticator(t, ctx, "bob")
+	x, err := exportRegistration(ctx, mustList(t, ctx, "bob")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := U2FLibDevice{
+		UserIdentity: "bob",
+		AppID:        testAppID,
+		KeyHandle:    base64.RawURLEncoding.EncodeToString(x.KeyHandle),
+		PublicKey:    base64.RawURLEncoding.EncodeToString(x.PublicKey),
+		Certificate:  base64.URLEncoding.EncodeToString(x.Certificate),
+		Counter:      3,
+	}
+
+	// devices returns the JSON array of device, changed by each f.
+	devices := func(fs ...func(d *U2FLibDevice)) string {
+		ds := []U2FLibDevice{}
+		for _, f := range fs {
+			d := device
+			f(&d)
+			ds = append(ds, d)
+		}
+		b, _ := json.Marshal(ds)
+		return string(b)
+	}
+	input := devices(
+		func(d *U2FLibDevice) {},
+		func(d *U2FLibDevice) { d.UserIdentity, d.PublicKey = "alice", d.PublicKey[:20] },
+		func(d *U2FLibDevice) { d.UserIdentity, d.AppID = "alice", "https://other.example.com" },
+		func(d *U2FLibDevice) { d.UserIdentity, d.KeyHandle = "alice", "not base64!" },
+		func(d *U2FLibDevice) { d.UserIdentity, d.Certificate = "alice", "" },
+	)
+
+	Storage = NewMemoryStore()
+	report, err := ImportU2FLibDevices(ctx, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Added != 2 || len(report.Rejected) != 3 || len(report.Conflicts) != 0 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	for i, p := range report.Rejected {
+		if p.Record != i+2 || p.UserIdentity != "alice" || p.Reason == "" {
+			t.Errorf("Expected record %v to be rejected, got %+v", i+2, p)
+		}
+	}
+
+	regis := mustList(t, ctx, "bob")
+	if len(regis) != 1 || regis[0].Counter != 3 {
+		t.Fatalf("Expected bob's device to be imported, got %+v", regis)
+	}
+	if _, err := regis[0].AttestationCertificate(); err != nil {
+		t.Errorf("Expected the certificate to be imported, got %v", err)
+	}
+	if regis := mustList(t, ctx, "alice"); len(regis) != 1 || len(regis[0].U2FRegistrationBytes) != 0 {
+		t.Errorf("Expected alice's device to be imported without a certificate, got %+v", regis)
+	}
+
+	// The imported device signs, with a counter above the imported one.
+	a.Counter = 10
+	reqs, err := NewSignChallenge(ctx, "bob")
+	if err != nil || len(reqs) != 1 || reqs[0].KeyHandle != req.KeyHandle {
+		t.Fatalf("Expected a sign request for the device, got %v, %v", reqs, err)
+	}
+	if err := Sign(ctx, "bob", sign(t, a, reqs[0])); err != nil {
+		t.Errorf("Expected the imported device to sign, got %v", err)
+	}
+
+	// Importing again changes nothing.
+	report, err = ImportU2FLibDevices(ctx, strings.NewReader(input))
+	if err != nil || report.Added != 0 || report.Unchanged != 2 {
+		t.Errorf("Expected the import to be idempotent, got %+v, %v", report, err)
+	}
+}
+
+func TestImportU2FValDevices(t *testing.T) {
+	ctx := setupMemoryStore(t, testAppID)
+
+	registerAuthenticator(t, ctx, "bob")
+	x, err := exportRegistration(ctx, mustList(t, ctx, "bob")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	bind, _ := json.Marshal(U2FLibDevice{
+		AppID:     testAppID,
+		KeyHandle: base64.RawURLEncoding.EncodeToString(x.KeyHandle),
+		PublicKey: base64.RawURLEncoding.EncodeToString(x.PublicKey),
+	})
+	row := func(user, cert, created string) string {
+		b, _ := json.Marshal(U2FValDevice{
+			UserIdentity: user,
+			BindData:     string(bind),
+			Certificate:  cert,
+			Counter:      5,
+			CreatedAt:    created,
+			Properties:   map[string]interface{}{"name": "yubikey of " + user},
+		})
+		return string(b) + "\n"
+	}
+
+	pemCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: x.Certificate}))
+	input := row("bob", base64.StdEncoding.EncodeToString(x.Certificate), "2016-05-01 12:00:00.123456") +
+		row("alice", pemCert, "2016-05-01T12:00:00Z") +
+		row("carol", "", "yesterday") +
+		`{"user": "dave", "bind_data": "{}", "counter": 1}` + "\n"
+
+	Storage = NewMemoryStore()
+	report, err := ImportU2FValDevices(ctx, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Added != 2 || len(report.Rejected) != 2 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+
+	want := time.Date(2016, 5, 1, 12, 0, 0, 123456000, time.UTC)
+	regis := mustList(t, ctx, "bob")
+	if len(regis) != 1 || regis[0].Name != "yubikey of bob" || string(regis[0].Properties) != `{"name":"yubikey of bob"}` || regis[0].Counter != 5 || !regis[0].Created.Equal(want) {
+		t.Errorf("Unexpected imported registration %+v", regis)
+	}
+	if regis := mustList(t, ctx, "alice"); len(regis) != 1 {
+		t.Errorf("Expected alice's device, with a PEM certificate, to be imported, got %+v", regis)
+	} else if _, err := regis[0].AttestationCertificate(); err != nil {
+		t.Errorf("Expected alice's certificate to be imported, got %v", err)
+	}
+
+	if _, err := ImportU2FValDevices(ctx, strings.NewReader(`{"user": "bob"`)); err == nil {
+		t.Error("Expected malformed JSON to stop the import")
+	}
+}
@@ -0,0 +1,163 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RecoveryCodeCount is the number of codes generated by NewRecoveryCodes.
+var RecoveryCodeCount = 10
+
+// recoveryEncoding is lower-case base32, so codes are easy to read out and
+// type; they are compared case-insensitively.
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567")
+
+// RecoveryCode stores one unused recovery code.  Only a salted hash of the
+// code is stored.
+type RecoveryCode struct {
+	// ID is the Store's ID of the code; it is not stored.
+	ID int64 `datastore:"-"`
+
+	UserIdentity string
+	Salt         []byte `datastore:",noindex"`
+	Hash         []byte `datastore:",noindex"`
+	Created      time.Time
+}
+
+// recoveryCodeBytes is the entropy of a recovery code: 80 bits, so that
+// even with its salt and hash, a stolen RecoveryCode cannot be brute-forced.
+const recoveryCodeBytes = 10
+
+// --- newRecoveryCode ---
+// Return a random code, formatted as e.g. "abcd-efgh-ijkl-mnop", with its
+// hash.
+func newRecoveryCode(userIdentity string) (string, *RecoveryCode, error) {
+	buf := make([]byte, 16+recoveryCodeBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, fmt.Errorf("rand.Read error: %v", err)
+	}
+
+	// 16 characters, of 5 bits each.
+	code := recoveryEncoding.EncodeToString(buf[16:])
+	rc := &RecoveryCode{
+		UserIdentity: userIdentity,
+		Salt:         buf[:16],
+		Created:      time.Now(),
+	}
+	rc.Hash = rc.hash(code)
+	return code[:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:], rc, nil
+}
+
+// hash returns the salted hash of a normalized code.
+func (rc *RecoveryCode) hash(code string) []byte {
+	h := sha256.New()
+	h.Write(rc.Salt)
+	h.Write([]byte(code))
+	return h.Sum(nil)
+}
+
+// normalizeRecoveryCode removes the separators and whitespace a user may
+// type, and lower-cases the code.
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
+
+// loadRecoveryCodes returns the unused recovery codes for a user.
+func loadRecoveryCodes(ctx context.Context, userIdentity string) (_ []*RecoveryCode, err error) {
+	ctx, end := startStorage(ctx, "loadRecoveryCodes")
+	defer func() { end(err) }()
+
+	return Storage.RecoveryCodes(ctx, userIdentity)
+}
+
+
+// NewRecoveryCodes generates a new set of RecoveryCodeCount one-time codes
+// for the user, replacing any existing ones.  The codes are returned to be
+// shown to the user once; they cannot be retrieved later.
+func NewRecoveryCodes(ctx context.Context, userIdentity string) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	rcs := make([]*RecoveryCode, RecoveryCodeCount)
+	for i := range codes {
+		code, rc, err := newRecoveryCode(userIdentity)
+		if err != nil {
+			return nil, err
+		}
+		codes[i], rcs[i] = code, rc
+	}
+
+	if err := Storage.ReplaceRecoveryCodes(ctx, userIdentity, rcs); err != nil {
+		return nil, err
+	}
+
+	Log.Info("recovery_code.new", "op", "recovery_code.new", "user", logUser(userIdentity))
+	return codes, nil
+}
+
+
+// UseRecoveryCode verifies a recovery code, as an alternative to Sign, and
+// burns it so it cannot be used again.  Failures are throttled as for Sign.
+func UseRecoveryCode(ctx context.Context, userIdentity, code string) error {
+	ctx, start := begin(ctx, AuditRecoveryCode, userIdentity)
+	err := throttled(ctx, userIdentity, func() error {
+		return useRecoveryCode(ctx, userIdentity, code)
+	})
+	finish(ctx, AuditRecoveryCode, userIdentity, 0, start, err)
+	return err
+}
+
+// --- useRecoveryCode ---
+//
+func useRecoveryCode(ctx context.Context, userIdentity, code string) error {
+	code = normalizeRecoveryCode(code)
+
+	rcs, err := loadRecoveryCodes(ctx, userIdentity)
+	if err != nil {
+		return fmt.Errorf("loadRecoveryCodes error %+v", err)
+	}
+
+	var match *RecoveryCode
+	for _, rc := range rcs {
+		if subtle.ConstantTimeCompare(rc.hash(code), rc.Hash) == 1 {
+			match = rc
+		}
+	}
+	if match == nil {
+		return &VerificationError{"UseRecoveryCode", errors.New("invalid recovery code")}
+	}
+
+	// Burn the code, unless a concurrent request already has.
+	err = Storage.DeleteRecoveryCode(ctx, userIdentity, match.ID)
+	if err == ErrNoSuchRegistration {
+		return &VerificationError{"UseRecoveryCode", errors.New("recovery code already used")}
+	}
+	return err
+}
+
+
+// RecoveryCodesRemaining returns the number of unused recovery codes the
+// user has.
+func RecoveryCodesRemaining(ctx context.Context, userIdentity string) (int, error) {
+	rcs, err := loadRecoveryCodes(ctx, userIdentity)
+	if err != nil {
+		return 0, err
+	}
+	return len(rcs), nil
+}
@@ -0,0 +1,90 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	if got := normalizeRecoveryCode(" ABCDE-fghij\t"); got != "abcdefghij" {
+		t.Errorf("Unexpected normalized code %q", got)
+	}
+}
+
+func TestNewRecoveryCode(t *testing.T) {
+	code, rc, err := newRecoveryCode("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 19 || strings.Count(code, "-") != 3 {
+		t.Errorf("Expected a code like abcd-efgh-ijkl-mnop, got %q", code)
+	}
+	if n := len(normalizeRecoveryCode(code)) * 5; n < 80 {
+		t.Errorf("Expected at least 80 bits, got %v", n)
+	}
+	if string(rc.hash(normalizeRecoveryCode(code))) != string(rc.Hash) {
+		t.Error("Expected the hash to be of the normalized code")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	ctx, done := newConsistentContext(t)
+	defer done()
+
+	var testID = "test-id-🔒"
+
+	codes, err := NewRecoveryCodes(ctx, testID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("Expected %v codes, got %v", RecoveryCodeCount, len(codes))
+	}
+
+	// Only the hash is stored.
+	rcs, err := loadRecoveryCodes(ctx, testID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rc := range rcs {
+		if strings.Contains(string(rc.Hash), normalizeRecoveryCode(codes[0])) {
+			t.Error("Expected the code not to be stored")
+		}
+	}
+
+	if err := UseRecoveryCode(ctx, testID, strings.ToUpper(codes[0])); err != nil {
+		t.Fatalf("UseRecoveryCode: %v", err)
+	}
+	if n, err := RecoveryCodesRemaining(ctx, testID); err != nil || n != RecoveryCodeCount-1 {
+		t.Errorf("Expected %v codes remaining, got %v (%v)", RecoveryCodeCount-1, n, err)
+	}
+
+	// Burnt, wrong and other users' codes are rejected.
+	for _, tc := range []struct{ user, code string }{
+		{testID, codes[0]},
+		{testID, "aaaa-aaaa-aaaa-aaaa"},
+		{"someone-else", codes[1]},
+	} {
+		err := UseRecoveryCode(ctx, tc.user, tc.code)
+		if _, ok := err.(*VerificationError); !ok {
+			t.Errorf("UseRecoveryCode(%v, %v): expected VerificationError, got %v", tc.user, tc.code, err)
+		}
+	}
+
+	// Regenerating replaces the old codes.
+	if _, err := NewRecoveryCodes(ctx, testID); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := RecoveryCodesRemaining(ctx, testID); n != RecoveryCodeCount {
+		t.Errorf("Expected %v codes after regenerating, got %v", RecoveryCodeCount, n)
+	}
+	if err := UseRecoveryCode(ctx, testID, codes[1]); err == nil {
+		t.Error("Expected an old code to be rejected")
+	}
+}
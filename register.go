@@ -9,24 +9,48 @@
 package aeu2f
 
 import (
-	"log"
+	"context"
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
-	"appengine"
-	"appengine/datastore"
+	"google.golang.org/appengine/datastore"
 
 	"github.com/tstranex/u2f"
 )
 
 // Registration stores the response to a registration challenge.
 type Registration struct {
+	// ID is the Store's ID of the registration; it is not stored.
+	ID int64 `datastore:"-"`
+
 	UserIdentity string
 	U2FRegistrationBytes []byte
 
+	// KeyHandle and PublicKey, an uncompressed P-256 point, are parsed from
+	// U2FRegistrationBytes when the key is registered, so that signing need
+	// not parse the attestation certificate.  Registrations stored before
+	// they were added are parsed on each use instead.
+	KeyHandle []byte
+	PublicKey []byte
+
 	// u2f.sign takes a uint32, but appengine does not store uints.
 	Counter int64
 	Created time.Time
+
+	// Name is a label for the key, e.g. set by an administrator.
+	Name string
+
+	// Revoked is when the key was revoked, or zero.  A revoked key is kept,
+	// but cannot sign.
+	Revoked time.Time
+
+	// Properties is a JSON object of properties set by the application,
+	// e.g. through aeu2fval, or nil.
+	Properties []byte
 }
 
 // AppID identifies this application.  Must be set to the hostname.
@@ -35,6 +59,31 @@ var AppID string
 // TrustedFacets is the list of U2F trusted facets
 var TrustedFacets []string
 
+type appIDContextKey struct{}
+
+// appIDs is the AppID and TrustedFacets given to WithAppID.
+type appIDs struct {
+	appID         string
+	trustedFacets []string
+}
+
+// WithAppID returns a copy of ctx whose challenges are for appID and
+// trustedFacets, rather than AppID and TrustedFacets, e.g. to serve several
+// applications.  A response is verified against its challenge's, so only
+// the challenge needs the context.
+func WithAppID(ctx context.Context, appID string, trustedFacets []string) context.Context {
+	return context.WithValue(ctx, appIDContextKey{}, appIDs{appID, trustedFacets})
+}
+
+// --- appID ---
+// Return the AppID and TrustedFacets of ctx.
+func appID(ctx context.Context) (string, []string) {
+	if ids, ok := ctx.Value(appIDContextKey{}).(appIDs); ok {
+		return ids.appID, ids.trustedFacets
+	}
+	return AppID, TrustedFacets
+}
+
 // ChallengeTimeout is the time within which a user must respond to a
 // U2F registration challenge.
 var ChallengeTimeout = 1000 * 60  // milliseconds
@@ -42,16 +91,79 @@ var ChallengeTimeout = 1000 * 60  // milliseconds
 
 // MakeParentKey returns a Key to be used as the parent for the model, to
 // enforce strong consistency.
-func MakeParentKey(ctx appengine.Context) *datastore.Key {
+func MakeParentKey(ctx context.Context) *datastore.Key {
 	return datastore.NewKey(ctx, "U2F", "Registration", 0, nil)
 }
 
 
 // makeKey creates a key for a strongly consistent model.
-func makeKey(ctx appengine.Context, stringKey, kind string) *datastore.Key {	parent := MakeParentKey(ctx)
+func makeKey(ctx context.Context, stringKey, kind string) *datastore.Key {	parent := MakeParentKey(ctx)
 	return datastore.NewKey(ctx, kind, stringKey, 0, parent)
 }
 
+// Client data types, as set by the browser.
+const (
+	clientDataRegister = "navigator.id.finishEnrollment"
+	clientDataSign     = "navigator.id.getAssertion"
+)
+
+// --- checkClientData ---
+// Check the response's client data is of the given type.  u2f verifies the
+// challenge and origin, but not the type, so e.g. the client data of a sign
+// response would otherwise be accepted for a registration.
+func checkClientData(clientData, typ string) error {
+	s := clientData
+	for i := 0; i < len(s)%4; i++ {
+		s += "="
+	}
+	buf, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("client data: %v", err)
+	}
+
+	var cd u2f.ClientData
+	if err := json.Unmarshal(buf, &cd); err != nil {
+		return fmt.Errorf("client data: %v", err)
+	}
+	if cd.Typ != typ {
+		return errors.New("client data: wrong type")
+	}
+	return nil
+}
+
+// --- putChallenge ---
+// Store the challenge of the given kind, ChallengeRegister or ChallengeSign,
+// for the user, replacing any earlier one.
+func putChallenge(ctx context.Context, kind, userIdentity string, c *u2f.Challenge) (err error) {
+	ctx, end := startStorage(ctx, "putChallenge")
+	defer func() { end(err) }()
+
+	return Storage.PutChallenge(ctx, kind, userIdentity, c)
+}
+
+// --- getChallenge ---
+// Load the user's challenge of the given kind, or ErrNoChallenge.
+func getChallenge(ctx context.Context, kind, userIdentity string) (_ *u2f.Challenge, err error) {
+	ctx, end := startStorage(ctx, "getChallenge")
+	defer func() { end(err) }()
+
+	return Storage.GetChallenge(ctx, kind, userIdentity)
+}
+
+// --- consumeChallenge ---
+// Delete the user's challenge once it has been answered, so it cannot be
+// answered again.  The operation has already succeeded, so a failure is only
+// logged.
+func consumeChallenge(ctx context.Context, kind, userIdentity string) {
+	ctx, end := startStorage(ctx, "consumeChallenge")
+	err := Storage.DeleteChallenge(ctx, kind, userIdentity)
+	end(err)
+
+	if err != nil {
+		Log.Error("challenge.consume", "op", "challenge.consume", "user", logUser(userIdentity), "error", err.Error())
+	}
+}
+
 
 // NewRegistrationChallenge creates a new U2F challenge and stores it in the
 // datastore.
@@ -59,23 +171,22 @@ func makeKey(ctx appengine.Context, stringKey, kind string) *datastore.Key {	par
 // Encode the response with e.g.
 // 	 json.NewEncoder(w).Encode(req)
 //
-func NewRegistrationChallenge(ctx appengine.Context, userIdentity string) (*u2f.RegisterRequest, error) {
+func NewRegistrationChallenge(ctx context.Context, userIdentity string) (_ *u2f.RegisterRequest, err error) {
+	ctx, start := begin(ctx, AuditRegistrationChallenge, userIdentity)
+	defer func() { finish(ctx, AuditRegistrationChallenge, userIdentity, 0, start, err) }()
+
 	// Generate a challenge
-	c, err := u2f.NewChallenge(AppID, TrustedFacets); if err != nil {
+	c, err := u2f.NewChallenge(appID(ctx)); if err != nil {
 		return nil, fmt.Errorf("u2f.NewChallenge error: %v", err)
 	}
 
 	// Save challenge to database.
-	ckey := makeKey(ctx, userIdentity, "Challenge")
-	if _, err := datastore.Put(ctx, ckey, c); err != nil {
-		return nil, fmt.Errorf("datastore.Put error: %v", err)
+	if err := putChallenge(ctx, ChallengeRegister, userIdentity, c); err != nil {
+		return nil, err
 	}
 
 	// Return challenge request
-	req := c.RegisterRequest()
-	log.Printf("🍁  New Registration Challenge for %v: %+v [%+v]",
-		userIdentity, req, ckey)
-	return req, nil
+	return c.RegisterRequest(), nil
 }
 
 
@@ -87,36 +198,73 @@ func NewRegistrationChallenge(ctx appengine.Context, userIdentity string) (*u2f.
 // 		http.Error(w, "invalid response: "+err.Error(), http.StatusBadRequest)
 // 		return
 // 	}
-func StoreResponse(ctx appengine.Context, userIdentity string, resp u2f.RegisterResponse) error {
+func StoreResponse(ctx context.Context, userIdentity string, resp u2f.RegisterResponse) error {
+	_, err := Register(ctx, userIdentity, resp)
+	return err
+}
+
+// Register checks the response to a registration challenge, like
+// StoreResponse, and returns the new registration, with its ID.
+func Register(ctx context.Context, userIdentity string, resp u2f.RegisterResponse) (_ *Registration, err error) {
+	var id int64
+	ctx, start := begin(ctx, AuditRegistration, userIdentity)
+	defer func() { finish(ctx, AuditRegistration, userIdentity, id, start, err) }()
+
 	// Load the most recent challenge.
-	ckey := makeKey(ctx, userIdentity, "Challenge")
+	challenge, err := getChallenge(ctx, ChallengeRegister, userIdentity)
+	if err != nil {
+		return nil, err
+	}
 
-	var challenge u2f.Challenge
-  if err := datastore.Get(ctx, ckey, &challenge); err != nil {
-    return fmt.Errorf("datastore.Get error: %v", err)
-  }
+	if err := checkClientData(resp.ClientData, clientDataRegister); err != nil {
+		return nil, &VerificationError{"u2f.Register", err}
+	}
 
-	reg, err := u2f.Register(resp, challenge, &u2f.Config{true})
+	_, end := startSpan(ctx, "u2f.register")
+	reg, err := u2f.Register(resp, *challenge, &u2f.Config{SkipAttestationVerify: true})
+	end(err)
 	if err != nil {
-		return fmt.Errorf("u2f.Register error: %v", err)
+		return nil, &VerificationError{"u2f.Register", err}
 	}
 
 	buf, err := reg.MarshalBinary()
 	if err != nil {
-		return fmt.Errorf("reg.MarshalBinary error: %v", err)
+		return nil, fmt.Errorf("reg.MarshalBinary error: %v", err)
 	}
 
-	// Save the registration in the datastore
-	regi := Registration{UserIdentity: userIdentity, Counter: 0, U2FRegistrationBytes: buf, Created: time.Now()}
-	// We set the stringKey to 0, because the user identity is not part of the
-	// key.  We look up registrations by a datastore query, since there might
-	// be multiple.
-	k := makeKey(ctx, "", "Registration")
-  if _, err := datastore.Put(ctx, k, &regi); err != nil {
-    return fmt.Errorf("datastore.Put error: %v", err)
+	// Save the registration
+	regi := Registration{
+		UserIdentity:         userIdentity,
+		U2FRegistrationBytes: buf,
+		KeyHandle:            reg.KeyHandle,
+		PublicKey:            elliptic.Marshal(elliptic.P256(), reg.PubKey.X, reg.PubKey.Y),
+		Counter:              0,
+		Created:              time.Now(),
 	}
+	id, err = putRegistration(ctx, &regi)
+	if err != nil {
+		return nil, err
+	}
+	regi.ID = id
 
-	log.Printf("🍁  Registered: %+v [%+v]", userIdentity, k)
+	consumeChallenge(ctx, ChallengeRegister, userIdentity)
 
-	return nil
+	return &regi, nil
+}
+
+
+// ListRegistrations returns the registrations for the given user, with their
+// ID set.
+func ListRegistrations(ctx context.Context, userIdentity string) ([]*Registration, error) {
+	return loadRegistrations(ctx, userIdentity)
+}
+
+
+// DeleteRegistration removes the registration with the given ID, provided it
+// belongs to the given user.
+func DeleteRegistration(ctx context.Context, userIdentity string, id int64) (err error) {
+	ctx, start := begin(ctx, AuditDeletion, userIdentity)
+	defer func() { finish(ctx, AuditDeletion, userIdentity, id, start, err) }()
+
+	return Storage.DeleteRegistration(ctx, userIdentity, id)
 }
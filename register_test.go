@@ -7,14 +7,14 @@
 package aeu2f
 
 import (
+	"encoding/base64"
   "fmt"
   "log"
-  "time"
   "testing"
-	"encoding/base64"
+  "time"
 
-  "appengine/aetest"
-  "appengine/datastore"
+  "google.golang.org/appengine/aetest"
+  "google.golang.org/appengine/datastore"
 
 	"github.com/tstranex/u2f"
 )
@@ -50,11 +50,11 @@ var fakeRegistrationResponse = u2f.RegisterResponse{
 func TestNewChallenge(t *testing.T) {
   log.Printf("--- challenge ---")
 
-  ctx, err := aetest.NewContext(nil)
+  ctx, done, err := aetest.NewContext()
   if err != nil {
     t.Fatal(err)
   }
-  defer ctx.Close()
+  defer done()
 
   // Create new challenge
   AppID = "tnc-appid"
@@ -91,11 +91,11 @@ func TestNewChallenge(t *testing.T) {
 
 
 func TestGoodRegistration(t *testing.T) {
-  ctx, err := aetest.NewContext(nil)
+  ctx, done, err := aetest.NewContext()
   if err != nil {
     t.Fatal(err)
   }
-  defer ctx.Close()
+  defer done()
 
   var testID = "test-id-🔒"
 
@@ -135,11 +135,15 @@ func TestGoodRegistration(t *testing.T) {
   }
 
   if regi.UserIdentity != testID {
-    t.Error("Expected user identity %v to be %v", regi.UserIdentity,
+    t.Errorf("Expected user identity %v to be %v", regi.UserIdentity,
       testID)
   }
 
-  // TODO: Ensure we delete the challenge.
+  // The challenge is consumed.
+  var c u2f.Challenge
+  if err := datastore.Get(ctx, ckey, &c); err != datastore.ErrNoSuchEntity {
+    t.Errorf("Expected the challenge to be deleted, got %v", err)
+  }
 
   u2fReg := new(u2f.Registration)
   if err := u2fReg.UnmarshalBinary(regi.U2FRegistrationBytes); err != nil {
@@ -0,0 +1,43 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+type requestContextKey struct{}
+
+// WithRequest returns a copy of ctx that carries the HTTP request being
+// served, so that audit events and throttles can use the client's address
+// and user agent.  aeu2fhttp does this for every request.
+func WithRequest(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, requestContextKey{}, r)
+}
+
+// request returns the HTTP request given to WithRequest, or nil (e.g. for
+// test contexts).
+func request(ctx context.Context) *http.Request {
+	r, _ := ctx.Value(requestContextKey{}).(*http.Request)
+	return r
+}
+
+// clientIP returns the IP address of the client making the request, or "".
+func clientIP(ctx context.Context) string {
+	r := request(ctx)
+	if r == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,120 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"time"
+
+	"github.com/tstranex/u2f"
+)
+
+// Challenge kinds.
+const (
+	ChallengeRegister = "Challenge"
+	ChallengeSign     = "SignChallenge"
+)
+
+// Store persists the challenges, registrations, recovery codes, TOTPs and
+// throttles of every user.
+//
+// Implementations must be safe for concurrent use, and strongly consistent
+// for each user: a read sees every earlier write.  The methods that update
+// (CompareAndSwapCounter, UpdateRegistration, UpdateTOTP, UpdateThrottle,
+// DeleteRecoveryCode and ReplaceRecoveryCodes) must be atomic.
+// storetest.RunStoreTests checks an implementation behaves as DatastoreStore
+// does.
+type Store interface {
+	// PutChallenge stores the user's challenge of the given kind,
+	// ChallengeRegister or ChallengeSign, replacing any other.
+	PutChallenge(ctx context.Context, kind, userIdentity string, c *u2f.Challenge) error
+
+	// GetChallenge returns the user's challenge of the given kind, or
+	// ErrNoChallenge.
+	GetChallenge(ctx context.Context, kind, userIdentity string) (*u2f.Challenge, error)
+
+	// DeleteChallenge removes the user's challenge of the given kind, if
+	// there is one.
+	DeleteChallenge(ctx context.Context, kind, userIdentity string) error
+
+	// CountChallenges returns the number of challenges of the given kind,
+	// of all users, issued after since.
+	CountChallenges(ctx context.Context, kind string, since time.Time) (int, error)
+
+	// DeleteChallenges removes the challenges of the given kind, of all
+	// users, issued before before, and returns how many it removed.
+	DeleteChallenges(ctx context.Context, kind string, before time.Time) (int, error)
+
+	// PutRegistration stores a new registration, and returns its ID.
+	PutRegistration(ctx context.Context, regi *Registration) (int64, error)
+
+	// Registrations returns the user's registrations, with their IDs set.
+	Registrations(ctx context.Context, userIdentity string) ([]*Registration, error)
+
+	// UpdateRegistration applies update to the user's registration with the
+	// given ID, and stores the result unless update returns an error.  It
+	// returns the registration as stored, or ErrNoSuchRegistration.  update
+	// may be called more than once.
+	UpdateRegistration(ctx context.Context, userIdentity string, id int64, update func(*Registration) error) (*Registration, error)
+
+	// CompareAndSwapCounter sets the Counter of the registration with the
+	// given ID to counter, provided it is old.  It returns the registration
+	// as stored, and whether it was updated, or ErrNoSuchRegistration.  If
+	// the registration is revoked, it is not updated, and the error is
+	// ErrRevoked.
+	CompareAndSwapCounter(ctx context.Context, id, old, counter int64) (*Registration, bool, error)
+
+	// DeleteRegistration removes the user's registration with the given ID,
+	// or returns ErrNoSuchRegistration.
+	DeleteRegistration(ctx context.Context, userIdentity string, id int64) error
+
+	// ReplaceRecoveryCodes replaces all of the user's recovery codes.
+	ReplaceRecoveryCodes(ctx context.Context, userIdentity string, rcs []*RecoveryCode) error
+
+	// RecoveryCodes returns the user's unused recovery codes, with their IDs
+	// set.
+	RecoveryCodes(ctx context.Context, userIdentity string) ([]*RecoveryCode, error)
+
+	// DeleteRecoveryCode removes the user's recovery code with the given ID,
+	// or returns ErrNoSuchRegistration, so that a code can be used once.
+	DeleteRecoveryCode(ctx context.Context, userIdentity string, id int64) error
+
+	// PutTOTP stores a new TOTP, and returns its ID.
+	PutTOTP(ctx context.Context, totp *TOTP) (int64, error)
+
+	// TOTPs returns the user's TOTPs, with their IDs set.
+	TOTPs(ctx context.Context, userIdentity string) ([]*TOTP, error)
+
+	// UpdateTOTP applies update to the user's TOTP with the given ID, and
+	// stores the result unless update returns an error.  It returns the TOTP
+	// as stored, or ErrNoSuchRegistration.  update may be called more than
+	// once.
+	UpdateTOTP(ctx context.Context, userIdentity string, id int64, update func(*TOTP) error) (*TOTP, error)
+
+	// DeleteTOTP removes the user's TOTP with the given ID, or returns
+	// ErrNoSuchRegistration.
+	DeleteTOTP(ctx context.Context, userIdentity string, id int64) error
+
+	// GetThrottle returns the throttle with the given key, which is zero if
+	// it has not been stored.
+	GetThrottle(ctx context.Context, key string) (*Throttle, error)
+
+	// UpdateThrottle applies update to the throttle with the given key, and
+	// stores the result.  update may be called more than once.
+	UpdateThrottle(ctx context.Context, key string, update func(*Throttle)) error
+
+	// DeleteThrottle removes the throttle with the given key, if it exists.
+	DeleteThrottle(ctx context.Context, key string) error
+
+	// Users returns, sorted, every user with a registration, recovery code
+	// or TOTP.
+	Users(ctx context.Context) ([]string, error)
+}
+
+// Storage is the Store in use.  It defaults to DatastoreStore; e.g. a
+// server outside App Engine might use NewMemoryStore instead.
+var Storage Store = DatastoreStore{}
@@ -0,0 +1,197 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ThrottleFreeFailures is the number of failures a user or client IP may
+// have before being locked out.
+var ThrottleFreeFailures int64 = 5
+
+// ThrottleBaseLockout is the lockout after ThrottleFreeFailures failures; it
+// doubles with each subsequent failure, up to ThrottleMaxLockout.
+var ThrottleBaseLockout = 30 * time.Second
+
+// ThrottleMaxLockout is the longest lockout.
+var ThrottleMaxLockout = time.Hour
+
+// ThrottleResetAfter is how long after its last failure a user or client IP
+// starts afresh.
+var ThrottleResetAfter = 24 * time.Hour
+
+// Throttle counts the recent authentication failures of a user or of a
+// client IP.
+type Throttle struct {
+	Failures    int64
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// --- lockoutFor ---
+// Return the lockout after the given number of consecutive failures.
+func lockoutFor(failures int64) time.Duration {
+	if failures < ThrottleFreeFailures {
+		return 0
+	}
+
+	d := ThrottleBaseLockout
+	for i := ThrottleFreeFailures; i < failures && d < ThrottleMaxLockout; i++ {
+		d *= 2
+	}
+	if d > ThrottleMaxLockout {
+		d = ThrottleMaxLockout
+	}
+	return d
+}
+
+// --- throttleKeys ---
+// Return the keys of the throttles for the user and, if known, the client IP.
+func throttleKeys(ctx context.Context, userIdentity string) []string {
+	keys := []string{"user:" + userIdentity}
+	if ip := clientIP(ctx); ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// countedAttempt is an attempt counted against a throttle, with the
+// throttle's LockedUntil before and after, so it can be refunded.
+type countedAttempt struct {
+	key           string
+	before, after time.Time
+}
+
+// --- countAttempt ---
+// Count an attempt as a failure against each of the throttles before it is
+// verified, atomically, so that concurrent attempts cannot all slip under
+// the limit.  If any throttle is locked, refund those counted and return a
+// LockedOutError.
+func countAttempt(ctx context.Context, keys []string) (_ []countedAttempt, err error) {
+	ctx, end := startStorage(ctx, "countAttempt")
+	defer func() { end(err) }()
+
+	now := time.Now()
+	counted := []countedAttempt{}
+	for _, k := range keys {
+		var locked bool
+		c := countedAttempt{key: k}
+		err := Storage.UpdateThrottle(ctx, k, func(t *Throttle) {
+			locked = now.Before(t.LockedUntil)
+			if locked {
+				c.before = t.LockedUntil
+				return
+			}
+			if now.Sub(t.LastFailure) > ThrottleResetAfter {
+				t.Failures = 0
+			}
+			c.before = t.LockedUntil
+			t.Failures++
+			t.LastFailure = now
+			t.LockedUntil = now.Add(lockoutFor(t.Failures))
+			c.after = t.LockedUntil
+		})
+		if err == nil && locked {
+			err = &LockedOutError{c.before}
+		}
+		if err != nil {
+			if rerr := refundAttempt(ctx, counted); rerr != nil {
+				Log.Error("throttle.refund", "op", "throttle.refund", "error", rerr.Error())
+			}
+			return nil, err
+		}
+		counted = append(counted, c)
+	}
+	return counted, nil
+}
+
+// --- refundAttempt ---
+// Uncount attempts counted by countAttempt, lifting the lockout they caused
+// unless another attempt has been counted since.
+func refundAttempt(ctx context.Context, counted []countedAttempt) (err error) {
+	ctx, end := startStorage(ctx, "refundAttempt")
+	defer func() { end(err) }()
+
+	for _, c := range counted {
+		err := Storage.UpdateThrottle(ctx, c.key, func(t *Throttle) {
+			if t.Failures > 0 {
+				t.Failures--
+			}
+			if t.LockedUntil.Equal(c.after) {
+				t.LockedUntil = c.before
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- resetThrottle ---
+// Clear the failures counted by a throttle.
+func resetThrottle(ctx context.Context, key string) (err error) {
+	ctx, end := startStorage(ctx, "resetThrottle")
+	defer func() { end(err) }()
+
+	return Storage.DeleteThrottle(ctx, key)
+}
+
+// --- throttled ---
+// Run verify unless the user or client is locked out.  The attempt is
+// counted as a failure first, then refunded unless verify returns a
+// VerificationError; on success the user's failures are cleared.
+func throttled(ctx context.Context, userIdentity string, verify func() error) error {
+	counted, err := countAttempt(ctx, throttleKeys(ctx, userIdentity))
+	if err != nil {
+		return err
+	}
+
+	err = verify()
+	var verr *VerificationError
+	if errors.As(err, &verr) {
+		return err
+	}
+
+	refund := counted
+	if err == nil {
+		if terr := resetThrottle(ctx, counted[0].key); terr != nil {
+			Log.Error("throttle.reset", "op", "throttle.reset", "user", logUser(userIdentity), "error", terr.Error())
+		}
+		refund = counted[1:]
+	}
+	if terr := refundAttempt(ctx, refund); terr != nil {
+		Log.Error("throttle.refund", "op", "throttle.refund", "user", logUser(userIdentity), "error", terr.Error())
+	}
+	return err
+}
+
+
+// ClearLockout resets the failures and any lockout of a user, e.g. once an
+// administrator has confirmed their identity.
+func ClearLockout(ctx context.Context, userIdentity string) error {
+	if err := resetThrottle(ctx, "user:"+userIdentity); err != nil {
+		return err
+	}
+
+	Log.Info("throttle.clear", "op", "throttle.clear", "user", logUser(userIdentity))
+	return nil
+}
+
+
+// ClearIPLockout resets the failures and any lockout of a client IP.
+func ClearIPLockout(ctx context.Context, ip string) error {
+	if err := resetThrottle(ctx, "ip:"+ip); err != nil {
+		return err
+	}
+
+	Log.Info("throttle.clear", "op", "throttle.clear", "ip", ip)
+	return nil
+}
@@ -0,0 +1,132 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/aetest"
+
+	"github.com/tstranex/u2f"
+)
+
+func TestLockoutFor(t *testing.T) {
+	for failures, want := range map[int64]time.Duration{
+		0:  0,
+		4:  0,
+		5:  30 * time.Second,
+		6:  time.Minute,
+		8:  4 * time.Minute,
+		12: time.Hour,
+		99: time.Hour,
+	} {
+		if got := lockoutFor(failures); got != want {
+			t.Errorf("lockoutFor(%v) = %v, expected %v", failures, got, want)
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	var testID = "test-id-🔒"
+	keys := throttleKeys(ctx, testID)
+
+	for i := int64(1); i < ThrottleFreeFailures; i++ {
+		if _, err := countAttempt(ctx, keys); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := countAttempt(ctx, keys); err != nil {
+		t.Fatalf("Expected no lockout yet, got %v", err)
+	}
+
+	// Locked out before the response is even looked at.
+	_, err = Authenticate(ctx, testID, u2f.SignResponse{})
+	lerr, ok := err.(*LockedOutError)
+	if !ok {
+		t.Fatalf("Expected a LockedOutError, got %v", err)
+	}
+	if d := lerr.Until.Sub(time.Now()); d <= 0 || d > ThrottleBaseLockout {
+		t.Errorf("Unexpected lockout of %v", d)
+	}
+
+	if err := ClearLockout(ctx, testID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := countAttempt(ctx, keys); err != nil {
+		t.Errorf("Expected the lockout to be cleared, got %v", err)
+	}
+}
+
+func TestThrottledRefund(t *testing.T) {
+	old := Storage
+	defer func() { Storage = old }()
+	Storage = NewMemoryStore()
+	r := httptest.NewRequest("POST", "/", nil)
+	ctx := WithRequest(context.Background(), r)
+	keys := throttleKeys(ctx, "bob")
+
+	failures := func() (n []int64) {
+		for _, k := range keys {
+			th, err := Storage.GetThrottle(ctx, k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n = append(n, th.Failures)
+		}
+		return n
+	}
+	fail := func() error { return &VerificationError{"test", errors.New("bad")} }
+
+	// Verification failures count, other errors are refunded, and success
+	// clears the user's failures and refunds the client's.
+	for i, tc := range []struct {
+		verify func() error
+		want   []int64
+	}{
+		{fail, []int64{1, 1}},
+		{fail, []int64{2, 2}},
+		{func() error { return ErrNoChallenge }, []int64{2, 2}},
+		{func() error { return nil }, []int64{0, 2}},
+	} {
+		throttled(ctx, "bob", tc.verify)
+		if got := failures(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: expected failures %v, got %v", i, tc.want, got)
+		}
+	}
+
+	// The attempt is counted while it is verified, so that concurrent
+	// attempts see it.
+	throttled(ctx, "bob", func() error {
+		if got := failures(); !reflect.DeepEqual(got, []int64{1, 3}) {
+			t.Errorf("Expected the attempt counted during verify, got %v", got)
+		}
+		return nil
+	})
+
+	// A success that reaches the lockout threshold does not leave the
+	// client locked out.
+	oldFree := ThrottleFreeFailures
+	defer func() { ThrottleFreeFailures = oldFree }()
+	ThrottleFreeFailures = 3
+	if err := throttled(ctx, "bob", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := throttled(ctx, "bob", func() error { return nil }); err != nil {
+		t.Errorf("Expected no lockout after a success, got %v", err)
+	}
+}
@@ -0,0 +1,309 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TOTP (RFC 6238) parameters.  These are the defaults of authenticator apps,
+// some of which ignore any others.
+const (
+	totpPeriod = 30 // seconds
+	totpDigits = 6
+)
+
+// TOTPSkew is the number of 30 second steps either side of the current one
+// within which a code is accepted, to allow for clock drift.
+var TOTPSkew int64 = 1
+
+// TOTP stores an authenticator app enrolled as a second factor, alongside
+// the user's U2F Registrations.
+type TOTP struct {
+	// ID is the Store's ID of the TOTP; it is not stored.
+	ID int64 `datastore:"-"`
+
+	UserIdentity string
+	Secret       []byte `datastore:",noindex" json:"-"`
+
+	// Confirmed is set once the user has proven the app is set up, by
+	// entering a code.
+	Confirmed bool
+
+	// LastStep is the time step of the last accepted code; a code must be
+	// for a later step, so that it cannot be replayed.
+	LastStep int64 `json:"-"`
+	Created  time.Time
+}
+
+// TOTPEnrollment is what the user needs to set up an authenticator app.
+type TOTPEnrollment struct {
+	ID int64
+
+	// Secret is the base32 secret, for manual entry.
+	Secret string
+
+	// URI is the otpauth:// URI, usually shown as a QR code.
+	URI string
+}
+
+// Factor is a second factor of either kind, as returned by ListFactors.
+type Factor struct {
+	// Type is FactorU2F or FactorTOTP.
+	Type    string
+	ID      int64
+	Created time.Time
+}
+
+// Factor types.
+const (
+	FactorU2F  = "u2f"
+	FactorTOTP = "totp"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// --- totpCode ---
+// Return the RFC 4226 HOTP code for the given secret and counter, which for
+// TOTP is the time step.
+func totpCode(secret []byte, step int64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+// --- totpMatch ---
+// Return the step within TOTPSkew of now for which code is valid, or -1.
+func totpMatch(secret []byte, code string, now time.Time) int64 {
+	current := now.Unix() / totpPeriod
+	for step := current - TOTPSkew; step <= current+TOTPSkew; step++ {
+		expected := totpCode(secret, step, totpDigits)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return -1
+}
+
+// --- totpURI ---
+//
+func totpURI(issuer, userIdentity string, secret []byte) string {
+	label := url.PathEscape(userIdentity)
+	v := url.Values{}
+	v.Set("secret", totpEncoding.EncodeToString(secret))
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	if issuer != "" {
+		label = url.PathEscape(issuer) + ":" + label
+		v.Set("issuer", issuer)
+	}
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// loadTOTPs returns the TOTPs for a given user identity, with their ID set.
+func loadTOTPs(ctx context.Context, userIdentity string) (_ []*TOTP, err error) {
+	ctx, end := startStorage(ctx, "loadTOTPs")
+	defer func() { end(err) }()
+
+	return Storage.TOTPs(ctx, userIdentity)
+}
+
+
+// NewTOTPEnrollment generates a secret for a new authenticator app.  It must
+// be confirmed with ConfirmTOTP before it can be used.  The issuer, e.g. the
+// name of the application, is shown in the app next to the user identity.
+//
+// It replaces any of the user's enrollments that are not yet confirmed, so
+// abandoned ones do not accumulate.
+func NewTOTPEnrollment(ctx context.Context, userIdentity, issuer string) (*TOTPEnrollment, error) {
+	// 160 bits, as recommended by RFC 4226.
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("rand.Read error: %v", err)
+	}
+
+	old, err := loadTOTPs(ctx, userIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("loadTOTPs error %+v", err)
+	}
+
+	totp := TOTP{UserIdentity: userIdentity, Secret: secret, Created: time.Now()}
+	id, err := Storage.PutTOTP(ctx, &totp)
+	if err != nil {
+		return nil, err
+	}
+
+	// The new enrollment is stored before the old ones are removed, so a
+	// failure leaves the user with one, at least.
+	for _, o := range old {
+		if o.Confirmed {
+			continue
+		}
+		err := Storage.DeleteTOTP(ctx, userIdentity, o.ID)
+		if err != nil && err != ErrNoSuchRegistration {
+			return nil, fmt.Errorf("DeleteTOTP error %+v", err)
+		}
+	}
+
+	Log.Info("totp.enroll", "op", "totp.enroll", "user", logUser(userIdentity), "totp", id)
+	return &TOTPEnrollment{
+		ID:     id,
+		Secret: totpEncoding.EncodeToString(secret),
+		URI:    totpURI(issuer, userIdentity, secret),
+	}, nil
+}
+
+
+// --- useTOTP ---
+// Accept code for the TOTP with the given ID, which must be confirmed or not
+// as given, atomically so that a code cannot be used twice.
+func useTOTP(ctx context.Context, id int64, userIdentity, code string, confirmed bool) (_ *TOTP, err error) {
+	ctx, end := startStorage(ctx, "useTOTP")
+	defer func() { end(err) }()
+
+	return Storage.UpdateTOTP(ctx, userIdentity, id, func(totp *TOTP) error {
+		if totp.Confirmed != confirmed {
+			return ErrNoSuchRegistration
+		}
+
+		step := totpMatch(totp.Secret, code, time.Now())
+		if step < 0 {
+			return &VerificationError{"TOTP", errors.New("invalid code")}
+		}
+		if step <= totp.LastStep {
+			return &VerificationError{"TOTP", errors.New("code already used")}
+		}
+
+		totp.LastStep = step
+		totp.Confirmed = true
+		return nil
+	})
+}
+
+
+// ConfirmTOTP completes the enrollment with the given ID, given a code from
+// the newly set up app.  Failures are throttled as for Sign.
+func ConfirmTOTP(ctx context.Context, userIdentity string, id int64, code string) error {
+	err := throttled(ctx, userIdentity, func() error {
+		_, err := useTOTP(ctx, id, userIdentity, strings.TrimSpace(code), false)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	Log.Info("totp.confirm", "op", "totp.confirm", "user", logUser(userIdentity), "totp", id)
+	return nil
+}
+
+
+// VerifyTOTP verifies a code from any of the user's confirmed authenticator
+// apps, as an alternative to Sign, and returns the TOTP it was from.  Each
+// code is accepted at most once, and failures are throttled as for Sign.
+func VerifyTOTP(ctx context.Context, userIdentity, code string) (*TOTP, error) {
+	var totp *TOTP
+	ctx, start := begin(ctx, AuditTOTP, userIdentity)
+	err := throttled(ctx, userIdentity, func() (err error) {
+		totp, err = verifyTOTP(ctx, userIdentity, code)
+		return err
+	})
+
+	if totp != nil {
+		finish(ctx, AuditTOTP, userIdentity, totp.ID, start, err)
+	} else {
+		finish(ctx, AuditTOTP, userIdentity, 0, start, err)
+	}
+	return totp, err
+}
+
+// --- verifyTOTP ---
+//
+func verifyTOTP(ctx context.Context, userIdentity, code string) (*TOTP, error) {
+	code = strings.TrimSpace(code)
+
+	totps, err := loadTOTPs(ctx, userIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("loadTOTPs error %+v", err)
+	}
+
+	for _, totp := range totps {
+		if !totp.Confirmed || totpMatch(totp.Secret, code, time.Now()) < 0 {
+			continue
+		}
+		return useTOTP(ctx, totp.ID, userIdentity, code, true)
+	}
+
+	return nil, &VerificationError{"TOTP", errors.New("invalid code")}
+}
+
+
+// DeleteTOTP removes the TOTP with the given ID, provided it belongs to the
+// given user.
+func DeleteTOTP(ctx context.Context, userIdentity string, id int64) (err error) {
+	ctx, start := begin(ctx, AuditTOTPDeletion, userIdentity)
+	defer func() { finish(ctx, AuditTOTPDeletion, userIdentity, id, start, err) }()
+
+	return Storage.DeleteTOTP(ctx, userIdentity, id)
+}
+
+
+// ListFactors returns all of the user's usable second factors: U2F
+// registrations that are not revoked, and confirmed authenticator apps,
+// oldest first.
+func ListFactors(ctx context.Context, userIdentity string) ([]Factor, error) {
+	regis, err := ListRegistrations(ctx, userIdentity)
+	if err != nil {
+		return nil, err
+	}
+
+	totps, err := loadTOTPs(ctx, userIdentity)
+	if err != nil {
+		return nil, err
+	}
+
+	factors := []Factor{}
+	for _, regi := range regis {
+		if regi.Revoked.IsZero() {
+			factors = append(factors, Factor{FactorU2F, regi.ID, regi.Created})
+		}
+	}
+	for _, totp := range totps {
+		if totp.Confirmed {
+			factors = append(factors, Factor{FactorTOTP, totp.ID, totp.Created})
+		}
+	}
+
+	sort.SliceStable(factors, func(i, j int) bool {
+		return factors[i].Created.Before(factors[j].Created)
+	})
+	return factors, nil
+}
@@ -0,0 +1,183 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// RFC 6238, Appendix B, for SHA1.
+func TestTOTPCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	for unix, want := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	} {
+		if got := totpCode(secret, unix/totpPeriod, 8); got != want {
+			t.Errorf("totpCode at %v = %v, expected %v", unix, got, want)
+		}
+	}
+}
+
+func TestTOTPMatch(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+
+	for offset, ok := range map[int64]bool{-2: false, -1: true, 0: true, 1: true, 2: false} {
+		code := totpCode(secret, step+offset, totpDigits)
+		if got := totpMatch(secret, code, now); (got == step+offset) != ok {
+			t.Errorf("Step offset %v: expected match %v, got step %v", offset, ok, got)
+		}
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	u, err := url.Parse(totpURI("ACME Co", "bob@example.com", []byte("12345678901234567890")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/ACME Co:bob@example.com" {
+		t.Errorf("Unexpected URI %v", u)
+	}
+	if q := u.Query(); q.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || q.Get("issuer") != "ACME Co" {
+		t.Errorf("Unexpected query %v", q)
+	}
+}
+
+func TestTOTPEnrollment(t *testing.T) {
+	ctx, done := newConsistentContext(t)
+	defer done()
+
+	var testID = "test-id-🔒"
+
+	e, err := NewTOTPEnrollment(ctx, testID, "aeu2f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := totpEncoding.DecodeString(e.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := func(offset int64) string {
+		return totpCode(secret, time.Now().Unix()/totpPeriod+offset, totpDigits)
+	}
+
+	// Unconfirmed apps are not factors, and cannot verify.
+	if _, err := VerifyTOTP(ctx, testID, code(0)); err == nil {
+		t.Error("Expected an unconfirmed TOTP to be rejected")
+	}
+	if factors, _ := ListFactors(ctx, testID); len(factors) != 0 {
+		t.Errorf("Expected no factors, got %+v", factors)
+	}
+
+	if err := ConfirmTOTP(ctx, testID, e.ID, code(-1)); err != nil {
+		t.Fatalf("ConfirmTOTP: %v", err)
+	}
+	if err := ConfirmTOTP(ctx, testID, e.ID, code(0)); err != ErrNoSuchRegistration {
+		t.Errorf("Expected a second confirmation to fail, got %v", err)
+	}
+
+	totp, err := VerifyTOTP(ctx, testID, code(0))
+	if err != nil {
+		t.Fatalf("VerifyTOTP: %v", err)
+	}
+	if totp.ID != e.ID {
+		t.Errorf("Expected TOTP %v, got %v", e.ID, totp.ID)
+	}
+
+	// Replay, and an older code.
+	for _, c := range []string{code(0), code(-1)} {
+		if _, err := VerifyTOTP(ctx, testID, c); err == nil {
+			t.Errorf("Expected code %v to be rejected", c)
+		}
+	}
+
+	factors, err := ListFactors(ctx, testID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(factors) != 1 || factors[0].Type != FactorTOTP || factors[0].ID != e.ID {
+		t.Errorf("Unexpected factors %+v", factors)
+	}
+
+	if err := DeleteTOTP(ctx, "someone-else", e.ID); err != ErrNoSuchRegistration {
+		t.Errorf("Expected ErrNoSuchRegistration, got %v", err)
+	}
+	if err := DeleteTOTP(ctx, testID, e.ID); err != nil {
+		t.Errorf("DeleteTOTP: %v", err)
+	}
+}
+
+func TestTOTPEnrollmentReplacesUnconfirmed(t *testing.T) {
+	ctx, done := newConsistentContext(t)
+	defer done()
+
+	confirmed, err := NewTOTPEnrollment(ctx, "bob", "aeu2f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := totpEncoding.DecodeString(confirmed.Secret)
+	if err := ConfirmTOTP(ctx, "bob", confirmed.ID, totpCode(secret, time.Now().Unix()/totpPeriod, totpDigits)); err != nil {
+		t.Fatal(err)
+	}
+
+	var last *TOTPEnrollment
+	for i := 0; i < 3; i++ {
+		if last, err = NewTOTPEnrollment(ctx, "bob", "aeu2f"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	totps, err := loadTOTPs(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[int64]bool{}
+	for _, totp := range totps {
+		ids[totp.ID] = true
+	}
+	if len(ids) != 2 || !ids[confirmed.ID] || !ids[last.ID] {
+		t.Errorf("Expected the confirmed and the last enrollment, %v and %v, got %v", confirmed.ID, last.ID, ids)
+	}
+}
+
+func TestListFactorsRevoked(t *testing.T) {
+	old := Storage
+	defer func() { Storage = old }()
+	Storage = NewMemoryStore()
+	ctx := context.Background()
+
+	var ids []int64
+	for i := 0; i < 2; i++ {
+		id, err := Storage.PutRegistration(ctx, &Registration{UserIdentity: "bob", Created: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if _, err := Storage.UpdateRegistration(ctx, "bob", ids[0], func(regi *Registration) error {
+		regi.Revoked = time.Now()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	factors, err := ListFactors(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(factors) != 1 || factors[0].ID != ids[1] {
+		t.Errorf("Expected only registration %v, got %+v", ids[1], factors)
+	}
+}
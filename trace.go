@@ -0,0 +1,88 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer makes the OpenTelemetry spans of each operation, storage call and
+// U2F verification step, as children of the span in the caller's context.
+// When nil, the global TracerProvider is used, which does nothing until one
+// is installed with otel.SetTracerProvider.
+//
+// Span attributes are "aeu2f.user" (always hashed), "aeu2f.registrations",
+// "aeu2f.registration", "aeu2f.outcome" and "aeu2f.error".
+var Tracer trace.Tracer
+
+// instrumentationName names the global tracer.
+const instrumentationName = "github.com/brianmhunt/aeu2f-go"
+
+// --- tracer ---
+//
+func tracer() trace.Tracer {
+	if Tracer != nil {
+		return Tracer
+	}
+	return otel.Tracer(instrumentationName)
+}
+
+// --- begin ---
+// Start the span of an operation, returning the context for its calls, and
+// its start time, both for finish.
+func begin(ctx context.Context, op, userIdentity string) (context.Context, time.Time) {
+	ctx, _ = tracer().Start(ctx, "aeu2f."+op,
+		trace.WithAttributes(attribute.String("aeu2f.user", hashUser(userIdentity))))
+	return ctx, time.Now()
+}
+
+// --- startSpan ---
+// Start a span for a step of an operation, returning the function that ends
+// it with the step's error.
+func startSpan(ctx context.Context, name string) (context.Context, func(error)) {
+	ctx, span := tracer().Start(ctx, "aeu2f."+name)
+	return ctx, func(err error) { endSpan(span, err) }
+}
+
+// --- startStorage ---
+// Start the span and timer of a storage call, returning the function that
+// ends them with the call's error.
+func startStorage(ctx context.Context, call string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, end := startSpan(ctx, "storage."+call)
+	return ctx, func(err error) {
+		storageDuration.observe(time.Since(start).Seconds(), call)
+		end(err)
+	}
+}
+
+// --- endSpan ---
+// Record the outcome of a span, and end it.
+func endSpan(span trace.Span, err error) {
+	if err == nil {
+		span.SetAttributes(attribute.String("aeu2f.outcome", AuditSuccess))
+	} else {
+		span.SetAttributes(
+			attribute.String("aeu2f.outcome", AuditFailure),
+			attribute.String("aeu2f.error", errorType(err)))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, errorType(err))
+	}
+	span.End()
+}
+
+// --- traceRegistrations ---
+// Record on the current span how many registrations a user has.
+func traceRegistrations(ctx context.Context, n int) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("aeu2f.registrations", n))
+}
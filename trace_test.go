@@ -0,0 +1,107 @@
+//
+// AppEngine Universal 2 Factor
+// (aeutf)
+//
+// License: MIT
+//
+package aeu2f
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/appengine/aetest"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans sends the package's spans to a recorder, until the returned
+// function is called.
+func recordSpans() (*tracetest.SpanRecorder, func()) {
+	rec := tracetest.NewSpanRecorder()
+	old := Tracer
+	Tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)).Tracer("test")
+	return rec, func() { Tracer = old }
+}
+
+// spanAttr returns the value of a span attribute, or "" when it is missing.
+func spanAttr(s sdktrace.ReadOnlySpan, key string) string {
+	for _, kv := range s.Attributes() {
+		if kv.Key == attribute.Key(key) {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestStorageSpan(t *testing.T) {
+	rec, restore := recordSpans()
+	defer restore()
+
+	ctx, parent := Tracer.Start(context.Background(), "caller")
+	_, end := startStorage(ctx, "getChallenge")
+	end(errors.New("datastore down"))
+	parent.End()
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %v", len(spans))
+	}
+	s := spans[0]
+	if s.Name() != "aeu2f.storage.getChallenge" {
+		t.Errorf("Unexpected span name %v", s.Name())
+	}
+	if s.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("Expected the storage span to be a child of the caller's")
+	}
+	if spanAttr(s, "aeu2f.outcome") != AuditFailure || spanAttr(s, "aeu2f.error") != "internal" {
+		t.Errorf("Unexpected attributes %v", s.Attributes())
+	}
+}
+
+func TestOperationSpans(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	rec, restore := recordSpans()
+	defer restore()
+
+	ctx, parent := Tracer.Start(ctx, "caller")
+	if _, err := NewSignChallenge(ctx, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range rec.Ended() {
+		spans[s.Name()] = s
+	}
+
+	op := spans["aeu2f."+AuditSignChallenge]
+	if op == nil {
+		t.Fatalf("Expected an operation span, got %v", spans)
+	}
+	if op.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("Expected the operation span to be a child of the caller's")
+	}
+	if spanAttr(op, "aeu2f.user") != hashUser("bob") ||
+		spanAttr(op, "aeu2f.registrations") != "0" ||
+		spanAttr(op, "aeu2f.outcome") != AuditSuccess {
+		t.Errorf("Unexpected attributes %v", op.Attributes())
+	}
+
+	for _, call := range []string{"loadRegistrations", "putChallenge"} {
+		s := spans["aeu2f.storage."+call]
+		if s == nil {
+			t.Errorf("Expected a span for %v", call)
+		} else if s.Parent().SpanID() != op.SpanContext().SpanID() {
+			t.Errorf("Expected the %v span to be a child of the operation's", call)
+		}
+	}
+}